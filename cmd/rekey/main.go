@@ -0,0 +1,72 @@
+// Command rekey moves every stored file to the primary key of
+// STORAGE_ENCRYPTION_KEYS by wrapping its data key again, without decrypting
+// its contents. Run it after putting a new key first in the keyring; once it
+// reports no failures the old key can be removed. Files stored before
+// encryption was turned on are encrypted as well.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/repository/dbrepo"
+	"pawprintpublic/internal/storage"
+)
+
+func main() {
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if err := run(infoLog, errorLog); err != nil {
+		errorLog.Fatal(err)
+	}
+}
+
+func run(infoLog, errorLog *log.Logger) error {
+	ctx := context.Background()
+	files, err := storage.FromEnv(ctx)
+	if err != nil {
+		return err
+	}
+	encrypted, ok := files.(*storage.Encrypted)
+	if !ok {
+		return errors.New("set STORAGE_ENCRYPTION_KEYS or STORAGE_ENCRYPTION_KEYS_FILE to rekey stored files")
+	}
+
+	db, err := driver.ConnectFromEnv()
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	repo := dbrepo.NewRepo(db, &config.AppConfig{InfoLog: infoLog, ErrorLog: errorLog})
+	stored, err := repo.GetAllFiles()
+	if err != nil {
+		return err
+	}
+
+	var rekeyed, failed int
+	for _, f := range stored {
+		changed, err := encrypted.Rekey(ctx, f.ObjectKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			errorLog.Printf("Cannot rekey %s: %v", f.ObjectKey, err)
+			failed++
+			continue
+		}
+		if changed {
+			rekeyed++
+		}
+	}
+
+	infoLog.Printf("Rekeyed %d of %d files; %d failed", rekeyed, len(stored), failed)
+	if failed > 0 {
+		return errors.New("some files still use an old key; keep it in the keyring")
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"log"
@@ -11,10 +12,15 @@ import (
 	"pawprintpublic/internal/driver"
 	"pawprintpublic/internal/handlers"
 	"pawprintpublic/internal/helpers"
+	"pawprintpublic/internal/jobs"
 	"pawprintpublic/internal/mailer"
+	"pawprintpublic/internal/migrations"
 	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/notify"
+	"pawprintpublic/internal/progress"
 	"pawprintpublic/internal/render"
-	"strings"
+	"pawprintpublic/internal/retention"
+	"pawprintpublic/internal/storage"
 	"sync"
 	"time"
 
@@ -28,6 +34,14 @@ var errorLog *log.Logger
 
 // main is the main application function
 func main() {
+	// Manage the schema without starting the application
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Println("Basic stdout test")
 
 	inProduction := true
@@ -109,57 +123,35 @@ func run(cfg config.Config) (*driver.DB, error) {
 	app.Wait = &sync.WaitGroup{}
 	app.ErrorChan = make(chan error)
 	app.ErrorChanDone = make(chan bool)
-	app.TaskManager = diplomapdfs.NewTaskManager()
-
-	// Read database connection parameters from environment variables
-	host := os.Getenv("POSTGRES_HOST")
-	if host == "" {
-		host = "localhost"
-	}
-
-	port := os.Getenv("POSTGRES_PORT")
-	if port == "" {
-		port = "5432"
-	}
-
-	user := os.Getenv("POSTGRES_USER")
-	if user == "" {
-		user = "postgres"
-	}
 
-	password := os.Getenv("POSTGRES_PASSWORD")
-	if password == "" {
-		passwordFile := os.Getenv("POSTGRES_PASSWORD_FILE")
-		if passwordFile != "" {
-			content, err := os.ReadFile(passwordFile)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read password file: %v", err)
-			}
-			password = strings.TrimSpace(string(content))
-		}
+	// Connect to the database chosen by DB_DRIVER
+	log.Println("Connecting to database...")
+	db, err := driver.ConnectFromEnv()
+	if err != nil {
+		log.Println("Cannot connect to database! Exiting...")
+		return nil, err
 	}
+	log.Println("Connected to database!")
 
-	dbName := os.Getenv("POSTGRES_DB")
-	if dbName == "" {
-		dbName = "postgres"
+	// Keep uploaded and generated files in the configured storage
+	app.Storage, err = storage.FromEnv(context.Background())
+	if err != nil {
+		log.Println("Cannot open file storage")
+		return nil, err
 	}
 
-	sslmode := os.Getenv("POSTGRES_SSLMODE")
-	if sslmode == "" {
-		sslmode = "disable"
+	// Bring the schema up to date, refusing one newer than this binary. A
+	// migration moves file contents out of the database into storage.
+	migrator, err := migrations.New(db, app.InfoLog)
+	if err != nil {
+		return nil, err
 	}
-
-	// Construct DSN
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", host, port, user, password, dbName, sslmode)
-
-	// Connect to database
-	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL(dsn)
+	migrator.Storage = app.Storage
+	err = migrator.OnStartup(context.Background())
 	if err != nil {
-		log.Println("Cannot connect to database! Exiting...")
+		log.Println("Cannot migrate the database schema")
 		return nil, err
 	}
-	log.Println("Connected to database!")
 
 	// Initialize Template Cache
 	tc, err := render.CreateTemplateCache()
@@ -170,19 +162,13 @@ func run(cfg config.Config) (*driver.DB, error) {
 	app.TemplateCache = tc
 
 	// Initialize Mailer Configuration
-	mailerConfig := mailer.MailConfig{
-		Domain:      "localhost",
-		Host:        "localhost",
-		Port:        1025,
-		Username:    "",
-		Password:    "",
-		Encryption:  "none",
-		FromAddress: "info@mycompany.com",
-		FromName:    "Info",
-		Wait:        app.Wait,
-		InfoLog:     app.InfoLog,
-		ErrorLog:    app.ErrorLog,
+	mailerConfig, err := mailer.ConfigFromEnv()
+	if err != nil {
+		return nil, err
 	}
+	mailerConfig.Wait = app.Wait
+	mailerConfig.InfoLog = app.InfoLog
+	mailerConfig.ErrorLog = app.ErrorLog
 
 	// Initialize Mailer
 	app.Mailer = mailer.CreateMail(mailerConfig)
@@ -191,6 +177,53 @@ func run(cfg config.Config) (*driver.DB, error) {
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 
+	// Track tasks in the database
+	app.TaskExecution, err = jobs.ExecutionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	maxTasks, err := jobs.MaxConcurrentFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	app.TaskManager = diplomapdfs.NewTaskManager(repo.DB, maxTasks)
+
+	// Follow task progress recorded by any process, so any replica can stream
+	// it. SQLite cannot announce progress, so only the tasks run here wake the
+	// progress streams and the rest are picked up when the streams poll.
+	var broker progress.Broker = progress.NewLocalBroker()
+	if db.Driver == driver.Postgres {
+		broker, err = progress.NewPostgresBroker(db.DSN, app.ErrorLog)
+		if err != nil {
+			log.Println("Cannot listen for task progress")
+			return nil, err
+		}
+	}
+	app.Progress = broker
+	app.TaskManager.SetNotifier(broker)
+
+	// Email uploaders when the tasks run here finish, with signed links that
+	// the web tier checks whichever process sent them
+	completion, err := notify.FromEnv(repo.DB, &app.Mailer, app.ErrorLog)
+	if err != nil {
+		return nil, err
+	}
+	app.Links = completion.Signer
+	app.BaseURL = completion.BaseURL
+	app.TaskManager.OnFinish(completion.TaskFinished)
+
+	// Tasks run here are lost when the process stops. They send heartbeats,
+	// and tasks whose process stopped sending them are failed, since nothing
+	// will finish them. Tasks left in the jobs table belong to the workers.
+	if app.TaskExecution == jobs.ExecutionLocal {
+		go watchTasks(taskHeartbeatInterval, taskStaleAfter)
+	}
+
+	// Remove files past the retention of their type, except for tasks on hold
+	app.Retention, err = retention.FromEnv()
+	if err != nil {
+		return nil, err
+	}
 	repo.StartCleanupJob()
 
 	render.NewRenderer(&app)
@@ -198,3 +231,33 @@ func run(cfg config.Config) (*driver.DB, error) {
 
 	return db, nil
 }
+
+// How often the tasks run here send heartbeats, and how long a task may go
+// without one before it is taken for interrupted
+const (
+	taskHeartbeatInterval = 30 * time.Second
+	taskStaleAfter        = 2 * time.Minute
+)
+
+// watchTasks sends heartbeats for the tasks this process runs and fails the
+// tasks of processes that have stopped, including this one before a restart
+func watchTasks(interval, staleAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if err := app.TaskManager.Heartbeat(now); err != nil {
+			app.ErrorLog.Println("Error sending task heartbeats:", err)
+		}
+
+		interrupted, err := app.TaskManager.RecoverInterrupted(now.Add(-staleAfter))
+		if err != nil {
+			app.ErrorLog.Println("Error recovering interrupted tasks:", err)
+		} else if interrupted > 0 {
+			app.InfoLog.Printf("Marked %d interrupted tasks as failed", interrupted)
+		}
+
+		<-ticker.C
+	}
+}
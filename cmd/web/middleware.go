@@ -2,13 +2,19 @@ package main
 
 import (
 	"bufio"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"time"
 
 	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/handlers"
 	"pawprintpublic/internal/helpers"
+	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/render"
+	"pawprintpublic/internal/roles"
 
 	"github.com/justinas/nosurf"
 )
@@ -151,6 +157,9 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// Auth sends visitors who are not signed in to log in. The user is looked up
+// on every request, so a user who has been deactivated or deleted is signed
+// out at once, and a change of role applies from the next request.
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthenticated(r) {
@@ -158,6 +167,42 @@ func Auth(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+
+		user, err := handlers.Repo.DB.GetUserByID(session.GetInt(r.Context(), "user_id"))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !user.Active) {
+			_ = session.Destroy(r.Context())
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if session.GetInt(r.Context(), "access_level") != user.AccessLevel {
+			session.Put(r.Context(), "access_level", user.AccessLevel)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole refuses signed in users whose role does not include role with
+// the 403 page. It belongs inside Auth, which sends everyone else to log in.
+func RequireRole(role roles.Role) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			current := helpers.UserRole(r)
+			if !current.Includes(role) {
+				app.InfoLog.Printf("Refused %s %s to user %d, a %v", r.Method, r.URL.Path, session.GetInt(r.Context(), "user_id"), current)
+
+				data := make(map[string]interface{})
+				data["required"] = role
+				w.WriteHeader(http.StatusForbidden)
+				_ = render.Template(w, r, "forbidden.page.tmpl", &models.TemplateData{
+					Data: data,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,8 +1,23 @@
 package main
 
 import (
+	"context"
+	"html/template"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/handlers"
+	"pawprintpublic/internal/helpers"
+	"pawprintpublic/internal/migrations"
+	"pawprintpublic/internal/render"
+	"pawprintpublic/internal/repository"
+	"pawprintpublic/internal/roles"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -42,3 +57,154 @@ func TestAuth(t *testing.T) {
 		t.Errorf("type is not http.Handler, but is %T", v)
 	}
 }
+
+// setupAuthTest points the handlers at a new, migrated SQLite database, whose
+// only user is the seeded admin, user 1
+func setupAuthTest(t *testing.T) repository.DatabaseRepo {
+	t.Helper()
+
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "pawprint.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	migrator, err := migrations.New(db, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	session = scs.New()
+	app.Session = session
+	app.InfoLog = log.New(io.Discard, "", 0)
+	app.ErrorLog = log.New(io.Discard, "", 0)
+	app.UseCache = true
+	app.TemplateCache = map[string]*template.Template{}
+	helpers.NewHelpers(&app)
+	render.NewRenderer(&app)
+
+	repo := handlers.NewRepo(&app, db)
+	handlers.NewHandlers(repo)
+	return repo.DB
+}
+
+// signedInRequest returns a request in a session of user id
+func signedInRequest(t *testing.T, id int) *http.Request {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ctx, err := session.Load(req.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Put(ctx, "user_id", id)
+	return req.WithContext(ctx)
+}
+
+func TestAuthSignsOutInactiveUsers(t *testing.T) {
+	db := setupAuthTest(t)
+
+	rr := httptest.NewRecorder()
+	Auth(&myHandler{}).ServeHTTP(rr, signedInRequest(t, 1))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected an active user to get %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	if err := db.SetUserActive(1, false); err != nil {
+		t.Fatal(err)
+	}
+	req := signedInRequest(t, 1)
+	rr = httptest.NewRecorder()
+	Auth(&myHandler{}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/login" {
+		t.Errorf("expected a deactivated user to be sent to log in, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if helpers.IsAuthenticated(req) {
+		t.Error("expected a deactivated user to be signed out")
+	}
+
+	rr = httptest.NewRecorder()
+	Auth(&myHandler{}).ServeHTTP(rr, signedInRequest(t, 9))
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected a deleted user to be sent to log in, got %d", rr.Code)
+	}
+}
+
+func TestAuthAppliesRoleChanges(t *testing.T) {
+	db := setupAuthTest(t)
+	h := Auth(RequireRole(roles.Admin)(&myHandler{}))
+
+	// The admin signs in, then is demoted while signed in
+	req := signedInRequest(t, 1)
+	session.Put(req.Context(), "access_level", int(roles.Admin))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected an admin to get %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	admin, err := db.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.AccessLevel = int(roles.Viewer)
+	if err := db.UpdateUser(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected a demoted admin to get %d but got %d", http.StatusForbidden, rr.Code)
+	}
+	if got := helpers.UserRole(req); got != roles.Viewer {
+		t.Errorf("expected the session to hold the new role, got %v", got)
+	}
+}
+
+// data for the RequireRole tests, by the access level in the session
+var requireRoleTests = []struct {
+	name               string
+	accessLevel        int
+	required           roles.Role
+	expectedStatusCode int
+}{
+	{"viewer on a viewer page", 1, roles.Viewer, http.StatusOK},
+	{"viewer on an operator page", 1, roles.Operator, http.StatusForbidden},
+	{"operator on an operator page", 2, roles.Operator, http.StatusOK},
+	{"operator on an admin page", 2, roles.Admin, http.StatusForbidden},
+	{"admin on an admin page", 3, roles.Admin, http.StatusOK},
+	{"no role", 0, roles.Viewer, http.StatusForbidden},
+}
+
+func TestRequireRole(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	app.InfoLog = log.New(io.Discard, "", 0)
+	app.UseCache = true
+	app.TemplateCache = map[string]*template.Template{}
+	helpers.NewHelpers(&app)
+	render.NewRenderer(&app)
+
+	for _, e := range requireRoleTests {
+		req, _ := http.NewRequest("GET", "/admin", nil)
+		ctx, err := session.Load(req.Context(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(ctx)
+		if e.accessLevel > 0 {
+			session.Put(ctx, "access_level", e.accessLevel)
+		}
+
+		rr := httptest.NewRecorder()
+		RequireRole(e.required)(&myHandler{}).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/migrations"
+	"pawprintpublic/internal/storage"
+	"strconv"
+)
+
+// migrate runs the migrate subcommand against the configured database:
+//
+//	migrate [up]      apply every pending migration
+//	migrate down [n]  undo the latest n migrations, 1 by default
+//	migrate status    print the schema version and the latest one
+func migrate(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	steps := 1
+	if command == "down" && len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("migrate down takes a positive number of steps, got %q", args[1])
+		}
+		steps = n
+	}
+
+	db, err := driver.ConnectFromEnv()
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	ctx := context.Background()
+	files, err := storage.FromEnv(ctx)
+	if err != nil {
+		return err
+	}
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	migrator, err := migrations.New(db, infoLog)
+	if err != nil {
+		return err
+	}
+	migrator.Storage = files
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		infoLog.Printf("Applied %d migrations", applied)
+	case "down":
+		undone, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		infoLog.Printf("Undid %d migrations", undone)
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q; use up, down [n] or status", command)
+	}
+
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version %d, latest %d\n", version, migrator.Latest())
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"pawprintpublic/internal/config"
 	"pawprintpublic/internal/handlers"
+	"pawprintpublic/internal/roles"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -20,24 +21,53 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/login", handlers.Repo.Login)
 	mux.Post("/login", handlers.Repo.PostLogin)
 	mux.Get("/logout", handlers.Repo.Logout)
+	mux.Get("/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/reset-password", handlers.Repo.ResetPassword)
+	mux.Post("/reset-password", handlers.Repo.PostResetPassword)
+
+	// Signed links from completion emails work without signing in
+	mux.Get("/shared/download", handlers.Repo.SharedDownloadHandler)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
 	mux.Route("/", func(mux chi.Router) {
 		mux.Use(Auth)
-		mux.Get("/", handlers.Repo.Home)
-		mux.Get("/file-upload", handlers.Repo.FileUploadPage)
-		mux.Get("/term-select", handlers.Repo.TermSelectPage)
-
-		mux.Post("/upload", handlers.Repo.UploadHandler)
-		mux.Get("/sse", handlers.Repo.SSEHandler)
-		mux.Get("/download/{src}", handlers.Repo.DownloadHandler)
-		mux.Get("/admin", handlers.Repo.AdminDashboard)
-
-		mux.Get("/admin/users", handlers.Repo.AdminUsers)
-		mux.Post("/admin/users/add", handlers.Repo.AdminAddUser)
-		mux.Post("/admin/users/edit", handlers.Repo.AdminEditUser)
+
+		// Every role follows its own jobs
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireRole(roles.Viewer))
+			mux.Get("/", handlers.Repo.Home)
+			mux.Get("/jobs", handlers.Repo.JobsPage)
+			mux.Get("/sse", handlers.Repo.SSEHandler)
+			mux.Get("/download/{src}", handlers.Repo.DownloadHandler)
+		})
+
+		// Operators run jobs
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireRole(roles.Operator))
+			mux.Get("/file-upload", handlers.Repo.FileUploadPage)
+			mux.Get("/term-select", handlers.Repo.TermSelectPage)
+			mux.Post("/upload", handlers.Repo.UploadHandler)
+			mux.Post("/tasks/{id}/cancel", handlers.Repo.CancelTaskHandler)
+			mux.Get("/preview", handlers.Repo.PreviewHandler)
+		})
+
+		// Admins see the dashboard, hold files and manage users
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireRole(roles.Admin))
+			mux.Get("/admin", handlers.Repo.AdminDashboard)
+			mux.Get("/admin/retention", handlers.Repo.AdminRetention)
+			mux.Post("/admin/retention/hold", handlers.Repo.AdminTaskHold)
+			mux.Get("/admin/users", handlers.Repo.AdminUsers)
+			mux.Get("/admin/users/add", handlers.Repo.AdminAddUser)
+			mux.Post("/admin/users/add", handlers.Repo.PostAdminAddUser)
+			mux.Post("/admin/users/edit", handlers.Repo.AdminEditUser)
+			mux.Post("/admin/users/active", handlers.Repo.AdminSetUserActive)
+			mux.Post("/admin/users/delete", handlers.Repo.AdminDeleteUser)
+			mux.Post("/admin/users/reset-password", handlers.Repo.AdminResetPassword)
+		})
 	})
 
 	return mux
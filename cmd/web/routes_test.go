@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"pawprintpublic/internal/roles"
 	"testing"
 
 	"github.com/go-chi/chi"
@@ -18,3 +22,39 @@ func TestRoutes(t *testing.T) {
 		t.Errorf("type is not *chi.Mux, type is %T", v)
 	}
 }
+
+func TestOperatorsCannotOpenAdminPages(t *testing.T) {
+	db := setupAuthTest(t)
+
+	// The seeded admin becomes an operator
+	user, err := db.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.AccessLevel = int(roles.Operator)
+	if err := db.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := session.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "access_level", int(roles.Operator))
+	token, _, err := session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := routes(&app)
+	for _, path := range []string{"/admin", "/admin/retention", "/admin/users"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected an operator to get %d but got %d", path, http.StatusForbidden, rr.Code)
+		}
+	}
+}
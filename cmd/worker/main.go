@@ -0,0 +1,98 @@
+// Command worker runs diploma tasks that the web process has left in the
+// jobs table. Run any number of workers against the same database; each one
+// claims jobs while it has free slots.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/jobs"
+	"pawprintpublic/internal/mailer"
+	"pawprintpublic/internal/migrations"
+	"pawprintpublic/internal/notify"
+	"pawprintpublic/internal/repository/dbrepo"
+	"pawprintpublic/internal/storage"
+	"sync"
+	"syscall"
+)
+
+func main() {
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if err := run(infoLog, errorLog); err != nil {
+		errorLog.Fatal(err)
+	}
+}
+
+func run(infoLog, errorLog *log.Logger) error {
+	maxTasks, err := jobs.MaxConcurrentFromEnv()
+	if err != nil {
+		return err
+	}
+
+	// Connect to database
+	infoLog.Println("Connecting to database...")
+	db, err := driver.ConnectFromEnv()
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	files, err := storage.FromEnv(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// Bring the schema up to date, refusing one newer than this binary
+	migrator, err := migrations.New(db, infoLog)
+	if err != nil {
+		return err
+	}
+	migrator.Storage = files
+	if err := migrator.OnStartup(context.Background()); err != nil {
+		return err
+	}
+
+	app := &config.AppConfig{InfoLog: infoLog, ErrorLog: errorLog, Wait: &sync.WaitGroup{}}
+	repo := dbrepo.NewRepo(db, app)
+	tasks := diplomapdfs.NewTaskManager(repo, maxTasks)
+
+	// Email uploaders when their tasks finish
+	mailerConfig, err := mailer.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	mailerConfig.Wait, mailerConfig.InfoLog, mailerConfig.ErrorLog = app.Wait, infoLog, errorLog
+	app.Mailer = mailer.CreateMail(mailerConfig)
+	go app.Mailer.ListenForMail()
+
+	completion, err := notify.FromEnv(repo, &app.Mailer, errorLog)
+	if err != nil {
+		return err
+	}
+	tasks.OnFinish(completion.TaskFinished)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	worker := jobs.NewWorker(fmt.Sprintf("%s-%d", hostname, os.Getpid()), repo, files, tasks, infoLog, errorLog)
+
+	// Finish the running tasks before exiting on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	worker.Run(ctx)
+
+	// Send the emails of the last tasks before exiting
+	app.Wait.Wait()
+	app.Mailer.DoneChan <- true
+	return nil
+}
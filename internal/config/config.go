@@ -6,7 +6,11 @@ import (
 	"os"
 	"os/signal"
 	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/links"
 	"pawprintpublic/internal/mailer"
+	"pawprintpublic/internal/progress"
+	"pawprintpublic/internal/retention"
+	"pawprintpublic/internal/storage"
 	"sync"
 	"syscall"
 
@@ -26,6 +30,12 @@ type AppConfig struct {
 	ErrorChan     chan error
 	ErrorChanDone chan bool
 	TaskManager   *diplomapdfs.TaskManager
+	TaskExecution string // "local" to run tasks here, "queue" to leave them for workers
+	Progress      progress.Broker
+	Links         *links.Signer // signs the download links in completion emails
+	Storage       storage.Storage
+	Retention     retention.Policy // how long the cleanup job keeps each type of file
+	BaseURL       string           // where the web tier is reached, for links in emails
 }
 
 // Config is used for application startup to allow for easier testing of main.go
@@ -0,0 +1,111 @@
+package diplomapdfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/phpdave11/gofpdf"
+)
+
+// Sort keys for the merged PDF
+const (
+	SortRowOrder = ""
+	SortLastName = "last_name"
+	SortDegree   = "degree"
+	SortMajor    = "major"
+	SortHonor    = "honor"
+)
+
+// sortKeys returns the value diplomas are sorted and grouped by
+var sortKeys = map[string]func(DiplomaData) string{
+	SortLastName: func(d DiplomaData) string { _, last := splitName(d.FullName); return last },
+	SortDegree:   func(d DiplomaData) string { return d.Degree },
+	SortMajor:    func(d DiplomaData) string { return d.Major },
+	SortHonor:    func(d DiplomaData) string { return d.Honor },
+}
+
+// ValidateSortBy checks that a sort key is known
+func ValidateSortBy(sortBy string) error {
+	if sortBy == SortRowOrder {
+		return nil
+	}
+	if _, ok := sortKeys[sortBy]; !ok {
+		return fmt.Errorf("unknown sort order %q", sortBy)
+	}
+	return nil
+}
+
+// arrangeDiplomas sorts the diplomas of one template group and, when asked,
+// puts a separator sheet in front of each run of diplomas sharing a sort value
+func arrangeDiplomas(diplomas []DiplomaData, opts Options) []DiplomaData {
+	key, ok := sortKeys[opts.SortBy]
+	if !ok {
+		return diplomas
+	}
+
+	sorted := make([]DiplomaData, len(diplomas))
+	copy(sorted, diplomas)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := strings.ToLower(key(sorted[i])), strings.ToLower(key(sorted[j]))
+		if a != b {
+			return a < b
+		}
+		_, lastA := splitName(sorted[i].FullName)
+		_, lastB := splitName(sorted[j].FullName)
+		return strings.ToLower(lastA) < strings.ToLower(lastB)
+	})
+
+	if !opts.Separators {
+		return sorted
+	}
+
+	// Last names are grouped by their first letter, everything else by value
+	groupLabel := func(d DiplomaData) string {
+		value := strings.TrimSpace(key(d))
+		if opts.SortBy == SortLastName {
+			for _, r := range value {
+				return string(unicode.ToUpper(r))
+			}
+		}
+		if value == "" {
+			return "None"
+		}
+		return value
+	}
+
+	arranged := make([]DiplomaData, 0, len(sorted)+8)
+	for i := 0; i < len(sorted); {
+		label := groupLabel(sorted[i])
+		end := i
+		for end < len(sorted) && strings.EqualFold(groupLabel(sorted[end]), label) {
+			end++
+		}
+
+		count := end - i
+		noun := "diplomas"
+		if count == 1 {
+			noun = "diploma"
+		}
+		arranged = append(arranged, DiplomaData{Separator: fmt.Sprintf("%s — %d %s", label, count, noun)})
+		arranged = append(arranged, sorted[i:end]...)
+		i = end
+	}
+
+	return arranged
+}
+
+// drawSeparator draws a plain page announcing the group that follows
+func drawSeparator(pdf *gofpdf.Fpdf, text string) error {
+	pdf.AddPage()
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetFont("Helvetica", "B", 32)
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	textWidth := pdf.GetStringWidth(tr(text))
+	pdf.Text((pageWidth-textWidth)/2, pageHeight/2, tr(text))
+
+	return pdf.Error()
+}
@@ -0,0 +1,69 @@
+package diplomapdfs
+
+import (
+	"testing"
+)
+
+func testDiplomas() []DiplomaData {
+	return []DiplomaData{
+		{Row: 2, FullName: "Cara Young", Degree: "Associate of Science"},
+		{Row: 3, FullName: "Ana Lee", Degree: "Associate of Arts"},
+		{Row: 4, FullName: "Bo Adams Jr", Degree: "Associate of Science"},
+		{Row: 5, FullName: "Dee Allen", Degree: "Associate of Arts"},
+	}
+}
+
+func TestArrangeDiplomasRowOrder(t *testing.T) {
+	arranged := arrangeDiplomas(testDiplomas(), Options{Separators: true})
+	for i, data := range arranged {
+		if data.Row != i+2 {
+			t.Errorf("expected spreadsheet order, got row %d at %d", data.Row, i)
+		}
+	}
+}
+
+func TestArrangeDiplomasSortAndSeparate(t *testing.T) {
+	arranged := arrangeDiplomas(testDiplomas(), Options{SortBy: SortDegree, Separators: true})
+
+	expected := []string{
+		"Associate of Arts — 2 diplomas", "Dee Allen", "Ana Lee",
+		"Associate of Science — 2 diplomas", "Bo Adams Jr", "Cara Young",
+	}
+	if len(arranged) != len(expected) {
+		t.Fatalf("expected %d pages, got %d", len(expected), len(arranged))
+	}
+	for i, data := range arranged {
+		got := data.FullName
+		if data.Separator != "" {
+			got = data.Separator
+		}
+		if got != expected[i] {
+			t.Errorf("page %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+}
+
+func TestArrangeDiplomasLastNameInitials(t *testing.T) {
+	arranged := arrangeDiplomas(testDiplomas(), Options{SortBy: SortLastName, Separators: true})
+
+	var separators []string
+	for _, data := range arranged {
+		if data.Separator != "" {
+			separators = append(separators, data.Separator)
+		}
+	}
+	if len(separators) != 3 || separators[0] != "A — 2 diplomas" || separators[2] != "Y — 1 diploma" {
+		t.Errorf("unexpected separators %v", separators)
+	}
+}
+
+func TestValidateSortBy(t *testing.T) {
+	for _, sortBy := range []string{SortRowOrder, SortLastName, SortDegree, SortMajor, SortHonor} {
+		if err := ValidateSortBy(sortBy); err != nil {
+			t.Errorf("%q should be valid: %v", sortBy, err)
+		}
+	}
+	if err := ValidateSortBy("gpa"); err == nil {
+		t.Error("expected an error for an unknown sort order")
+	}
+}
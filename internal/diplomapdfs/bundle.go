@@ -0,0 +1,126 @@
+package diplomapdfs
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultZipPattern names the individual diplomas in a ZIP bundle
+const DefaultZipPattern = "{LastName}_{FirstName}_{Degree}.pdf"
+
+var (
+	zipTokenRe     = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+	unsafeNameRe   = regexp.MustCompile(`[\\/:*?"<>|\x00-\x1f]+`)
+	nameSuffixes   = []string{"II", "III", "IV", "Jr", "Jr.", "Sr", "Sr."}
+	zipPatternKeys = map[string]func(DiplomaData) string{
+		"FullName":   func(d DiplomaData) string { return d.FullName },
+		"FirstName":  func(d DiplomaData) string { first, _ := splitName(d.FullName); return first },
+		"LastName":   func(d DiplomaData) string { _, last := splitName(d.FullName); return last },
+		"Degree":     func(d DiplomaData) string { return d.Degree },
+		"Major":      func(d DiplomaData) string { return d.Major },
+		"Honor":      func(d DiplomaData) string { return d.Honor },
+		"DegreeCode": func(d DiplomaData) string { return d.Values["Degree Code"] },
+		"Row":        func(d DiplomaData) string { return fmt.Sprint(d.Row) },
+	}
+)
+
+// ValidateZipPattern checks that a file name pattern only uses known tokens
+func ValidateZipPattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return errors.New("pattern cannot be blank")
+	}
+	if strings.ContainsAny(pattern, `/\`) {
+		return errors.New("pattern cannot contain path separators")
+	}
+
+	matches := zipTokenRe.FindAllStringSubmatch(pattern, -1)
+	if len(matches) == 0 {
+		return errors.New("pattern must contain at least one {Token}")
+	}
+	for _, match := range matches {
+		if _, ok := zipPatternKeys[match[1]]; !ok {
+			return fmt.Errorf("unknown token {%s}", match[1])
+		}
+	}
+	return nil
+}
+
+// zipFileName expands a pattern for one diploma
+func zipFileName(pattern string, data DiplomaData) string {
+	name := zipTokenRe.ReplaceAllStringFunc(pattern, func(token string) string {
+		value, ok := zipPatternKeys[token[1:len(token)-1]]
+		if !ok {
+			return token
+		}
+		return value(data)
+	})
+
+	name = strings.TrimSpace(unsafeNameRe.ReplaceAllString(name, "-"))
+	if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+		name += ".pdf"
+	}
+	return name
+}
+
+// splitName returns the first and last name of a full name, ignoring suffixes
+func splitName(fullName string) (string, string) {
+	parts := strings.Fields(fullName)
+	if len(parts) > 1 {
+		for _, suffix := range nameSuffixes {
+			if strings.EqualFold(parts[len(parts)-1], suffix) {
+				parts = parts[:len(parts)-1]
+				break
+			}
+		}
+	}
+
+	switch len(parts) {
+	case 0:
+		return "", ""
+	case 1:
+		return parts[0], parts[0]
+	default:
+		return parts[0], parts[len(parts)-1]
+	}
+}
+
+// writeZipBundle writes one PDF per diploma into a ZIP at outputPath. Names
+// that come out the same get a numeric suffix.
+func writeZipBundle(diplomas []BatchJob, pdfBuffers [][]byte, pattern, outputPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	used := make(map[string]int, len(diplomas))
+	for i, diploma := range diplomas {
+		if pdfBuffers[i] == nil || len(diploma.Data) == 0 {
+			continue
+		}
+
+		name := zipFileName(pattern, diploma.Data[0])
+		used[strings.ToLower(name)]++
+		if n := used[strings.ToLower(name)]; n > 1 {
+			name = fmt.Sprintf("%s_%d.pdf", strings.TrimSuffix(name, ".pdf"), n)
+		}
+
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(pdfBuffers[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,78 @@
+package diplomapdfs
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateZipPattern(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		valid   bool
+	}{
+		{DefaultZipPattern, true},
+		{"{Row}-{FullName}", true},
+		{"", false},
+		{"diploma.pdf", false},
+		{"{LastName}/{FirstName}.pdf", false},
+		{"{Nickname}.pdf", false},
+	}
+
+	for _, e := range tests {
+		err := ValidateZipPattern(e.pattern)
+		if e.valid && err != nil {
+			t.Errorf("%q: expected valid, got %v", e.pattern, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("%q: expected an error", e.pattern)
+		}
+	}
+}
+
+func TestZipFileName(t *testing.T) {
+	data := DiplomaData{Row: 7, FullName: "Jane Q Public III", Degree: "Associate of Arts", Major: "Music: Voice"}
+
+	var tests = []struct {
+		pattern  string
+		expected string
+	}{
+		{DefaultZipPattern, "Public_Jane_Associate of Arts.pdf"},
+		{"{Row}_{Major}", "7_Music- Voice.pdf"},
+		{"{FullName}.PDF", "Jane Q Public III.PDF"},
+	}
+
+	for _, e := range tests {
+		if got := zipFileName(e.pattern, data); got != e.expected {
+			t.Errorf("%q: expected %q, got %q", e.pattern, e.expected, got)
+		}
+	}
+}
+
+func TestWriteZipBundle(t *testing.T) {
+	diplomas := []BatchJob{
+		{Index: 0, Data: []DiplomaData{{FullName: "Ana Lee", Degree: "AA"}}},
+		{Index: 1, Data: []DiplomaData{{FullName: "Ana Lee", Degree: "AA"}}},
+		{Index: 2, Data: []DiplomaData{{FullName: "Bo Diaz", Degree: "AS"}}},
+	}
+	buffers := [][]byte{[]byte("one"), []byte("two"), nil}
+
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	if err := writeZipBundle(diplomas, buffers, DefaultZipPattern, path); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if len(names) != 2 || names[0] != "Lee_Ana_AA.pdf" || names[1] != "Lee_Ana_AA_2.pdf" {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
@@ -2,50 +2,167 @@ package diplomapdfs
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/phpdave11/gofpdf"
 	"github.com/phpdave11/gofpdf/contrib/gofpdi"
 	"github.com/xuri/excelize/v2"
 )
 
+// ErrInvalidRow is returned when a row is missing data its layout needs
+var ErrInvalidRow = errors.New("invalid row")
+
 type DiplomaData struct {
-	FullName string
-	Degree   string
-	Major    string
-	Honor    string
-	Date     time.Time
+	Row       int
+	Separator string // set for group separator sheets, which carry no graduate
+	FullName  string
+	Degree    string
+	Major     string
+	Honor     string
+	Date      time.Time
+	Values    map[string]string
 }
 
 type BatchJob struct {
-	Index int
-	Data  []DiplomaData
+	Index        int
+	Data         []DiplomaData
+	Layout       *Layout
+	TemplatePath string
 }
 
 type BatchResult struct {
 	Index    int
 	PDFBytes []byte
+	Failed   []RowResult // diplomas left out of PDFBytes
+}
+
+// OutputFile is a file written by GeneratePdfs and the file type it is stored as
+type OutputFile struct {
+	Path string
+	Type string // "pdf", "zip" or "exceptions"
+}
+
+// rowError reports the diploma that stopped a batch from rendering
+type rowError struct {
+	Index int
+	Err   error
+}
+
+func (e *rowError) Error() string {
+	return e.Err.Error()
+}
+
+// assetDirs holds the directories diploma templates, fonts and layouts are read from
+type assetDirs struct {
+	Template string
+	Font     string
+	Layout   string
+}
+
+// assetDirectories returns the asset directories next to the executable
+func assetDirectories() (assetDirs, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return assetDirs{}, err
+	}
+	ROOT_DIR := filepath.Dir(exePath)
+
+	return assetDirs{
+		Template: filepath.Join(ROOT_DIR, "data", "input", "template"),
+		Font:     filepath.Join(ROOT_DIR, "data", "input", "fonts"),
+		Layout:   filepath.Join(ROOT_DIR, "data", "input", "layouts"),
+	}, nil
+}
+
+// loadTemplates loads the template set and checks that every layout's assets exist
+func loadTemplates(dirs assetDirs) (*TemplateSet, []templateGroup, error) {
+	templateSet, err := loadTemplateSetOrDefault(filepath.Join(dirs.Layout, "templates.json"), dirs.Layout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := templateSet.groups()
+	for _, group := range groups {
+		if err := group.Layout.CheckAssets(dirs.Template, dirs.Font); err != nil {
+			return nil, nil, fmt.Errorf("layout assets are missing for %s: %v", group.Name, err)
+		}
+	}
+
+	return templateSet, groups, nil
 }
 
-func (tm *TaskManager) GeneratePdfs(task *Task, filePath string, batchSize int) error {
+// RenderPreview renders a single diploma from Output sheet values, using the
+// same layouts and drawing code as a full run
+func RenderPreview(ctx context.Context, values map[string]string) ([]byte, error) {
+	dirs, err := assetDirectories()
+	if err != nil {
+		return nil, err
+	}
+
+	templateSet, groups, err := loadTemplates(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	groupName := templateSet.groupFor(values["Degree Code"], values["Degree Type"])
+	for _, group := range groups {
+		if group.Name != groupName {
+			continue
+		}
+
+		data, err := parseDiplomaRow(values, group.Layout)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRow, err)
+		}
+
+		templatePath := filepath.Join(dirs.Template, group.Layout.Template)
+		return generateBatchPDF(ctx, []DiplomaData{data}, group.Layout, templatePath, dirs.Font, nil)
+	}
+
+	return nil, fmt.Errorf("no layout for group %s", groupName)
+}
+
+// Options controls how GeneratePdfs builds its output
+type Options struct {
+	BatchSize  int
+	ZipPattern string // when set, also build a ZIP with one PDF per graduate
+	SortBy     string // one of the Sort constants; row order when empty
+	Separators bool   // insert a separator sheet before each sorted group
+}
+
+// GeneratePdfs renders the Output sheet of filePath and returns the merged
+// PDFs, the ZIP bundle if requested, and a spreadsheet of the rows that were
+// not printed if there were any
+func (tm *TaskManager) GeneratePdfs(task *Task, filePath string, opts Options) ([]OutputFile, error) {
 	// Get the directory of the executable
 	// defer close(task.ProgressChan) // Ensure the channel is closed when done
-	task.ProgressChan <- ProgressUpdate{Status: "Starting PDF generation", Progress: 60}
-	exePath, err := os.Executable()
+	if err := task.Report(ProgressUpdate{Status: "Starting PDF generation", Progress: 60}); err != nil {
+		return nil, err
+	}
+	dirs, err := assetDirectories()
 	if err != nil {
 		log.Printf("Failed to get executable path: %v\n", err)
-		return err
+		return nil, err
+	}
+
+	// Load the layouts before touching the data so a bad file fails fast
+	templateSet, groups, err := loadTemplates(dirs)
+	if err != nil {
+		log.Printf("Failed to load layouts: %v\n", err)
+		return nil, err
 	}
-	ROOT_DIR := filepath.Dir(exePath)
 
 	// Set the path to the Excel file
 	// dataPath := filepath.Join(ROOT_DIR, "data", "input", "test_202410.xlsx")
@@ -58,19 +175,19 @@ func (tm *TaskManager) GeneratePdfs(task *Task, filePath string, batchSize int)
 	if err != nil {
 		// log.Fatalf("Failed to load Excel data from %s: %v", dataPath, err)
 		log.Printf("Failed to load Excel data from %s: %v\n", filePath, err)
-		return err
+		return nil, err
 	}
 
 	// Read the sheet
 	rows, err := f.GetRows(sheetName)
 	if err != nil {
 		log.Printf("Failed to get rows from sheet %s: %v\n", sheetName, err)
-		return err
+		return nil, err
 	}
 
 	if len(rows) < 1 {
 		log.Printf("No rows found in sheet %s\n", sheetName)
-		return err
+		return nil, err
 	}
 	header := rows[0]
 
@@ -80,80 +197,192 @@ func (tm *TaskManager) GeneratePdfs(task *Task, filePath string, batchSize int)
 		colIndex[colName] = i
 	}
 
-	// Parse the Excel data into a slice of DiplomaData
-	var diplomaDataList []DiplomaData
+	// Parse the Excel data into a slice of DiplomaData per template group
+	groupData := make(map[string][]DiplomaData, len(groups))
+	layouts := make(map[string]*Layout, len(groups))
+	for _, group := range groups {
+		layouts[group.Name] = group.Layout
+	}
+	var results []RowResult
 	for i, row := range rows[1:] {
-		data := DiplomaData{}
-		if idx, ok := colIndex["Full Name"]; ok && idx < len(row) {
-			data.FullName = row[idx]
-		} else {
-			log.Printf("Row %d: Missing 'Full Name'", i+2)
-			continue
-		}
-		if idx, ok := colIndex["Degree"]; ok && idx < len(row) {
-			data.Degree = row[idx]
-		} else {
-			log.Printf("Row %d: Missing 'Degree'", i+2)
-			continue
-		}
-		if idx, ok := colIndex["Major"]; ok && idx < len(row) {
-			data.Major = row[idx]
-		} else {
-			log.Printf("Row %d: Missing 'Major'", i+2)
+		values := rowValues(row, colIndex)
+		groupName := templateSet.groupFor(values["Degree Code"], values["Degree Type"])
+
+		data, err := parseDiplomaRow(values, layouts[groupName])
+		if err != nil {
+			reason := err.Error()
+			if values["Degree"] == "" && values["Degree Code"] != "" {
+				reason = fmt.Sprintf("%s (degree code '%s' is not in the lookup)", reason, values["Degree Code"])
+			}
+			log.Printf("Row %d: %s", i+2, reason)
+			results = append(results, RowResult{Row: i + 2, Graduate: values["Full Name"], Status: RowSkipped, Reason: reason})
 			continue
 		}
-		if idx, ok := colIndex["Honor"]; ok && idx < len(row) {
-			data.Honor = row[idx]
-		} else {
-			data.Honor = ""
-		}
-		if idx, ok := colIndex["Date"]; ok && idx < len(row) {
-			dateStr := row[idx]
-			date, err := parseDate(dateStr)
-			if err != nil {
-				log.Printf("Row %d: %v", i+2, err)
-				continue
+		data.Row = i + 2
+		groupData[groupName] = append(groupData[groupName], data)
+	}
+
+	// Batch size
+	batchSize := opts.BatchSize
+	if batchSize < 1 {
+		batchSize = 100
+	}
+
+	// Divide each group into batches, keeping the groups in output order
+	var batches []BatchJob
+	groupBatches := make(map[string][]int, len(groups))
+	for _, group := range groups {
+		diplomaDataList := arrangeDiplomas(groupData[group.Name], opts)
+		templatePath := filepath.Join(dirs.Template, group.Layout.Template)
+		for i := 0; i < len(diplomaDataList); i += batchSize {
+			end := i + batchSize
+			if end > len(diplomaDataList) {
+				end = len(diplomaDataList)
 			}
-			data.Date = date
-		} else {
-			log.Printf("Row %d: Missing 'Date'", i+2)
-			continue
+			batch := BatchJob{
+				Index:        len(batches),
+				Data:         diplomaDataList[i:end],
+				Layout:       group.Layout,
+				TemplatePath: templatePath,
+			}
+			groupBatches[group.Name] = append(groupBatches[group.Name], batch.Index)
+			batches = append(batches, batch)
 		}
-		diplomaDataList = append(diplomaDataList, data)
 	}
 
-	// Coordinates for text placement
-	yCoordsOriginal := map[string]float64{
-		"name":   443,
-		"degree": 298,
-		"major":  260,
-		"honor":  230,
-		"date":   193,
+	rendering := newStepProgress(task, "diplomas rendered", countDiplomas(batches), 60, 80)
+	pdfBuffers, failed := renderBatches(task.Ctx, batches, dirs.Font, tm.workers(), rendering)
+	if task.Ctx.Err() != nil {
+		return nil, ErrCancelled
 	}
+	results = append(results, failed...)
 
-	// Path to the template PDF
-	templatePath := filepath.Join(ROOT_DIR, "data", "input", "template", "Template_datamerge_notxt.pdf")
+	// Every graduate in a batch that was not reported as failed was printed
+	failedRows := make(map[int]bool, len(failed))
+	for _, result := range failed {
+		failedRows[result.Row] = true
+	}
+	for _, batch := range batches {
+		for _, data := range batch.Data {
+			if data.Separator == "" && !failedRows[data.Row] {
+				results = append(results, RowResult{Row: data.Row, Graduate: data.FullName, Status: RowPrinted})
+			}
+		}
+	}
 
-	// Define the font directory
-	fontDir := filepath.Join(ROOT_DIR, "data", "input", "fonts")
+	// Merge batch PDFs, either into one file or one file per group
+	if err := task.Report(ProgressUpdate{Status: "Saving to final pdf", Progress: 80}); err != nil {
+		return nil, err
+	}
+	merging := newStepProgress(task, "batches merged", countPages(pdfBuffers), 80, 90)
+	var outputs []OutputFile
+	if templateSet.Mode == OutputSeparate {
+		for _, group := range groups {
+			indexes := groupBatches[group.Name]
+			if len(indexes) == 0 {
+				continue
+			}
+			buffers := make([][]byte, 0, len(indexes))
+			for _, index := range indexes {
+				buffers = append(buffers, pdfBuffers[index])
+			}
+			if !hasPages(buffers) {
+				continue
+			}
+			outputPath := filepath.Join("tmp", fmt.Sprintf("%s_%s.pdf", task.ID, group.Name))
+			err = mergePDFs(buffers, outputPath, merging)
+			if err != nil {
+				log.Printf("Failed to merge PDFs for %s: %v", group.Name, err)
+				return nil, err
+			}
+			outputs = append(outputs, OutputFile{Path: outputPath, Type: "pdf"})
+		}
+	} else if hasPages(pdfBuffers) {
+		outputPath := filepath.Join("tmp", fmt.Sprintf("%s.pdf", task.ID))
+		err = mergePDFs(pdfBuffers, outputPath, merging)
+		if err != nil {
+			log.Printf("Failed to merge PDFs: %v", err)
+			return nil, err
+		}
+		outputs = append(outputs, OutputFile{Path: outputPath, Type: "pdf"})
+	}
 
-	// Batch size
-	// batchSize := 150
+	// fmt.Printf("All diplomas have been saved to %s\n", outputPath)
 
-	// Divide diplomaDataList into batches
-	var batches []BatchJob
-	for i := 0; i < len(diplomaDataList); i += batchSize {
-		end := i + batchSize
-		if end > len(diplomaDataList) {
-			end = len(diplomaDataList)
+	// Bundle one PDF per graduate
+	if opts.ZipPattern != "" {
+		if err := task.Report(ProgressUpdate{Status: "Building ZIP of individual diplomas", Progress: 90}); err != nil {
+			return nil, err
 		}
-		batch := BatchJob{
-			Index: len(batches),
-			Data:  diplomaDataList[i:end],
+		var diplomas []BatchJob
+		for _, batch := range batches {
+			for _, data := range batch.Data {
+				if data.Separator != "" || failedRows[data.Row] {
+					continue
+				}
+				diplomas = append(diplomas, BatchJob{
+					Index:        len(diplomas),
+					Data:         []DiplomaData{data},
+					Layout:       batch.Layout,
+					TemplatePath: batch.TemplatePath,
+				})
+			}
 		}
-		batches = append(batches, batch)
+
+		zipPath := filepath.Join("tmp", fmt.Sprintf("%s.zip", task.ID))
+		bundling := newStepProgress(task, "individual diplomas rendered", len(diplomas), 90, 99)
+		buffers, _ := renderBatches(task.Ctx, diplomas, dirs.Font, tm.workers(), bundling)
+		if task.Ctx.Err() != nil {
+			return nil, ErrCancelled
+		}
+		err = writeZipBundle(diplomas, buffers, opts.ZipPattern, zipPath)
+		if err != nil {
+			log.Printf("Failed to build ZIP: %v", err)
+			return nil, err
+		}
+		outputs = append(outputs, OutputFile{Path: zipPath, Type: "zip"})
+	}
+
+	// List the rows that were not printed so they can be fixed and rerun
+	notPrinted := exceptions(results)
+	if len(notPrinted) > 0 {
+		reportPath := filepath.Join("tmp", fmt.Sprintf("%s_exceptions.xlsx", task.ID))
+		err = writeExceptionsReport(notPrinted, reportPath)
+		if err != nil {
+			log.Printf("Failed to write exceptions report: %v", err)
+			return nil, err
+		}
+		outputs = append(outputs, OutputFile{Path: reportPath, Type: "exceptions"})
 	}
 
+	summary := summarize(results)
+	files := make([]string, 0, len(outputs))
+	for _, output := range outputs {
+		files = append(files, filepath.Base(output.Path))
+	}
+
+	task.Results = results
+	err = task.Report(ProgressUpdate{
+		Status:     "PDF generation completed",
+		Progress:   100,
+		Files:      files,
+		Summary:    &summary,
+		Exceptions: notPrinted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	task.FinishedAt = time.Now()
+	close(task.DoneChan)
+	return outputs, nil
+}
+
+// renderBatches renders the batches across a pool of workers and returns the
+// PDFs in batch order along with the diplomas that could not be rendered. A
+// batch with no diplomas left is returned as nil. Once ctx is cancelled the
+// remaining batches are skipped. Each diploma is counted on progress as it
+// is rendered or given up on.
+func renderBatches(ctx context.Context, batches []BatchJob, fontDir string, numWorkers int, progress *stepProgress) ([][]byte, []RowResult) {
 	// Channels for jobs and results
 	jobs := make(chan BatchJob, len(batches))
 	results := make(chan BatchResult, len(batches))
@@ -161,13 +390,10 @@ func (tm *TaskManager) GeneratePdfs(task *Task, filePath string, batchSize int)
 	// WaitGroup to wait for all goroutines to finish
 	var wg sync.WaitGroup
 
-	// Number of worker goroutines
-	numWorkers := runtime.NumCPU() // Or set to a fixed number
-
 	// Start worker goroutines
 	for w := 1; w <= numWorkers; w++ {
 		wg.Add(1)
-		go batchWorker(w, &wg, jobs, results, yCoordsOriginal, templatePath, fontDir)
+		go batchWorker(ctx, w, &wg, jobs, results, fontDir, progress)
 	}
 
 	// Send jobs
@@ -182,54 +408,120 @@ func (tm *TaskManager) GeneratePdfs(task *Task, filePath string, batchSize int)
 
 	// Collect all the batch PDFs in order
 	pdfBuffers := make([][]byte, len(batches))
-	for i := 0; i < len(batches); i++ {
-		result := <-results
+	var failed []RowResult
+	for result := range results {
 		pdfBuffers[result.Index] = result.PDFBytes
+		failed = append(failed, result.Failed...)
 	}
 
-	// Merge batch PDFs
-	task.ProgressChan <- ProgressUpdate{Status: "Saving to final pdf", Progress: 80}
-	outputPath := filepath.Join("tmp", fmt.Sprintf("%s.pdf", task.ID))
-	err = mergePDFs(pdfBuffers, outputPath)
-	if err != nil {
-		log.Fatalf("Failed to merge PDFs: %v", err)
-	}
-
-	// fmt.Printf("All diplomas have been saved to %s\n", outputPath)
-
-	task.ProgressChan <- ProgressUpdate{Status: "PDF generation completed", Progress: 100}
-	task.FinishedAt = time.Now()
-	close(task.DoneChan)
-	return nil
+	return pdfBuffers, failed
 }
 
 // Batch worker function
-func batchWorker(id int, wg *sync.WaitGroup, jobs <-chan BatchJob, results chan<- BatchResult, yCoordsOriginal map[string]float64, templatePath, fontDir string) {
+func batchWorker(ctx context.Context, id int, wg *sync.WaitGroup, jobs <-chan BatchJob, results chan<- BatchResult, fontDir string, progress *stepProgress) {
 	defer wg.Done()
 	for batchJob := range jobs {
-		pdfBytes, err := generateBatchPDF(batchJob.Data, yCoordsOriginal, templatePath, fontDir)
-		if err != nil {
+		result := BatchResult{Index: batchJob.Index}
+		data := batchJob.Data
+		for ctx.Err() == nil {
+			pages := 0
+			pdfBytes, err := generateBatchPDF(ctx, data, batchJob.Layout, batchJob.TemplatePath, fontDir, func() {
+				pages++
+				progress.add(1)
+			})
+			if err == nil {
+				result.PDFBytes = pdfBytes
+				break
+			}
+			if ctx.Err() != nil {
+				break
+			}
 			log.Printf("Worker %d: Error processing batch %d: %v", id, batchJob.Index, err)
-			continue
+
+			// The pages of this attempt are rendered again, or not at all
+			progress.add(-pages)
+
+			// A failed page leaves the PDF unusable, so drop the diploma
+			// that failed and render the rest of the batch again
+			var rowErr *rowError
+			if !errors.As(err, &rowErr) {
+				result.Failed = append(result.Failed, failedRows(data, err.Error())...)
+				progress.add(countDiplomas([]BatchJob{{Data: data}}))
+				break
+			}
+			result.Failed = append(result.Failed, failedRows(data[rowErr.Index:rowErr.Index+1], err.Error())...)
+			progress.add(1)
+			data = append(data[:rowErr.Index:rowErr.Index], data[rowErr.Index+1:]...)
+			if !hasDiplomas(data) {
+				break
+			}
+		}
+		results <- result
+	}
+}
+
+// hasDiplomas reports whether data holds at least one graduate
+func hasDiplomas(data []DiplomaData) bool {
+	for _, d := range data {
+		if d.Separator == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// countDiplomas returns how many graduates the batches hold, leaving out
+// separator sheets
+func countDiplomas(batches []BatchJob) int {
+	count := 0
+	for _, batch := range batches {
+		for _, d := range batch.Data {
+			if d.Separator == "" {
+				count++
+			}
 		}
-		results <- BatchResult{Index: batchJob.Index, PDFBytes: pdfBytes}
 	}
+	return count
 }
 
-// Function to generate a multi-page PDF for a batch and return it as bytes
-func generateBatchPDF(batch []DiplomaData, yCoordsOriginal map[string]float64, templatePath, fontDir string) ([]byte, error) {
+// hasPages reports whether any of the buffers holds a rendered batch
+func hasPages(pdfBuffers [][]byte) bool {
+	return countPages(pdfBuffers) > 0
+}
+
+// countPages returns how many of the buffers hold a rendered batch
+func countPages(pdfBuffers [][]byte) int {
+	count := 0
+	for _, buf := range pdfBuffers {
+		if buf != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Function to generate a multi-page PDF for a batch and return it as bytes.
+// onPage, if set, is called after each graduate's page is drawn.
+func generateBatchPDF(ctx context.Context, batch []DiplomaData, layout *Layout, templatePath, fontDir string, onPage func()) ([]byte, error) {
 	// Create a new PDF object with the font directory specified
 	pdf := gofpdf.New("L", "pt", "Letter", fontDir)
 
 	// Register the fonts using only the file names
-	pdf.AddUTF8Font("OldEnglishBold", "", "EngraversOldEnglish.ttf")
-	pdf.AddUTF8Font("TimesNewRoman", "", "TimesNewRoman.ttf")
+	for _, font := range layout.Fonts {
+		pdf.AddUTF8Font(font.Name, "", font.File)
+	}
 
-	for _, data := range batch {
-		err := processDiplomaData(pdf, data, yCoordsOriginal, templatePath)
+	for i, data := range batch {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := processDiplomaData(pdf, data, layout, templatePath)
 		if err != nil {
 			log.Printf("Error processing diploma for %s: %v", data.FullName, err)
-			continue
+			return nil, &rowError{Index: i, Err: err}
+		}
+		if onPage != nil && data.Separator == "" {
+			onPage()
 		}
 	}
 
@@ -243,39 +535,99 @@ func generateBatchPDF(batch []DiplomaData, yCoordsOriginal map[string]float64, t
 	return buf.Bytes(), nil
 }
 
-// Function to merge multiple PDFs
-func mergePDFs(pdfBuffers [][]byte, outputPath string) error {
-	var pdfReaders []string
-	tmpDir := os.TempDir()
-	tmpFiles := make([]string, 0, len(pdfBuffers))
+// mergePDFs merges the batch PDFs in order into outputPath, skipping
+// batches that failed, and counts each merged batch on progress
+func mergePDFs(pdfBuffers [][]byte, outputPath string, progress *stepProgress) error {
+	var inputs [][]byte
+	for _, buf := range pdfBuffers {
+		if buf != nil {
+			inputs = append(inputs, buf)
+		}
+	}
+	if len(inputs) == 0 {
+		return errors.New("no pages to merge")
+	}
 
-	// Write each PDF buffer to a temporary file
-	for i, buf := range pdfBuffers {
-		tmpFile := filepath.Join(tmpDir, fmt.Sprintf("temp_%d.pdf", i))
-		err := os.WriteFile(tmpFile, buf, 0644)
+	conf := model.NewDefaultConfiguration()
+	conf.Cmd = model.MERGECREATE
+	conf.ValidationMode = model.ValidationRelaxed
+	conf.CreateBookmarks = false
+
+	// Merge with pdfcpu one batch at a time so progress can be reported
+	merged, err := api.ReadAndValidate(bytes.NewReader(inputs[0]), conf)
+	if err != nil {
+		return err
+	}
+	merged.EnsureVersionForWriting()
+	progress.add(1)
+
+	for i, buf := range inputs[1:] {
+		batch, err := api.ReadAndValidate(bytes.NewReader(buf), merged.Configuration)
+		if err != nil {
+			return err
+		}
+		err = pdfcpu.MergeXRefTables(fmt.Sprintf("batch_%d.pdf", i+1), batch, merged, false, false)
 		if err != nil {
 			return err
 		}
-		tmpFiles = append(tmpFiles, tmpFile)
-		pdfReaders = append(pdfReaders, tmpFile)
+		progress.add(1)
 	}
 
-	// Merge PDFs using pdfcpu
-	err := api.MergeCreateFile(pdfReaders, outputPath, false, nil)
-	if err != nil {
+	if err := api.OptimizeContext(merged); err != nil {
 		return err
 	}
+	return api.WriteContextFile(merged, outputPath)
+}
 
-	// Clean up temporary files
-	for _, tmpFile := range tmpFiles {
-		os.Remove(tmpFile)
+// rowValues maps the cells of a row to their column names
+func rowValues(row []string, colIndex map[string]int) map[string]string {
+	values := make(map[string]string, len(colIndex))
+	for colName, idx := range colIndex {
+		if idx < len(row) {
+			values[colName] = row[idx]
+		}
 	}
+	return values
+}
+
+// parseDiplomaRow maps the values of a row of the Output sheet to DiplomaData,
+// checking the columns the layout needs
+func parseDiplomaRow(values map[string]string, layout *Layout) (DiplomaData, error) {
+	data := DiplomaData{Values: values}
 
-	return nil
+	for _, column := range layout.requiredColumns() {
+		if strings.TrimSpace(data.Values[column]) == "" {
+			return data, fmt.Errorf("missing '%s'", column)
+		}
+	}
+
+	for _, field := range layout.Fields {
+		value := data.Values[field.Column]
+		if field.DateFormat == "" || value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			return data, err
+		}
+	}
+
+	data.FullName = data.Values["Full Name"]
+	data.Degree = data.Values["Degree"]
+	data.Major = data.Values["Major"]
+	data.Honor = data.Values["Honor"]
+	if dateStr, ok := data.Values["Date"]; ok {
+		data.Date, _ = parseDate(dateStr)
+	}
+
+	return data, nil
 }
 
 // Function to process each diploma data and generate a PDF page
-func processDiplomaData(pdf *gofpdf.Fpdf, data DiplomaData, yCoordsOriginal map[string]float64, templatePath string) error {
+func processDiplomaData(pdf *gofpdf.Fpdf, data DiplomaData, layout *Layout, templatePath string) error {
+	if data.Separator != "" {
+		return drawSeparator(pdf, data.Separator)
+	}
+
 	pdf.AddPage()
 
 	// Import the template PDF page
@@ -286,101 +638,115 @@ func processDiplomaData(pdf *gofpdf.Fpdf, data DiplomaData, yCoordsOriginal map[
 	// Get page dimensions
 	_, pageHeight := pdf.GetPageSize()
 
-	// Adjust y-coordinates
-	yCoords := map[string]float64{
-		"name":   pageHeight - yCoordsOriginal["name"],
-		"degree": pageHeight - yCoordsOriginal["degree"],
-		"major":  pageHeight - yCoordsOriginal["major"],
-		"honor":  pageHeight - yCoordsOriginal["honor"],
-		"date":   pageHeight - yCoordsOriginal["date"],
-	}
+	// Draw each field, either at its fixed position or below the previous field
+	var nextY float64
+	for _, field := range layout.Fields {
+		text := data.Values[field.Column]
+		if field.DateFormat != "" && text != "" {
+			date, err := parseDate(text)
+			if err != nil {
+				return err
+			}
+			text = date.Format(field.DateFormat)
+		}
 
-	// Extract information from the data
-	nameText := data.FullName
-	degreeText := data.Degree
-	majorText := data.Major
-	honorText := data.Honor
-	dateText := data.Date.Format("January 02, 2006")
+		if text == "" && field.Optional {
+			continue
+		}
 
-	// Split the full name into parts to handle suffixes
-	nameParts := strings.Fields(nameText)
-	mainName := nameText
-	suffix := ""
-	if len(nameParts) > 1 {
-		lastPart := nameParts[len(nameParts)-1]
-		if lastPart == "II" || lastPart == "III" || lastPart == "IV" {
-			mainName = strings.Join(nameParts[:len(nameParts)-1], " ")
-			suffix = lastPart
+		y := nextY + field.Spacing
+		if field.Anchor == AnchorFixed {
+			y = pageHeight - field.Y
 		}
+
+		height := drawField(pdf, field, text, y)
+		nextY = y + height
 	}
 
-	// Font size for the main name
-	fontSize := 31.0
-	mainFont := "OldEnglishBold"
-	mainStyle := ""
-	suffixFont := "TimesNewRoman"
-	suffixStyle := ""
+	return pdf.Error()
+}
 
-	// Calculate x-coordinates for centering
-	mainNameX := centeredX(pdf, mainName, mainFont, mainStyle, fontSize, suffix, suffixFont, suffixStyle, fontSize)
+// drawField draws a field at y and returns the height it used
+func drawField(pdf *gofpdf.Fpdf, field FieldLayout, text string, y float64) float64 {
+	if field.Wrap {
+		return drawWrappedText(pdf, text, field, y)
+	}
 
-	// Adjust y-coordinate for the name
-	nameY := yCoords["name"]
+	// Split the text into parts to handle suffixes
+	mainText, suffix := splitSuffix(text, field.Suffixes)
 
-	// Draw the main name
-	pdf.SetFont(mainFont, mainStyle, fontSize)
-	pdf.Text(mainNameX, nameY, mainName)
+	pdf.SetFont(field.Font, field.Style, field.Size)
+	textWidth := pdf.GetStringWidth(mainText)
+	mainWidth := textWidth
 
-	// Draw the suffix if it exists
 	if suffix != "" {
-		suffixX := mainNameX + pdf.GetStringWidth(mainName) + 5
-		pdf.SetFont(suffixFont, suffixStyle, fontSize)
-		pdf.Text(suffixX, nameY, suffix)
+		pdf.SetFont(field.SuffixFont, "", field.Size)
+		textWidth += pdf.GetStringWidth(suffix) + field.SuffixGap
 	}
 
-	// Adjust y-coordinate for degree
-	degreeY := yCoords["degree"]
-	degreeHeight := drawWrappedText(pdf, degreeText, "OldEnglishBold", "", 30, degreeY)
+	x := alignedX(pdf, field, textWidth)
 
-	// Adjust y-coordinate for major
-	majorY := degreeY + degreeHeight + 10
-	majorHeight := drawWrappedText(pdf, majorText, "OldEnglishBold", "", 24, majorY)
+	// Draw the main text
+	pdf.SetFont(field.Font, field.Style, field.Size)
+	pdf.Text(x, y, mainText)
 
-	// Adjust y-coordinate for honor or date
-	var nextY float64
-	if honorText != "" {
-		honorY := majorY + majorHeight + 5
-		honorHeight := drawWrappedText(pdf, honorText, "OldEnglishBold", "", 18, honorY)
-		nextY = honorY + honorHeight + 10
-	} else {
-		nextY = majorY + majorHeight + 10
+	// Draw the suffix if it exists
+	if suffix != "" {
+		pdf.SetFont(field.SuffixFont, "", field.Size)
+		pdf.Text(x+mainWidth+field.SuffixGap, y, suffix)
 	}
 
-	// Draw the date
-	drawWrappedText(pdf, dateText, "OldEnglishBold", "", 18, nextY)
-
-	return nil
+	return field.Size + field.LineSpacing
 }
 
-// Function to calculate centered x-coordinate
-func centeredX(pdf *gofpdf.Fpdf, mainText string, mainFont string, mainStyle string, mainSize float64, suffixText string, suffixFont string, suffixStyle string, suffixSize float64) float64 {
-	pdf.SetFont(mainFont, mainStyle, mainSize)
-	textWidth := pdf.GetStringWidth(mainText)
+// splitSuffix splits a trailing suffix such as "III" from the text
+func splitSuffix(text string, suffixes []string) (string, string) {
+	parts := strings.Fields(text)
+	if len(parts) < 2 {
+		return text, ""
+	}
 
-	if suffixText != "" {
-		pdf.SetFont(suffixFont, suffixStyle, suffixSize)
-		suffixTextWidth := pdf.GetStringWidth(suffixText)
-		textWidth += suffixTextWidth + 5 // Adding a small space
+	lastPart := parts[len(parts)-1]
+	for _, suffix := range suffixes {
+		if lastPart == suffix {
+			return strings.Join(parts[:len(parts)-1], " "), suffix
+		}
 	}
+	return text, ""
+}
 
+// fieldBox returns the left edge and width of the area a field is drawn in
+func fieldBox(pdf *gofpdf.Fpdf, field FieldLayout) (float64, float64) {
 	pageWidth, _ := pdf.GetPageSize()
-	return (pageWidth - textWidth) / 2
+
+	width := field.MaxWidth
+	if width == 0 {
+		width = pageWidth - 40
+	}
+
+	left := field.X
+	if left == 0 {
+		left = (pageWidth - width) / 2
+	}
+	return left, width
 }
 
-func drawWrappedText(pdf *gofpdf.Fpdf, text string, font string, style string, size float64, y float64) float64 {
-	pageWidth, _ := pdf.GetPageSize()
-	maxWidth := pageWidth - 40
-	pdf.SetFont(font, style, size)
+// Function to calculate the x-coordinate for the field's alignment
+func alignedX(pdf *gofpdf.Fpdf, field FieldLayout, textWidth float64) float64 {
+	left, width := fieldBox(pdf, field)
+	switch field.Align {
+	case AlignLeft:
+		return left
+	case AlignRight:
+		return left + width - textWidth
+	default:
+		return left + (width-textWidth)/2
+	}
+}
+
+func drawWrappedText(pdf *gofpdf.Fpdf, text string, field FieldLayout, y float64) float64 {
+	_, maxWidth := fieldBox(pdf, field)
+	pdf.SetFont(field.Font, field.Style, field.Size)
 
 	// Regular expression to split on spaces, dashes, and hyphens
 	re := regexp.MustCompile(`(\s+|-|–)`)
@@ -407,11 +773,12 @@ func drawWrappedText(pdf *gofpdf.Fpdf, text string, font string, style string, s
 		lines = append(lines, currentLine)
 	}
 
-	totalHeight := float64(len(lines)) * (size + 5)
+	lineHeight := field.Size + field.LineSpacing
+	totalHeight := float64(len(lines)) * lineHeight
 
 	for i, line := range lines {
-		lineX := centeredX(pdf, line, font, style, size, "", "", "", 0)
-		lineY := y + float64(i)*(size+5)
+		lineX := alignedX(pdf, field, pdf.GetStringWidth(line))
+		lineY := y + float64(i)*lineHeight
 		pdf.Text(lineX, lineY, line)
 	}
 
@@ -0,0 +1,234 @@
+package diplomapdfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Field anchors
+const (
+	AnchorFixed = "fixed" // Y is measured from the bottom of the page
+	AnchorFlow  = "flow"  // placed below the previously drawn field
+)
+
+// Field alignments
+const (
+	AlignLeft   = "left"
+	AlignCenter = "center"
+	AlignRight  = "right"
+)
+
+// Layout describes the template, fonts and text fields of a diploma
+type Layout struct {
+	Template string        `json:"template"`
+	Fonts    []FontLayout  `json:"fonts"`
+	Fields   []FieldLayout `json:"fields"`
+}
+
+// FontLayout registers a TrueType font from the font directory under a name
+type FontLayout struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// FieldLayout describes how one column of the Output sheet is drawn
+type FieldLayout struct {
+	Name        string   `json:"name"`
+	Column      string   `json:"column"`
+	Font        string   `json:"font"`
+	Style       string   `json:"style,omitempty"`
+	Size        float64  `json:"size"`
+	Anchor      string   `json:"anchor"`
+	Y           float64  `json:"y,omitempty"`
+	Spacing     float64  `json:"spacing,omitempty"`
+	LineSpacing float64  `json:"line_spacing"`
+	Align       string   `json:"align"`
+	X           float64  `json:"x,omitempty"`
+	MaxWidth    float64  `json:"max_width,omitempty"`
+	Wrap        bool     `json:"wrap,omitempty"`
+	Optional    bool     `json:"optional,omitempty"`
+	DateFormat  string   `json:"date_format,omitempty"`
+	Suffixes    []string `json:"suffixes,omitempty"`
+	SuffixFont  string   `json:"suffix_font,omitempty"`
+	SuffixGap   float64  `json:"suffix_gap,omitempty"`
+}
+
+// DefaultLayout returns the layout used for the original diploma artwork
+func DefaultLayout() *Layout {
+	return &Layout{
+		Template: "Template_datamerge_notxt.pdf",
+		Fonts: []FontLayout{
+			{Name: "OldEnglishBold", File: "EngraversOldEnglish.ttf"},
+			{Name: "TimesNewRoman", File: "TimesNewRoman.ttf"},
+		},
+		Fields: []FieldLayout{
+			{
+				Name: "name", Column: "Full Name", Font: "OldEnglishBold", Size: 31,
+				Anchor: AnchorFixed, Y: 443, LineSpacing: 5, Align: AlignCenter,
+				Suffixes: []string{"II", "III", "IV"}, SuffixFont: "TimesNewRoman", SuffixGap: 5,
+			},
+			{
+				Name: "degree", Column: "Degree", Font: "OldEnglishBold", Size: 30,
+				Anchor: AnchorFixed, Y: 298, LineSpacing: 5, Align: AlignCenter, Wrap: true,
+			},
+			{
+				Name: "major", Column: "Major", Font: "OldEnglishBold", Size: 24,
+				Anchor: AnchorFlow, Spacing: 10, LineSpacing: 5, Align: AlignCenter, Wrap: true,
+			},
+			{
+				Name: "honor", Column: "Honor", Font: "OldEnglishBold", Size: 18,
+				Anchor: AnchorFlow, Spacing: 5, LineSpacing: 5, Align: AlignCenter, Wrap: true,
+				Optional: true,
+			},
+			{
+				Name: "date", Column: "Date", Font: "OldEnglishBold", Size: 18,
+				Anchor: AnchorFlow, Spacing: 10, LineSpacing: 5, Align: AlignCenter, Wrap: true,
+				DateFormat: "January 02, 2006",
+			},
+		},
+	}
+}
+
+// LoadLayout reads and validates a layout file
+func LoadLayout(path string) (*Layout, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	var layout Layout
+	if err := decoder.Decode(&layout); err != nil {
+		return nil, fmt.Errorf("failed to parse layout %s: %v", path, err)
+	}
+
+	if err := layout.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid layout %s: %v", path, err)
+	}
+
+	return &layout, nil
+}
+
+// loadLayoutOrDefault loads the layout at path, falling back to DefaultLayout
+// when the file does not exist
+func loadLayoutOrDefault(path string) (*Layout, error) {
+	layout, err := LoadLayout(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultLayout(), nil
+	}
+	return layout, err
+}
+
+// Validate checks the layout for problems and reports all of them at once
+func (l *Layout) Validate() error {
+	var problems []string
+
+	if strings.TrimSpace(l.Template) == "" {
+		problems = append(problems, "template is required")
+	}
+
+	fonts := make(map[string]bool, len(l.Fonts))
+	for i, font := range l.Fonts {
+		if font.Name == "" {
+			problems = append(problems, fmt.Sprintf("font %d: name is required", i+1))
+			continue
+		}
+		if fonts[font.Name] {
+			problems = append(problems, fmt.Sprintf("font %q: declared more than once", font.Name))
+		}
+		if font.File == "" {
+			problems = append(problems, fmt.Sprintf("font %q: file is required", font.Name))
+		}
+		fonts[font.Name] = true
+	}
+
+	if len(l.Fields) == 0 {
+		problems = append(problems, "at least one field is required")
+	}
+
+	names := make(map[string]bool, len(l.Fields))
+	for i, field := range l.Fields {
+		label := fmt.Sprintf("field %d", i+1)
+		if field.Name != "" {
+			label = fmt.Sprintf("field %q", field.Name)
+			if names[field.Name] {
+				problems = append(problems, fmt.Sprintf("%s: declared more than once", label))
+			}
+			names[field.Name] = true
+		} else {
+			problems = append(problems, fmt.Sprintf("%s: name is required", label))
+		}
+
+		if field.Column == "" {
+			problems = append(problems, fmt.Sprintf("%s: column is required", label))
+		}
+		if !fonts[field.Font] {
+			problems = append(problems, fmt.Sprintf("%s: unknown font %q", label, field.Font))
+		}
+		if field.Size <= 0 {
+			problems = append(problems, fmt.Sprintf("%s: size must be greater than 0", label))
+		}
+
+		switch field.Anchor {
+		case AnchorFixed:
+			if field.Y <= 0 {
+				problems = append(problems, fmt.Sprintf("%s: y must be greater than 0 for a fixed anchor", label))
+			}
+		case AnchorFlow:
+			if i == 0 {
+				problems = append(problems, fmt.Sprintf("%s: the first field must use a fixed anchor", label))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s: anchor must be %q or %q", label, AnchorFixed, AnchorFlow))
+		}
+
+		switch field.Align {
+		case AlignLeft, AlignCenter, AlignRight, "":
+		default:
+			problems = append(problems, fmt.Sprintf("%s: align must be left, center or right", label))
+		}
+
+		if field.MaxWidth < 0 || field.X < 0 || field.Spacing < 0 || field.LineSpacing < 0 || field.SuffixGap < 0 {
+			problems = append(problems, fmt.Sprintf("%s: x, max_width and spacing values cannot be negative", label))
+		}
+		if len(field.Suffixes) > 0 && !fonts[field.SuffixFont] {
+			problems = append(problems, fmt.Sprintf("%s: unknown suffix_font %q", label, field.SuffixFont))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// CheckAssets makes sure the template and font files referenced by the layout exist
+func (l *Layout) CheckAssets(templateDir, fontDir string) error {
+	if _, err := os.Stat(filepath.Join(templateDir, l.Template)); err != nil {
+		return fmt.Errorf("template %s: %v", l.Template, err)
+	}
+	for _, font := range l.Fonts {
+		if _, err := os.Stat(filepath.Join(fontDir, font.File)); err != nil {
+			return fmt.Errorf("font %s: %v", font.Name, err)
+		}
+	}
+	return nil
+}
+
+// requiredColumns returns the Output sheet columns a row must have to be printed
+func (l *Layout) requiredColumns() []string {
+	var columns []string
+	for _, field := range l.Fields {
+		if !field.Optional {
+			columns = append(columns, field.Column)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,68 @@
+package diplomapdfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultLayoutIsValid(t *testing.T) {
+	if err := DefaultLayout().Validate(); err != nil {
+		t.Errorf("default layout should be valid, got %v", err)
+	}
+}
+
+var layoutTests = []struct {
+	name     string
+	modify   func(l *Layout)
+	expected string
+}{
+	{"no template", func(l *Layout) { l.Template = "" }, "template is required"},
+	{"unknown font", func(l *Layout) { l.Fields[1].Font = "Comic Sans" }, `unknown font "Comic Sans"`},
+	{"zero size", func(l *Layout) { l.Fields[2].Size = 0 }, "size must be greater than 0"},
+	{"bad anchor", func(l *Layout) { l.Fields[2].Anchor = "middle" }, "anchor must be"},
+	{"flow first", func(l *Layout) { l.Fields[0].Anchor = AnchorFlow }, "first field must use a fixed anchor"},
+	{"bad align", func(l *Layout) { l.Fields[3].Align = "justify" }, "align must be left, center or right"},
+	{"negative width", func(l *Layout) { l.Fields[3].MaxWidth = -10 }, "cannot be negative"},
+	{"duplicate field", func(l *Layout) { l.Fields[4].Name = "major" }, `field "major": declared more than once`},
+	{"missing column", func(l *Layout) { l.Fields[4].Column = "" }, "column is required"},
+	{"unknown suffix font", func(l *Layout) { l.Fields[0].SuffixFont = "" }, "unknown suffix_font"},
+}
+
+func TestLayoutValidate(t *testing.T) {
+	for _, e := range layoutTests {
+		layout := DefaultLayout()
+		e.modify(layout)
+
+		err := layout.Validate()
+		if err == nil {
+			t.Errorf("%s: expected an error but got none", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected error containing %q, got %q", e.name, e.expected, err.Error())
+		}
+	}
+}
+
+func TestLoadLayout(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := LoadLayout(filepath.Join("..", "..", "data", "input", "layouts", "diploma.json"))
+	if err != nil {
+		t.Errorf("shipped layout should load, got %v", err)
+	}
+
+	badPath := filepath.Join(dir, "bad.json")
+	_ = os.WriteFile(badPath, []byte(`{"template": "x.pdf", "fields": [], "colour": "red"}`), 0644)
+	_, err = LoadLayout(badPath)
+	if err == nil {
+		t.Error("expected an error for unknown keys")
+	}
+
+	layout, err := loadLayoutOrDefault(filepath.Join(dir, "missing.json"))
+	if err != nil || layout == nil {
+		t.Errorf("expected the default layout for a missing file, got %v", err)
+	}
+}
@@ -35,23 +35,29 @@ type LookupMaps struct {
 }
 
 type GraduateDegree struct {
-	FullName string
-	Degree   string
-	Major    string
-	Honor    string
-	Date     string
+	FullName   string
+	Degree     string
+	Major      string
+	Honor      string
+	Date       string
+	DegreeCode string
+	DegreeType string
 }
 
 func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 	// Simulate processing steps
-	task.ProgressChan <- ProgressUpdate{Status: "Opening Excel file", Progress: 10}
+	if err := task.Report(ProgressUpdate{Status: "Opening Excel file", Progress: 10}); err != nil {
+		return err
+	}
 	f, err := excelize.OpenFile(filePath)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	task.ProgressChan <- ProgressUpdate{Status: "Reading rows", Progress: 20}
+	if err := task.Report(ProgressUpdate{Status: "Reading rows", Progress: 20}); err != nil {
+		return err
+	}
 
 	termLookupSlice, err := readTermLookup(f)
 	if err != nil {
@@ -85,7 +91,9 @@ func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 		lookupMaps.DegreeLookupMap[degree.Code] = degree
 	}
 
-	task.ProgressChan <- ProgressUpdate{Status: "Processing data", Progress: 30}
+	if err := task.Report(ProgressUpdate{Status: "Processing data", Progress: 30}); err != nil {
+		return err
+	}
 	for _, graduate := range degreeDataSlice {
 		term := lookupMaps.TermLookupMap[graduate.Term]
 		degree := lookupMaps.DegreeLookupMap[graduate.Degree]
@@ -93,11 +101,13 @@ func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 		honor := lookupMaps.DegreeLookupMap[graduate.Honor]
 
 		output := GraduateDegree{
-			FullName: graduate.FullName,
-			Degree:   degree.Text,
-			Major:    major.Text,
-			Honor:    honor.Text,
-			Date:     term.DateText,
+			FullName:   graduate.FullName,
+			Degree:     degree.Text,
+			Major:      major.Text,
+			Honor:      honor.Text,
+			Date:       term.DateText,
+			DegreeCode: graduate.Degree,
+			DegreeType: degree.CodeType,
 		}
 
 		graduateData = append(graduateData, output)
@@ -116,12 +126,14 @@ func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 
 		// Write headers
 		if i == 0 {
-			dataToWrite = append(dataToWrite, []string{"Full Name", "Degree", "Major", "Honor", "Graduation Date"})
+			dataToWrite = append(dataToWrite, []string{"Full Name", "Degree", "Major", "Honor", "Graduation Date", "Degree Code", "Degree Type"})
 			f.SetCellValue("Output", "A1", "Full Name")
 			f.SetCellValue("Output", "B1", "Degree")
 			f.SetCellValue("Output", "C1", "Major")
 			f.SetCellValue("Output", "D1", "Honor")
 			f.SetCellValue("Output", "E1", "Date")
+			f.SetCellValue("Output", "F1", "Degree Code")
+			f.SetCellValue("Output", "G1", "Degree Type")
 		}
 
 		// Collect row data for resizing columns
@@ -131,6 +143,8 @@ func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 			grad.Major,
 			grad.Honor,
 			grad.Date,
+			grad.DegreeCode,
+			grad.DegreeType,
 		}
 		dataToWrite = append(dataToWrite, row)
 
@@ -140,6 +154,8 @@ func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 		f.SetCellValue("Output", fmt.Sprintf("C%d", rowIndex), grad.Major)
 		f.SetCellValue("Output", fmt.Sprintf("D%d", rowIndex), grad.Honor)
 		f.SetCellValue("Output", fmt.Sprintf("E%d", rowIndex), grad.Date)
+		f.SetCellValue("Output", fmt.Sprintf("F%d", rowIndex), grad.DegreeCode)
+		f.SetCellValue("Output", fmt.Sprintf("G%d", rowIndex), grad.DegreeType)
 	}
 
 	// Adjust column widths based on data
@@ -152,7 +168,9 @@ func (tm *TaskManager) ProcessData(task *Task, filePath string) error {
 		return err
 	}
 
-	task.ProgressChan <- ProgressUpdate{Status: "Data processing completed", Progress: 50}
+	if err := task.Report(ProgressUpdate{Status: "Data processing completed", Progress: 50}); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -170,11 +188,11 @@ func readTermLookup(f *excelize.File) ([]TermLookup, error) {
 			continue
 		}
 
-		code, _ := strconv.Atoi(row[1])
+		code, _ := strconv.Atoi(cell(row, 1))
 		term := TermLookup{
-			Name:     row[0],
+			Name:     cell(row, 0),
 			Code:     code,
-			DateText: row[2],
+			DateText: cell(row, 2),
 		}
 		termLookupSlice = append(termLookupSlice, term)
 	}
@@ -197,9 +215,9 @@ func readDegreeLookup(f *excelize.File) ([]DegreeLookup, error) {
 		}
 
 		degree := DegreeLookup{
-			Code:     row[0],
-			Text:     row[1],
-			CodeType: row[2],
+			Code:     cell(row, 0),
+			Text:     cell(row, 1),
+			CodeType: cell(row, 2),
 		}
 		degreeLookupSlice = append(degreeLookupSlice, degree)
 	}
@@ -220,13 +238,13 @@ func readDegreeData(f *excelize.File) ([]DegreeData, error) {
 			continue
 		}
 
-		term, _ := strconv.Atoi(row[1])
+		term, _ := strconv.Atoi(cell(row, 1))
 		degreeData := DegreeData{
 			Term:     term,
-			FullName: row[6],
-			Degree:   row[7],
-			Major:    row[8],
-			Honor:    row[9],
+			FullName: cell(row, 6),
+			Degree:   cell(row, 7),
+			Major:    cell(row, 8),
+			Honor:    cell(row, 9),
 		}
 
 		degreeDataSlice = append(degreeDataSlice, degreeData)
@@ -235,6 +253,15 @@ func readDegreeData(f *excelize.File) ([]DegreeData, error) {
 	return degreeDataSlice, nil
 }
 
+// cell returns the value at index i of a row, or "" when trailing empty cells
+// were trimmed from it
+func cell(row []string, i int) string {
+	if i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
 func adjustColumnWidths(f *excelize.File, sheetName string, data [][]string) error {
 	if len(data) == 0 {
 		return errors.New("no data")
@@ -249,7 +276,7 @@ func adjustColumnWidths(f *excelize.File, sheetName string, data [][]string) err
 		}
 	}
 
-	colNames := []string{"A", "B", "C", "D", "E"} // Adjust based on your number of columns
+	colNames := []string{"A", "B", "C", "D", "E", "F", "G"} // Adjust based on your number of columns
 	for i, width := range colWidths {
 		// Set column width slightly larger than the max content width
 		err := f.SetColWidth(sheetName, colNames[i], colNames[i], float64(width)*1.2)
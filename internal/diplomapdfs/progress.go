@@ -0,0 +1,111 @@
+package diplomapdfs
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// progressInterval is the least time between two progress reports of a step
+const progressInterval = time.Second
+
+// stepProgress reports how far a task is through one step, such as
+// rendering diplomas, as a count and as a share of the task's progress
+// between from and to. It is safe for use by several workers.
+type stepProgress struct {
+	task     *Task
+	unit     string // what is counted, e.g. "diplomas rendered"
+	total    int
+	from, to int
+	started  time.Time
+
+	mu       sync.Mutex
+	done     int
+	reported time.Time
+}
+
+// newStepProgress starts counting a step of total items
+func newStepProgress(task *Task, unit string, total, from, to int) *stepProgress {
+	return &stepProgress{
+		task:    task,
+		unit:    unit,
+		total:   total,
+		from:    from,
+		to:      to,
+		started: time.Now(),
+	}
+}
+
+// add counts n more items done, or takes them back when n is negative
+// because the work is being redone. Reports go out at most once per
+// progressInterval, and always for the last item.
+func (p *stepProgress) add(n int) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+
+	now := time.Now()
+	if p.done < p.total && now.Sub(p.reported) < progressInterval {
+		return
+	}
+	p.reported = now
+	p.task.Report(p.update(now))
+}
+
+// update describes the step at time now
+func (p *stepProgress) update(now time.Time) ProgressUpdate {
+	done := p.done
+	if done < 0 {
+		done = 0
+	} else if done > p.total {
+		done = p.total
+	}
+
+	update := ProgressUpdate{
+		Status:   fmt.Sprintf("%s / %s %s", formatCount(done), formatCount(p.total), p.unit),
+		Progress: p.to,
+		Done:     done,
+		Total:    p.total,
+	}
+	if p.total > 0 {
+		update.Progress = p.from + (p.to-p.from)*done/p.total
+	}
+
+	// Estimate the time left from the pace so far
+	if done > 0 && done < p.total {
+		eta := time.Duration(float64(now.Sub(p.started)) * float64(p.total-done) / float64(done))
+		update.ETASeconds = int(eta.Round(time.Second).Seconds())
+		update.Status += ", " + formatETA(eta)
+	}
+	return update
+}
+
+// formatCount writes n with thousands separators, e.g. 2,014
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+// formatETA describes the time left in words
+func formatETA(eta time.Duration) string {
+	switch {
+	case eta < time.Minute:
+		return "less than a minute left"
+	case eta < time.Hour:
+		return fmt.Sprintf("about %d min left", int(eta.Round(time.Minute).Minutes()))
+	default:
+		eta = eta.Round(time.Minute)
+		return fmt.Sprintf("about %d h %d min left", int(eta.Hours()), int(eta.Minutes())%60)
+	}
+}
@@ -0,0 +1,105 @@
+package diplomapdfs
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/phpdave11/gofpdf"
+)
+
+func TestFormatCount(t *testing.T) {
+	tests := map[int]string{0: "0", 812: "812", 2014: "2,014", 1234567: "1,234,567", -1500: "-1,500"}
+	for n, want := range tests {
+		if got := formatCount(n); got != want {
+			t.Errorf("formatCount(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	tests := map[time.Duration]string{
+		20 * time.Second:               "less than a minute left",
+		3*time.Minute + 10*time.Second: "about 3 min left",
+		90 * time.Minute:               "about 1 h 30 min left",
+	}
+	for eta, want := range tests {
+		if got := formatETA(eta); got != want {
+			t.Errorf("formatETA(%v) = %q, want %q", eta, got, want)
+		}
+	}
+}
+
+func TestStepProgressUpdate(t *testing.T) {
+	p := newStepProgress(nil, "diplomas rendered", 2014, 60, 80)
+	p.started = time.Now().Add(-2 * time.Minute)
+	p.done = 812
+
+	update := p.update(time.Now())
+	if update.Progress != 68 {
+		t.Errorf("expected 68%%, got %d", update.Progress)
+	}
+	if update.Done != 812 || update.Total != 2014 {
+		t.Errorf("unexpected counts %d / %d", update.Done, update.Total)
+	}
+	if update.Status != "812 / 2,014 diplomas rendered, about 3 min left" {
+		t.Errorf("unexpected status %q", update.Status)
+	}
+	if update.ETASeconds < 170 || update.ETASeconds > 180 {
+		t.Errorf("expected about 177 seconds left, got %d", update.ETASeconds)
+	}
+
+	// Work taken back to be redone never shows as negative
+	p.done = -1
+	if update := p.update(time.Now()); update.Progress != 60 || update.Done != 0 {
+		t.Errorf("expected no progress, got %+v", update)
+	}
+}
+
+func TestMergePDFs(t *testing.T) {
+	tm := NewTaskManager(newMemoryStore(), 1)
+	task, _ := tm.CreateTask("merge", 1, "grads.xlsx")
+
+	// Three batches of two pages, one of which failed to render
+	var buffers [][]byte
+	for i := 0; i < 3; i++ {
+		pdf := gofpdf.New("L", "pt", "Letter", "")
+		pdf.SetFont("Helvetica", "", 12)
+		for page := 0; page < 2; page++ {
+			pdf.AddPage()
+			pdf.Text(72, 72, "Diploma")
+		}
+		var buf bytes.Buffer
+		if err := pdf.Output(&buf); err != nil {
+			t.Fatal(err)
+		}
+		buffers = append(buffers, buf.Bytes())
+	}
+	buffers = append(buffers[:1], append([][]byte{nil}, buffers[1:]...)...)
+
+	outputPath := filepath.Join(t.TempDir(), "merged.pdf")
+	progress := newStepProgress(task, "batches merged", countPages(buffers), 80, 90)
+	if err := mergePDFs(buffers, outputPath, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := api.PageCountFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 6 {
+		t.Errorf("expected 6 pages, got %d", pages)
+	}
+	if progress.done != 3 {
+		t.Errorf("expected 3 batches counted, got %d", progress.done)
+	}
+	if record, _ := tm.GetTaskRecord("merge"); record.StatusText != "3 / 3 batches merged" || record.Progress != 90 {
+		t.Errorf("expected the last batch to be reported, got %q at %d%%", record.StatusText, record.Progress)
+	}
+
+	if err := mergePDFs([][]byte{nil}, outputPath, nil); err == nil {
+		t.Error("expected an error when there is nothing to merge")
+	}
+}
@@ -0,0 +1,109 @@
+package diplomapdfs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Row statuses
+const (
+	RowPrinted = "printed"
+	RowSkipped = "skipped" // the row was missing data and never rendered
+	RowFailed  = "failed"  // rendering the row's diploma failed
+)
+
+// RowResult records what happened to one row of the Output sheet
+type RowResult struct {
+	Row      int    `json:"row"`
+	Graduate string `json:"graduate"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// RunSummary counts the row results of a run
+type RunSummary struct {
+	Printed int `json:"printed"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// summarize counts the results by status
+func summarize(results []RowResult) RunSummary {
+	var summary RunSummary
+	for _, result := range results {
+		switch result.Status {
+		case RowPrinted:
+			summary.Printed++
+		case RowSkipped:
+			summary.Skipped++
+		case RowFailed:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// exceptions returns the results that were not printed, in row order
+func exceptions(results []RowResult) []RowResult {
+	var out []RowResult
+	for _, result := range results {
+		if result.Status != RowPrinted {
+			out = append(out, result)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Row < out[j].Row })
+	return out
+}
+
+// failedRows marks every graduate in data as failed for the same reason
+func failedRows(data []DiplomaData, reason string) []RowResult {
+	var results []RowResult
+	for _, d := range data {
+		if d.Separator != "" {
+			continue
+		}
+		results = append(results, RowResult{Row: d.Row, Graduate: d.FullName, Status: RowFailed, Reason: reason})
+	}
+	return results
+}
+
+// writeExceptionsReport writes the rows that were not printed to a spreadsheet
+func writeExceptionsReport(rows []RowResult, outputPath string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheetName := "Exceptions"
+	index, err := f.NewSheet(sheetName)
+	if err != nil {
+		return err
+	}
+	f.SetActiveSheet(index)
+	_ = f.DeleteSheet("Sheet1")
+
+	data := [][]string{{"Row", "Graduate", "Status", "Reason"}}
+	for _, row := range rows {
+		data = append(data, []string{fmt.Sprint(row.Row), row.Graduate, row.Status, row.Reason})
+	}
+
+	for i, row := range data {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		values := make([]interface{}, len(row))
+		for j, value := range row {
+			values[j] = value
+		}
+		if err := f.SetSheetRow(sheetName, cell, &values); err != nil {
+			return err
+		}
+	}
+
+	if err := adjustColumnWidths(f, sheetName, data); err != nil {
+		return err
+	}
+
+	return f.SaveAs(outputPath)
+}
@@ -0,0 +1,64 @@
+package diplomapdfs
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func testResults() []RowResult {
+	return []RowResult{
+		{Row: 4, Graduate: "Bo Adams", Status: RowFailed, Reason: "template error"},
+		{Row: 2, Graduate: "Ana Lee", Status: RowPrinted},
+		{Row: 3, Graduate: "", Status: RowSkipped, Reason: "missing 'Full Name'"},
+		{Row: 5, Graduate: "Dee Allen", Status: RowPrinted},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	summary := summarize(testResults())
+	if summary != (RunSummary{Printed: 2, Skipped: 1, Failed: 1}) {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	rows := exceptions(testResults())
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 exceptions, got %d", len(rows))
+	}
+	if rows[0].Row != 3 || rows[1].Row != 4 {
+		t.Errorf("expected exceptions in row order, got rows %d and %d", rows[0].Row, rows[1].Row)
+	}
+}
+
+func TestParseDiplomaRowBlankRequired(t *testing.T) {
+	values := map[string]string{"Full Name": "Ana Lee", "Degree": " ", "Major": "Biology", "Date": "2024-05-10"}
+	_, err := parseDiplomaRow(values, DefaultLayout())
+	if err == nil || !strings.Contains(err.Error(), "missing 'Degree'") {
+		t.Errorf("expected a blank degree to be reported, got %v", err)
+	}
+}
+
+func TestWriteExceptionsReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exceptions.xlsx")
+	if err := writeExceptionsReport(exceptions(testResults()), path); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows("Exceptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[2][1] != "Bo Adams" || rows[2][3] != "template error" {
+		t.Errorf("unexpected report rows %v", rows)
+	}
+}
@@ -1,51 +1,214 @@
 package diplomapdfs
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"pawprintpublic/internal/models"
+	"runtime"
 	"sync"
 	"time"
 )
 
+// Task statuses stored in the tasks table
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// ErrCancelled is returned by a task that was cancelled before it finished
+var ErrCancelled = errors.New("task cancelled")
+
+// ErrTaskFinished is returned when cancelling a task that has already finished
+var ErrTaskFinished = errors.New("task already finished")
+
 // ProgressUpdate represents a progress update for a task
 type ProgressUpdate struct {
-	Status   string `json:"status"`
-	Progress int    `json:"progress"` // Percentage completion
-	Error    string `json:"error,omitempty"`
+	Status     string      `json:"status"`
+	Progress   int         `json:"progress"` // Percentage completion
+	Error      string      `json:"error,omitempty"`
+	Files      []string    `json:"files,omitempty"`
+	Summary    *RunSummary `json:"summary,omitempty"`
+	Exceptions []RowResult `json:"exceptions,omitempty"` // rows that were not printed
+	Done       int         `json:"done,omitempty"`       // items finished in the current step
+	Total      int         `json:"total,omitempty"`      // items in the current step
+	ETASeconds int         `json:"eta_seconds,omitempty"`
+}
+
+// TaskStore persists task records so they outlive the process
+type TaskStore interface {
+	InsertTask(t models.Task) error
+	StartTask(id string, startedAt time.Time) error
+	AddTaskProgress(id string, progress int, statusText string, data []byte) error
+	FinishTask(t models.Task) error
+	GetTaskByID(id string) (models.Task, error)
+	HeartbeatTask(id string, now time.Time) error
+	InterruptRunningTasks(staleBefore time.Time) (int64, error)
+}
+
+// Notifier is told when a task records progress or finishes, so listeners
+// in this process can catch up without waiting for the database
+type Notifier interface {
+	Notify(taskID string)
 }
 
 // Task represents a long-running task
 type Task struct {
-	ID           string
-	ProgressChan chan ProgressUpdate
-	DoneChan     chan struct{}
-	StartedAt    time.Time
-	FinishedAt   time.Time
+	ID         string
+	UserID     int
+	InputFile  string          // name of the uploaded workbook
+	Ctx        context.Context // cancelled when the task is cancelled or stops
+	DoneChan   chan struct{}
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Results    []RowResult // what happened to each row, set when generation finishes
+
+	cancel   context.CancelCauseFunc
+	store    TaskStore
+	notifier Notifier
+	ready    chan struct{} // closed when the queue lets the task start
+}
+
+// Report records a progress update as an event of the task, which SSE
+// handlers in any process read back. It never waits for a listener. Report
+// returns ErrCancelled once the task is cancelled.
+func (t *Task) Report(update ProgressUpdate) error {
+	if t.Ctx.Err() != nil {
+		return ErrCancelled
+	}
+
+	if t.store != nil {
+		data, err := json.Marshal(update)
+		if err == nil {
+			err = t.store.AddTaskProgress(t.ID, update.Progress, update.Status, data)
+		}
+		if err != nil {
+			log.Printf("Task %s: failed to save progress: %v", t.ID, err)
+		}
+	}
+	if t.notifier != nil {
+		t.notifier.Notify(t.ID)
+	}
+	return nil
+}
+
+// Cancelled reports whether the task was stopped by CancelTask
+func (t *Task) Cancelled() bool {
+	return errors.Is(context.Cause(t.Ctx), ErrCancelled)
 }
 
-// TaskManager manages tasks and their progress
+// Stop releases the task's context once its goroutine has returned
+func (t *Task) Stop() {
+	t.cancel(nil)
+}
+
+// TaskManager manages running tasks and their progress. Tasks are kept in
+// memory only while they are queued or running; their records live in the
+// store. At most maxRunning tasks run at once and the rest wait in order.
 type TaskManager struct {
 	Tasks map[string]*Task
 	Mu    *sync.RWMutex
+
+	store      TaskStore
+	notifier   Notifier
+	onFinish   func(record models.Task)
+	maxRunning int
+	running    int
+	queue      []*Task // tasks waiting to run, oldest first
+}
+
+// QueueStats describes the load on the task queue
+type QueueStats struct {
+	Queued     int
+	Running    int
+	MaxRunning int
 }
 
-// NewTaskManager creates a new TaskManager
-func NewTaskManager() *TaskManager {
+// NewTaskManager creates a new TaskManager backed by store that runs up to
+// maxRunning tasks at once. A nil store keeps tasks in memory only.
+func NewTaskManager(store TaskStore, maxRunning int) *TaskManager {
+	if maxRunning < 1 {
+		maxRunning = 1
+	}
 	return &TaskManager{
-		Tasks: make(map[string]*Task),
-		Mu:    &sync.RWMutex{},
+		Tasks:      make(map[string]*Task),
+		Mu:         &sync.RWMutex{},
+		store:      store,
+		maxRunning: maxRunning,
 	}
 }
 
-// CreateTask initializes a new task
-func (tm *TaskManager) CreateTask(taskID string) *Task {
+// SetNotifier has the manager's tasks tell n about their progress
+func (tm *TaskManager) SetNotifier(n Notifier) {
 	tm.Mu.Lock()
 	defer tm.Mu.Unlock()
+	tm.notifier = n
+}
+
+// OnFinish has the manager call fn with the stored record of each task it
+// finishes, after the record is saved
+func (tm *TaskManager) OnFinish(fn func(record models.Task)) {
+	tm.Mu.Lock()
+	defer tm.Mu.Unlock()
+	tm.onFinish = fn
+}
+
+// workers returns how many PDF workers each running task gets, sharing the
+// CPUs between the tasks that may run at once
+func (tm *TaskManager) workers() int {
+	workers := runtime.NumCPU() / tm.maxRunning
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// CreateTask records a new task for userID and starts tracking it
+func (tm *TaskManager) CreateTask(taskID string, userID int, inputFile string) (*Task, error) {
+	if tm.store != nil {
+		err := tm.store.InsertTask(models.Task{
+			ID:         taskID,
+			UserID:     userID,
+			InputFile:  inputFile,
+			Status:     StatusQueued,
+			StatusText: "Queued",
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tm.track(taskID, userID, inputFile), nil
+}
+
+// AdoptTask starts tracking a task whose record was created elsewhere, such
+// as by the web process for a job a worker has claimed
+func (tm *TaskManager) AdoptTask(record models.Task) *Task {
+	return tm.track(record.ID, record.UserID, record.InputFile)
+}
+
+// track adds a new in-memory task to the manager
+func (tm *TaskManager) track(taskID string, userID int, inputFile string) *Task {
+	ctx, cancel := context.WithCancelCause(context.Background())
 	task := &Task{
-		ID:           taskID,
-		ProgressChan: make(chan ProgressUpdate),
-		DoneChan:     make(chan struct{}),
-		StartedAt:    time.Now(),
+		ID:        taskID,
+		UserID:    userID,
+		InputFile: inputFile,
+		Ctx:       ctx,
+		DoneChan:  make(chan struct{}),
+		cancel:    cancel,
+		store:     tm.store,
+		ready:     make(chan struct{}),
 	}
+
+	tm.Mu.Lock()
+	defer tm.Mu.Unlock()
+	task.notifier = tm.notifier
 	tm.Tasks[taskID] = task
 	return task
 }
@@ -61,6 +224,248 @@ func (tm *TaskManager) GetTask(taskID string) (*Task, error) {
 	return task, nil
 }
 
+// Enqueue queues a task and runs it once a slot is free. run does the work;
+// when it returns, the task is recorded as finished. Waiting tasks report
+// their position in the queue.
+func (tm *TaskManager) Enqueue(task *Task, run func() error) {
+	tm.Mu.Lock()
+	tm.queue = append(tm.queue, task)
+	waiting := tm.dispatchLocked()
+	tm.Mu.Unlock()
+	reportPositions(waiting)
+
+	go func() {
+		defer task.Stop()
+
+		err := tm.waitForSlot(task)
+		if err == nil {
+			// The slot is freed even if run panics
+			func() {
+				defer tm.release()
+				err = run()
+			}()
+		}
+
+		if errors.Is(err, ErrCancelled) {
+			log.Printf("Task %s: cancelled", task.ID)
+		} else if err != nil {
+			// Send error update
+			task.Report(ProgressUpdate{Status: "Error", Error: err.Error()})
+		}
+
+		tm.FinishTask(task, err)
+	}()
+}
+
+// waitForSlot blocks until the task may start, or returns ErrCancelled if it
+// is cancelled while still queued
+func (tm *TaskManager) waitForSlot(task *Task) error {
+	select {
+	case <-task.ready:
+	case <-task.Ctx.Done():
+		tm.Mu.Lock()
+		started := tm.removeQueuedLocked(task)
+		waiting := tm.queue
+		tm.Mu.Unlock()
+		if started {
+			// The slot was handed over just as the task was cancelled
+			tm.release()
+		}
+		reportPositions(waiting)
+		return ErrCancelled
+	}
+
+	task.StartedAt = time.Now()
+	if tm.store != nil {
+		if err := tm.store.StartTask(task.ID, task.StartedAt); err != nil {
+			log.Printf("Task %s: failed to save start: %v", task.ID, err)
+		}
+	}
+	return task.Report(ProgressUpdate{Status: "Starting", Progress: 0})
+}
+
+// release frees the slot of a finished task and starts the next one
+func (tm *TaskManager) release() {
+	tm.Mu.Lock()
+	tm.running--
+	waiting := tm.dispatchLocked()
+	tm.Mu.Unlock()
+	reportPositions(waiting)
+}
+
+// dispatchLocked starts queued tasks while slots are free and returns the
+// tasks still waiting. tm.Mu must be held.
+func (tm *TaskManager) dispatchLocked() []*Task {
+	for tm.running < tm.maxRunning && len(tm.queue) > 0 {
+		next := tm.queue[0]
+		tm.queue = tm.queue[1:]
+		tm.running++
+		close(next.ready)
+	}
+	return append([]*Task(nil), tm.queue...)
+}
+
+// removeQueuedLocked takes a cancelled task out of the queue. It returns
+// true if the task had already been given a slot. tm.Mu must be held.
+func (tm *TaskManager) removeQueuedLocked(task *Task) bool {
+	for i, queued := range tm.queue {
+		if queued == task {
+			tm.queue = append(tm.queue[:i], tm.queue[i+1:]...)
+			return false
+		}
+	}
+	return true
+}
+
+// reportPositions tells each waiting task where it is in the queue
+func reportPositions(waiting []*Task) {
+	for i, task := range waiting {
+		task.Report(ProgressUpdate{Status: fmt.Sprintf("Queued (position %d)", i+1)})
+	}
+}
+
+// QueueStats returns how many tasks are waiting and running
+func (tm *TaskManager) QueueStats() QueueStats {
+	tm.Mu.RLock()
+	defer tm.Mu.RUnlock()
+	return QueueStats{Queued: len(tm.queue), Running: tm.running, MaxRunning: tm.maxRunning}
+}
+
+// CancelTask stops a queued or running task. The workers finish the page they
+// are on and the task's goroutine returns ErrCancelled.
+func (tm *TaskManager) CancelTask(taskID string) error {
+	task, err := tm.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-task.DoneChan:
+		return ErrTaskFinished
+	default:
+	}
+	if task.Ctx.Err() != nil {
+		return ErrTaskFinished
+	}
+
+	task.cancel(ErrCancelled)
+	return nil
+}
+
+// FinishTask records how a task ended and stops tracking it. err is the error
+// the task's goroutine returned, if any.
+func (tm *TaskManager) FinishTask(task *Task, err error) {
+	task.FinishedAt = time.Now()
+	summary := summarize(task.Results)
+
+	record := models.Task{
+		ID:         task.ID,
+		UserID:     task.UserID,
+		InputFile:  task.InputFile,
+		Status:     StatusCompleted,
+		Progress:   100,
+		StatusText: "PDF generation completed",
+		Printed:    summary.Printed,
+		Skipped:    summary.Skipped,
+		Failed:     summary.Failed,
+		FinishedAt: task.FinishedAt,
+	}
+	switch {
+	case task.Cancelled():
+		record.Status = StatusCancelled
+		record.StatusText = "Cancelled"
+		record.Progress = 0
+	case err != nil:
+		record.Status = StatusFailed
+		record.StatusText = "Error"
+		record.Error = err.Error()
+		record.Progress = 0
+	}
+
+	if tm.store != nil {
+		if err := tm.store.FinishTask(record); err != nil {
+			log.Printf("Task %s: failed to save result: %v", task.ID, err)
+		}
+	}
+
+	tm.DeleteTask(task.ID)
+	if task.notifier != nil {
+		task.notifier.Notify(task.ID)
+	}
+
+	tm.Mu.RLock()
+	onFinish := tm.onFinish
+	tm.Mu.RUnlock()
+	if onFinish != nil {
+		onFinish(record)
+	}
+}
+
+// GetTaskRecord returns the stored record of a task, running or not
+func (tm *TaskManager) GetTaskRecord(taskID string) (models.Task, error) {
+	if tm.store == nil {
+		return models.Task{}, errors.New("task not found")
+	}
+	return tm.store.GetTaskByID(taskID)
+}
+
+// Heartbeat records that the tasks the manager tracks are still alive, so
+// that no process takes them for interrupted
+func (tm *TaskManager) Heartbeat(now time.Time) error {
+	if tm.store == nil {
+		return nil
+	}
+
+	tm.Mu.RLock()
+	ids := make([]string, 0, len(tm.Tasks))
+	for id := range tm.Tasks {
+		ids = append(ids, id)
+	}
+	tm.Mu.RUnlock()
+
+	for _, id := range ids {
+		if err := tm.store.HeartbeatTask(id, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecoverInterrupted marks queued and running tasks without a heartbeat
+// since staleBefore as failed, since the process that ran them has stopped
+// and nothing will finish them. Tasks left for workers are theirs to
+// recover.
+func (tm *TaskManager) RecoverInterrupted(staleBefore time.Time) (int64, error) {
+	if tm.store == nil {
+		return 0, nil
+	}
+	return tm.store.InterruptRunningTasks(staleBefore)
+}
+
+// IsFinished reports whether a task with the given status has stopped for good
+func IsFinished(status string) bool {
+	switch status {
+	case StatusCompleted, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// RecordUpdate describes a stored task record as a progress update, for
+// listeners that connect after the task stopped running here
+func RecordUpdate(record models.Task, files []string) ProgressUpdate {
+	update := ProgressUpdate{
+		Status:   record.StatusText,
+		Progress: record.Progress,
+		Error:    record.Error,
+		Files:    files,
+	}
+	if record.Status == StatusCompleted {
+		update.Summary = &RunSummary{Printed: record.Printed, Skipped: record.Skipped, Failed: record.Failed}
+	}
+	return update
+}
+
 // DeleteTask removes a task from the manager
 func (tm *TaskManager) DeleteTask(taskID string) {
 	tm.Mu.Lock()
@@ -0,0 +1,243 @@
+package diplomapdfs
+
+import (
+	"errors"
+	"pawprintpublic/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a TaskStore that keeps records in a map
+type memoryStore struct {
+	mu         sync.Mutex
+	tasks      map[string]models.Task
+	heartbeats map[string]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{tasks: make(map[string]models.Task), heartbeats: make(map[string]time.Time)}
+}
+
+func (s *memoryStore) InsertTask(t models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[t.ID] = t
+	return nil
+}
+
+func (s *memoryStore) StartTask(id string, startedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tasks[id]
+	t.Status, t.StartedAt = StatusRunning, startedAt
+	s.tasks[id] = t
+	return nil
+}
+
+func (s *memoryStore) AddTaskProgress(id string, progress int, statusText string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tasks[id]
+	t.Progress, t.StatusText = progress, statusText
+	s.tasks[id] = t
+	return nil
+}
+
+func (s *memoryStore) FinishTask(t models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[t.ID] = t
+	return nil
+}
+
+func (s *memoryStore) GetTaskByID(id string) (models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return t, errors.New("task not found")
+	}
+	return t, nil
+}
+
+func (s *memoryStore) HeartbeatTask(id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heartbeats[id] = now
+	return nil
+}
+
+func (s *memoryStore) InterruptRunningTasks(staleBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, t := range s.tasks {
+		if (t.Status == StatusQueued || t.Status == StatusRunning) && s.heartbeats[id].Before(staleBefore) {
+			t.Status = StatusFailed
+			s.tasks[id] = t
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestCancelTask(t *testing.T) {
+	tm := NewTaskManager(nil, 1)
+	task, _ := tm.CreateTask("running", 0, "grads.xlsx")
+
+	if err := tm.CancelTask("running"); err != nil {
+		t.Fatalf("expected a running task to cancel, got %v", err)
+	}
+	if !task.Cancelled() {
+		t.Error("expected the task to report it was cancelled")
+	}
+	if err := task.Report(ProgressUpdate{Status: "still going"}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected Report to stop with ErrCancelled, got %v", err)
+	}
+	if err := tm.CancelTask("running"); !errors.Is(err, ErrTaskFinished) {
+		t.Errorf("expected cancelling twice to fail with ErrTaskFinished, got %v", err)
+	}
+
+	if err := tm.CancelTask("missing"); err == nil {
+		t.Error("expected an error for an unknown task")
+	}
+}
+
+func TestCancelFinishedTask(t *testing.T) {
+	tm := NewTaskManager(nil, 1)
+	task, _ := tm.CreateTask("finished", 0, "grads.xlsx")
+	close(task.DoneChan)
+
+	if err := tm.CancelTask("finished"); !errors.Is(err, ErrTaskFinished) {
+		t.Errorf("expected ErrTaskFinished, got %v", err)
+	}
+
+	task.Stop()
+	if task.Cancelled() {
+		t.Error("a task that stopped normally should not report it was cancelled")
+	}
+}
+
+func TestTaskRecords(t *testing.T) {
+	store := newMemoryStore()
+	tm := NewTaskManager(store, 1)
+	var finished []models.Task
+	tm.OnFinish(func(record models.Task) { finished = append(finished, record) })
+
+	task, err := tm.CreateTask("ok", 7, "grads.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := tm.GetTaskRecord("ok"); record.Status != StatusQueued || record.UserID != 7 {
+		t.Errorf("expected a queued record for user 7, got %+v", record)
+	}
+
+	// Updates are stored without waiting for a listener
+	for i := 0; i < 32; i++ {
+		if err := task.Report(ProgressUpdate{Status: "Working", Progress: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if record, _ := tm.GetTaskRecord("ok"); record.Progress != 31 || record.StatusText != "Working" {
+		t.Errorf("expected the latest progress to be recorded, got %+v", record)
+	}
+
+	task.Results = []RowResult{{Row: 2, Status: RowPrinted}, {Row: 3, Status: RowSkipped}}
+	tm.FinishTask(task, nil)
+
+	if _, err := tm.GetTask("ok"); err == nil {
+		t.Error("expected a finished task to be removed from memory")
+	}
+	record, _ := tm.GetTaskRecord("ok")
+	if record.Status != StatusCompleted || record.Printed != 1 || record.Skipped != 1 {
+		t.Errorf("unexpected completed record %+v", record)
+	}
+	if len(finished) != 1 || finished[0].UserID != 7 || finished[0].InputFile != "grads.xlsx" {
+		t.Errorf("expected the finish hook to get the record with its uploader, got %+v", finished)
+	}
+
+	failed, _ := tm.CreateTask("bad", 7, "grads.xlsx")
+	tm.FinishTask(failed, errors.New("no Output sheet"))
+	if record, _ := tm.GetTaskRecord("bad"); record.Status != StatusFailed || record.Error != "no Output sheet" {
+		t.Errorf("unexpected failed record %+v", record)
+	}
+
+	// A task is interrupted once its heartbeats stop
+	now := time.Now()
+	_, _ = tm.CreateTask("lost", 7, "grads.xlsx")
+	if err := tm.Heartbeat(now); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := tm.RecoverInterrupted(now.Add(-time.Minute)); n != 0 {
+		t.Errorf("expected a task with a recent heartbeat to be left alone, got %d interrupted", n)
+	}
+	if n, _ := tm.RecoverInterrupted(now.Add(time.Minute)); n != 1 {
+		t.Errorf("expected 1 interrupted task, got %d", n)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	store := newMemoryStore()
+	tm := NewTaskManager(store, 1)
+
+	first, _ := tm.CreateTask("first", 1, "a.xlsx")
+	second, _ := tm.CreateTask("second", 1, "b.xlsx")
+	third, _ := tm.CreateTask("third", 1, "c.xlsx")
+
+	release := make(chan struct{})
+	ran := make(chan string, 3)
+	run := func(task *Task) func() error {
+		return func() error {
+			ran <- task.ID
+			<-release
+			return nil
+		}
+	}
+
+	tm.Enqueue(first, run(first))
+	if <-ran != "first" {
+		t.Fatal("expected the first task to start straight away")
+	}
+	tm.Enqueue(second, run(second))
+	tm.Enqueue(third, run(third))
+
+	if stats := tm.QueueStats(); stats != (QueueStats{Queued: 2, Running: 1, MaxRunning: 1}) {
+		t.Errorf("unexpected queue stats %+v", stats)
+	}
+
+	if record, _ := tm.GetTaskRecord("third"); record.StatusText != "Queued (position 2)" {
+		t.Errorf("expected the third task to be second in line, got %q", record.StatusText)
+	}
+
+	// Cancelling a queued task takes it out of line
+	if err := tm.CancelTask("second"); err != nil {
+		t.Fatal(err)
+	}
+	waitStopped(t, tm, "second")
+	if record, _ := tm.GetTaskRecord("second"); record.Status != StatusCancelled {
+		t.Errorf("expected the cancelled task to be recorded as cancelled, got %q", record.Status)
+	}
+
+	close(release)
+	if <-ran != "third" {
+		t.Error("expected the third task to run after the first")
+	}
+	waitStopped(t, tm, "third")
+	if stats := tm.QueueStats(); stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("expected an empty queue, got %+v", stats)
+	}
+}
+
+// waitStopped waits for the manager to finish a task and stop tracking it
+func waitStopped(t *testing.T, tm *TaskManager, taskID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := tm.GetTask(taskID); err != nil {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not stop", taskID)
+}
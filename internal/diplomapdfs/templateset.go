@@ -0,0 +1,203 @@
+package diplomapdfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Output modes for a template set
+const (
+	OutputCombined = "combined" // one merged PDF with the groups in order
+	OutputSeparate = "separate" // one merged PDF per group
+)
+
+// DefaultGroup is the name of the group used for diplomas no rule matches
+const DefaultGroup = "default"
+
+var groupNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TemplateSet maps degree code types or degree codes to diploma layouts
+type TemplateSet struct {
+	Mode    string          `json:"mode"`
+	Default string          `json:"default"`
+	Order   []string        `json:"order,omitempty"`
+	Groups  []TemplateGroup `json:"groups"`
+
+	defaultLayout *Layout
+}
+
+// TemplateGroup selects a layout for diplomas of the listed code types or degree codes
+type TemplateGroup struct {
+	Name        string   `json:"name"`
+	CodeTypes   []string `json:"code_types,omitempty"`
+	DegreeCodes []string `json:"degree_codes,omitempty"`
+	Layout      string   `json:"layout"`
+
+	layout *Layout
+}
+
+// templateGroup is a resolved group with its loaded layout
+type templateGroup struct {
+	Name   string
+	Layout *Layout
+}
+
+// defaultTemplateSet prints every diploma with a single layout
+func defaultTemplateSet(layout *Layout) *TemplateSet {
+	return &TemplateSet{
+		Mode:          OutputCombined,
+		defaultLayout: layout,
+	}
+}
+
+// LoadTemplateSet reads a template set and loads every layout it references
+// from layoutDir
+func LoadTemplateSet(path, layoutDir string) (*TemplateSet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	var set TemplateSet
+	if err := decoder.Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to parse template set %s: %v", path, err)
+	}
+
+	if set.Mode == "" {
+		set.Mode = OutputCombined
+	}
+	if set.Default == "" {
+		set.Default = "diploma.json"
+	}
+
+	if err := set.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid template set %s: %v", path, err)
+	}
+
+	// A template set names its layouts, so a missing one is an error rather
+	// than a reason to print with the built-in layout
+	set.defaultLayout, err = LoadLayout(filepath.Join(layoutDir, set.Default))
+	if err != nil {
+		return nil, fmt.Errorf("default layout: %v", err)
+	}
+
+	for i := range set.Groups {
+		set.Groups[i].layout, err = LoadLayout(filepath.Join(layoutDir, set.Groups[i].Layout))
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", set.Groups[i].Name, err)
+		}
+	}
+
+	return &set, nil
+}
+
+// loadTemplateSetOrDefault loads the template set at path, falling back to the
+// single default layout when the file does not exist
+func loadTemplateSetOrDefault(path, layoutDir string) (*TemplateSet, error) {
+	set, err := LoadTemplateSet(path, layoutDir)
+	if errors.Is(err, os.ErrNotExist) {
+		layout, err := loadLayoutOrDefault(filepath.Join(layoutDir, "diploma.json"))
+		if err != nil {
+			return nil, err
+		}
+		return defaultTemplateSet(layout), nil
+	}
+	return set, err
+}
+
+// Validate checks the template set for problems and reports all of them at once
+func (s *TemplateSet) Validate() error {
+	var problems []string
+
+	if s.Mode != OutputCombined && s.Mode != OutputSeparate {
+		problems = append(problems, fmt.Sprintf("mode must be %q or %q", OutputCombined, OutputSeparate))
+	}
+
+	names := map[string]bool{DefaultGroup: true}
+	for i, group := range s.Groups {
+		label := fmt.Sprintf("group %d", i+1)
+		if group.Name != "" {
+			label = fmt.Sprintf("group %q", group.Name)
+		}
+
+		switch {
+		case !groupNameRe.MatchString(group.Name):
+			problems = append(problems, fmt.Sprintf("%s: name may only contain letters, digits, dashes and underscores", label))
+		case names[group.Name]:
+			problems = append(problems, fmt.Sprintf("%s: declared more than once", label))
+		}
+		names[group.Name] = true
+
+		if len(group.CodeTypes) == 0 && len(group.DegreeCodes) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: at least one code type or degree code is required", label))
+		}
+		if group.Layout == "" {
+			problems = append(problems, fmt.Sprintf("%s: layout is required", label))
+		}
+	}
+
+	for _, name := range s.Order {
+		if !names[name] {
+			problems = append(problems, fmt.Sprintf("order: unknown group %q", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// groups returns the resolved groups in output order. Groups missing from
+// Order keep their declared position after the ordered ones, with the
+// default group last.
+func (s *TemplateSet) groups() []templateGroup {
+	all := make(map[string]templateGroup, len(s.Groups)+1)
+	declared := make([]string, 0, len(s.Groups)+1)
+	for _, group := range s.Groups {
+		all[group.Name] = templateGroup{Name: group.Name, Layout: group.layout}
+		declared = append(declared, group.Name)
+	}
+	all[DefaultGroup] = templateGroup{Name: DefaultGroup, Layout: s.defaultLayout}
+	declared = append(declared, DefaultGroup)
+
+	ordered := make([]templateGroup, 0, len(all))
+	seen := make(map[string]bool, len(all))
+	for _, name := range append(s.Order, declared...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		ordered = append(ordered, all[name])
+	}
+	return ordered
+}
+
+// groupFor returns the name of the group a diploma is printed with. Degree
+// codes are matched before code types.
+func (s *TemplateSet) groupFor(degreeCode, degreeType string) string {
+	for _, group := range s.Groups {
+		for _, code := range group.DegreeCodes {
+			if strings.EqualFold(code, degreeCode) {
+				return group.Name
+			}
+		}
+	}
+	for _, group := range s.Groups {
+		for _, codeType := range group.CodeTypes {
+			if strings.EqualFold(codeType, degreeType) {
+				return group.Name
+			}
+		}
+	}
+	return DefaultGroup
+}
@@ -0,0 +1,105 @@
+package diplomapdfs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTemplateSet() *TemplateSet {
+	layout := DefaultLayout()
+	return &TemplateSet{
+		Mode:  OutputCombined,
+		Order: []string{"CERT", DefaultGroup},
+		Groups: []TemplateGroup{
+			{Name: "AAS", CodeTypes: []string{"AAS"}, Layout: "aas.json", layout: layout},
+			{Name: "CERT", CodeTypes: []string{"CERT"}, DegreeCodes: []string{"OSA"}, Layout: "cert.json", layout: layout},
+		},
+		defaultLayout: layout,
+	}
+}
+
+func TestTemplateSetGroupFor(t *testing.T) {
+	set := testTemplateSet()
+
+	var tests = []struct {
+		code     string
+		codeType string
+		expected string
+	}{
+		{"AAS.BUSI", "AAS", "AAS"},
+		{"OSA", "AAS", "CERT"},
+		{"CERT.WELD", "cert", "CERT"},
+		{"AA.GEN", "AA", DefaultGroup},
+	}
+
+	for _, e := range tests {
+		if got := set.groupFor(e.code, e.codeType); got != e.expected {
+			t.Errorf("groupFor(%q, %q): expected %s, got %s", e.code, e.codeType, e.expected, got)
+		}
+	}
+}
+
+func TestTemplateSetGroupsOrder(t *testing.T) {
+	var names []string
+	for _, group := range testTemplateSet().groups() {
+		names = append(names, group.Name)
+	}
+
+	if strings.Join(names, ",") != "CERT,default,AAS" {
+		t.Errorf("unexpected group order %v", names)
+	}
+}
+
+func TestTemplateSetValidate(t *testing.T) {
+	set := testTemplateSet()
+	set.Mode = "zip"
+	set.Order = []string{"BA"}
+	set.Groups = append(set.Groups, TemplateGroup{Name: "AAS", Layout: ""})
+
+	err := set.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for _, expected := range []string{"mode must be", `unknown group "BA"`, "declared more than once", "layout is required", "at least one code type"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error containing %q, got %q", expected, err.Error())
+		}
+	}
+}
+
+func TestLoadTemplateSetOrDefault(t *testing.T) {
+	dir := t.TempDir()
+
+	set, err := loadTemplateSetOrDefault(filepath.Join(dir, "templates.json"), dir)
+	if err != nil {
+		t.Fatalf("expected the default template set, got %v", err)
+	}
+	if groups := set.groups(); len(groups) != 1 || groups[0].Name != DefaultGroup {
+		t.Errorf("expected only the default group, got %v", groups)
+	}
+
+	// A template set whose default layout is missing is not printed with the
+	// built-in one
+	_ = os.WriteFile(filepath.Join(dir, "templates.json"), []byte(`{"default": "missing.json"}`), 0644)
+	_, err = loadTemplateSetOrDefault(filepath.Join(dir, "templates.json"), dir)
+	if err == nil {
+		t.Error("expected an error for a missing default layout")
+	}
+
+	layout, _ := json.Marshal(DefaultLayout())
+	_ = os.WriteFile(filepath.Join(dir, "diploma.json"), layout, 0644)
+	_ = os.WriteFile(filepath.Join(dir, "templates.json"), []byte(`{}`), 0644)
+	if _, err := loadTemplateSetOrDefault(filepath.Join(dir, "templates.json"), dir); err != nil {
+		t.Errorf("expected the template set with its default layout, got %v", err)
+	}
+
+	_ = os.WriteFile(filepath.Join(dir, "templates.json"), []byte(`{"groups": [{"name": "AAS", "code_types": ["AAS"], "layout": "missing.json"}]}`), 0644)
+	_, err = loadTemplateSetOrDefault(filepath.Join(dir, "templates.json"), dir)
+	if err == nil {
+		t.Error("expected an error for a group with a missing layout")
+	}
+}
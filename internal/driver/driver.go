@@ -2,14 +2,27 @@ package driver
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
+	_ "github.com/glebarez/go-sqlite"
 	_ "github.com/lib/pq"
 )
 
+// Databases that can be chosen with DB_DRIVER
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
 // DB holds the database connection pool
 type DB struct {
-	SQL *sql.DB
+	SQL    *sql.DB
+	Driver string // Postgres or SQLite
+	DSN    string
 }
 
 var dbConn = &DB{}
@@ -30,6 +43,8 @@ func ConnectSQL(dsn string) (*DB, error) {
 	db.SetConnMaxLifetime(maxDbLifetime)
 
 	dbConn.SQL = db
+	dbConn.Driver = Postgres
+	dbConn.DSN = dsn
 
 	err = testDB(db)
 	if err != nil {
@@ -38,6 +53,49 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbConn, nil
 }
 
+// ConnectSQLite opens the SQLite database file at path, creating it if it
+// does not exist. Foreign keys are enforced, and writers wait for each other
+// rather than failing while the database is busy.
+func ConnectSQLite(path string) (*DB, error) {
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "busy_timeout(10000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	dsn := path + "?" + query.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(maxOpenDbConn)
+	db.SetMaxIdleConns(maxIdleDbConn)
+
+	err = testDB(db)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{SQL: db, Driver: SQLite, DSN: dsn}, nil
+}
+
+// ConnectFromEnv connects to the database chosen by DB_DRIVER, postgres by
+// default. Postgres reads the POSTGRES_* variables and SQLite the file named
+// by SQLITE_PATH, pawprint.db by default.
+func ConnectFromEnv() (*DB, error) {
+	switch value := os.Getenv("DB_DRIVER"); value {
+	case "", Postgres:
+		dsn, err := DSNFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return ConnectSQL(dsn)
+	case SQLite:
+		return ConnectSQLite(envOr("SQLITE_PATH", "pawprint.db"))
+	default:
+		return nil, fmt.Errorf("DB_DRIVER must be %q or %q, got %q", Postgres, SQLite, value)
+	}
+}
+
 // testDB tries to ping the database
 func testDB(d *sql.DB) error {
 	err := d.Ping()
@@ -61,65 +119,35 @@ func NewDatabase(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-// package driver
-
-// import (
-// 	"database/sql"
-// 	"time"
-
-// 	_ "github.com/glebarez/go-sqlite"
-// )
-
-// // DB holds the database connection pool
-// type DB struct {
-// 	SQL *sql.DB
-// }
-
-// var dbConn = &DB{}
-
-// const maxOpenDbConn = 10
-// const maxIdleDbConn = 5
-// const maxDbLifetime = 5 * time.Minute
-
-// // ConnectSQL creates database pool for Postgres
-// func ConnectSQL() (*DB, error) {
-// 	db, err := NewDatabase()
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	db.SetMaxOpenConns(maxOpenDbConn)
-// 	db.SetMaxIdleConns(maxIdleDbConn)
-// 	db.SetConnMaxLifetime(maxDbLifetime)
-
-// 	dbConn.SQL = db
-
-// 	err = testDB(db)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	return dbConn, nil
-// }
-
-// // testDB tries to ping the database
-// func testDB(d *sql.DB) error {
-// 	err := d.Ping()
-// 	if err != nil {
-// 		return err
-// 	}
-// 	return nil
-// }
-
-// // NewDatabase creates a new database for the application
-// func NewDatabase() (*sql.DB, error) {
-// 	db, err := sql.Open("sqlite", "pawprint.db")
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	if err = db.Ping(); err != nil {
-// 		return nil, err
-// 	}
-
-// 	return db, nil
-// }
+// DSNFromEnv builds the Postgres connection string from the POSTGRES_*
+// environment variables. The password may be given in POSTGRES_PASSWORD or
+// read from the file named by POSTGRES_PASSWORD_FILE.
+func DSNFromEnv() (string, error) {
+	host := envOr("POSTGRES_HOST", "localhost")
+	port := envOr("POSTGRES_PORT", "5432")
+	user := envOr("POSTGRES_USER", "postgres")
+	dbName := envOr("POSTGRES_DB", "postgres")
+	sslmode := envOr("POSTGRES_SSLMODE", "disable")
+
+	password := os.Getenv("POSTGRES_PASSWORD")
+	if password == "" {
+		passwordFile := os.Getenv("POSTGRES_PASSWORD_FILE")
+		if passwordFile != "" {
+			content, err := os.ReadFile(passwordFile)
+			if err != nil {
+				return "", fmt.Errorf("failed to read password file: %v", err)
+			}
+			password = strings.TrimSpace(string(content))
+		}
+	}
+
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", host, port, user, password, dbName, sslmode), nil
+}
+
+// envOr returns the environment variable key, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,25 +1,42 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path/filepath"
 	"pawprintpublic/internal/config"
 	"pawprintpublic/internal/diplomapdfs"
 	"pawprintpublic/internal/driver"
 	"pawprintpublic/internal/forms"
 	"pawprintpublic/internal/helpers"
+	"pawprintpublic/internal/jobs"
+	"pawprintpublic/internal/links"
+	"pawprintpublic/internal/mailer"
 	"pawprintpublic/internal/models"
 	"pawprintpublic/internal/render"
 	"pawprintpublic/internal/repository"
 	"pawprintpublic/internal/repository/dbrepo"
+	"pawprintpublic/internal/retention"
+	"pawprintpublic/internal/roles"
+	"pawprintpublic/internal/storage"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Repo the repository used by the handlers
@@ -35,7 +52,7 @@ type Repository struct {
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
 		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		DB:  dbrepo.NewRepo(db, a),
 	}
 }
 
@@ -53,7 +70,21 @@ func NewHandlers(r *Repository) {
 }
 
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	data := make(map[string]interface{})
+	if m.App.TaskExecution == jobs.ExecutionQueue {
+		pending, claimed, err := m.DB.CountJobs()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["queue"] = diplomapdfs.QueueStats{Queued: pending, Running: claimed}
+	} else {
+		data["queue"] = m.App.TaskManager.QueueStats()
+	}
+
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 // Login shows the login page
@@ -93,7 +124,11 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id, _, accessLevel, err := m.DB.Authenticate(email, password)
-	if err != nil {
+	if errors.Is(err, repository.ErrInactiveUser) {
+		m.App.Session.Put(r.Context(), "error", "This account is deactivated; ask an admin to reactivate it")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
@@ -113,6 +148,201 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// passwordResetTTL is how long an emailed reset link works
+const passwordResetTTL = time.Hour
+
+// inviteTTL is how long the link in an invite works, which is longer because
+// a new user may not read their email straight away
+const inviteTTL = 7 * 24 * time.Hour
+
+// Why a user is emailed a link to choose a password
+const (
+	passwordLinkForgot = "forgot" // they asked for one
+	passwordLinkReset  = "reset"  // an admin reset their password
+	passwordLinkInvite = "invite" // an admin added them
+)
+
+// ForgotPassword shows the form for asking for a password reset link
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["email"] = ""
+
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostForgotPassword emails a reset link to the active user with an email.
+// The reply is the same whether or not there is one, so the form cannot be
+// used to find out who has an account.
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["email"] = email
+
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(email)
+	if err == nil && user.Active {
+		if err := m.emailPasswordLink(user, passwordLinkForgot); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If an account uses that email, a link to reset its password is on its way")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form for choosing a new password, if the token
+// from the emailed link still works
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := m.DB.GetPasswordReset(hashToken(token), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This reset link has expired or was already used. Ask for a new one.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["token"] = token
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostResetPassword sets a new password with a token from an emailed link,
+// which then stops working
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	token := r.Form.Get("token")
+	password := r.Form.Get("password")
+	form := forms.New(r.PostForm)
+	form.MinLength("password", minPasswordLength)
+	if r.Form.Get("confirm_password") != password {
+		form.Errors.Add("confirm_password", "The passwords do not match")
+	}
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["token"] = token
+
+		render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	_, err = m.DB.UsePasswordReset(hashToken(token), string(hash), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This reset link has expired or was already used. Ask for a new one.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Your password has been reset. Log in with the new one.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// hashToken is how a reset token is stored, so the table alone cannot be used
+// to reset passwords
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// emailPasswordLink stores a single-use token for a user and emails them a
+// link to choose a password with it. reason is one of the passwordLink
+// constants.
+func (m *Repository) emailPasswordLink(user models.User, reason string) error {
+	ttl, subject := passwordResetTTL, "Reset your PawPrint password"
+	switch reason {
+	case passwordLinkReset:
+		subject = "Your PawPrint password was reset"
+	case passwordLinkInvite:
+		ttl, subject = inviteTTL, "You have been invited to PawPrint"
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	err = m.DB.InsertPasswordReset(user.ID, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	query := url.Values{"token": {token}}
+
+	// The mailer calls Done once the message is sent
+	m.App.Mailer.Wait.Add(1)
+	m.App.Mailer.MailerChan <- mailer.Message{
+		To:       user.Email,
+		Subject:  subject,
+		Template: "password-reset",
+		DataMap: map[string]any{
+			"firstName": user.FirstName,
+			"email":     user.Email,
+			"reason":    reason,
+			"resetURL":  m.App.BaseURL + "/reset-password?" + query.Encode(),
+			"validFor":  describeTTL(ttl),
+		},
+	}
+	return nil
+}
+
+// describeTTL says how long a link works, in days, hours or minutes
+func describeTTL(ttl time.Duration) string {
+	switch {
+	case ttl >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(ttl.Hours()/24))
+	case ttl >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(ttl.Hours()))
+	default:
+		return fmt.Sprintf("%d minutes", int(ttl.Minutes()))
+	}
+}
+
 // Home is the home page handler
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
@@ -147,13 +377,26 @@ func (m *Repository) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read the file data into memory
-	fileData, err := io.ReadAll(file)
-	if err != nil {
-		m.App.ErrorLog.Println("Error parsing form data")
-		http.Error(w, "Unable to read file", http.StatusInternalServerError)
+	// Output options
+	opts := diplomapdfs.Options{
+		BatchSize:  100,
+		SortBy:     r.FormValue("sort_by"),
+		Separators: r.FormValue("separators") != "",
+	}
+	if err := diplomapdfs.ValidateSortBy(opts.SortBy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if r.FormValue("zip") != "" {
+		opts.ZipPattern = r.FormValue("zip_pattern")
+		if opts.ZipPattern == "" {
+			opts.ZipPattern = diplomapdfs.DefaultZipPattern
+		}
+		if err := diplomapdfs.ValidateZipPattern(opts.ZipPattern); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid ZIP file name pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Get the session ID
 	sessionID := m.App.Session.Token(r.Context())
@@ -162,80 +405,145 @@ func (m *Repository) UploadHandler(w http.ResponseWriter, r *http.Request) {
 	taskID := uuid.New().String()
 	fileName := fmt.Sprintf("%s.xlsx", taskID)
 
-	// Store the XLSX file in the database
-	err = m.DB.InsertFile(taskID, sessionID, fileName, "xlsx", fileData)
-	if err != nil {
-		m.App.ErrorLog.Println("Error parsing form data")
-		http.Error(w, "Unable to save file", http.StatusInternalServerError)
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	inputFile := filepath.Base(handler.Filename)
+	upload := models.File{
+		TaskID:    taskID,
+		SessionID: sessionID,
+		UserID:    userID,
+		FileName:  fileName,
+		FileType:  "xlsx",
+	}
+
+	// Leave the task for a worker process when the web tier does not run tasks itself
+	if m.App.TaskExecution == jobs.ExecutionQueue {
+		err = m.submitJob(r.Context(), upload, inputFile, file, handler.Size, opts)
+		if err != nil {
+			m.App.ErrorLog.Println("Error queueing task:", err)
+			http.Error(w, "Unable to start task", http.StatusInternalServerError)
+			return
+		}
+		response := map[string]string{"task_id": taskID}
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
 	// Create a new task
-	task := m.App.TaskManager.CreateTask(taskID)
+	task, err := m.App.TaskManager.CreateTask(taskID, userID, inputFile)
+	if err != nil {
+		m.App.ErrorLog.Println("Error creating task:", err)
+		http.Error(w, "Unable to start task", http.StatusInternalServerError)
+		return
+	}
 
-	// Start the processing function in a Goroutine
-	go func() {
-		defer close(task.ProgressChan)
+	// Store the XLSX file
+	err = storage.Save(r.Context(), m.App.Storage, m.DB, upload, file, handler.Size)
+	if err != nil {
+		m.App.ErrorLog.Println("Error saving uploaded file:", err)
+		m.App.TaskManager.FinishTask(task, err)
+		task.Stop()
+		http.Error(w, "Unable to save file", http.StatusInternalServerError)
+		return
+	}
 
-		err := m.processFileFromDB(task, sessionID)
-		if err != nil {
-			// Send error update
-			task.ProgressChan <- diplomapdfs.ProgressUpdate{Status: "Error", Error: err.Error()}
-		}
-	}()
+	// Queue the processing; it starts once a slot is free
+	m.App.TaskManager.Enqueue(task, func() error {
+		return jobs.Process(m.App.TaskManager, m.DB, m.App.Storage, task, sessionID, opts)
+	})
 
 	// Return the task ID to the client
 	response := map[string]string{"task_id": taskID}
 	json.NewEncoder(w).Encode(response)
 }
 
-func (m *Repository) processFileFromDB(task *diplomapdfs.Task, sessionID string) error {
-	// Retrieve the XLSX file data from the database
-	xlsxData, err := m.DB.GetFile(task.ID, "xlsx")
+// submitJob records a task and its workbook and leaves it in the jobs table
+// for a worker. The task is marked failed if it cannot be queued. Its status
+// gives its place among the jobs waiting when it was submitted, which is not
+// updated while it waits.
+func (m *Repository) submitJob(ctx context.Context, upload models.File, inputFile string, r io.Reader, size int64, opts diplomapdfs.Options) error {
+	pending, _, err := m.DB.CountJobs()
 	if err != nil {
 		return err
 	}
 
-	// Write the XLSX data to a temporary file
-	tmpXlsxFilePath := fmt.Sprintf("./tmp/%s.xlsx", task.ID)
-	err = os.WriteFile(tmpXlsxFilePath, xlsxData, 0644)
+	taskID := upload.TaskID
+	err = m.DB.InsertTask(models.Task{
+		ID:         taskID,
+		UserID:     upload.UserID,
+		InputFile:  inputFile,
+		Status:     diplomapdfs.StatusQueued,
+		StatusText: fmt.Sprintf("Queued (position %d)", pending+1),
+	})
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmpXlsxFilePath)
 
-	// Proceed with processing
-	err = m.App.TaskManager.ProcessData(task, tmpXlsxFilePath)
+	err = storage.Save(ctx, m.App.Storage, m.DB, upload, r, size)
+	if err == nil {
+		err = jobs.Submit(m.DB, taskID, upload.SessionID, opts)
+	}
 	if err != nil {
-		return err
+		m.DB.FinishTask(models.Task{
+			ID:         taskID,
+			Status:     diplomapdfs.StatusFailed,
+			StatusText: "Error",
+			Error:      err.Error(),
+			FinishedAt: time.Now(),
+		})
 	}
+	return err
+}
 
-	// Generate PDFs
-	err = m.App.TaskManager.GeneratePdfs(task, tmpXlsxFilePath, 100)
+// JobsPage lists the signed-in user's past runs with links to their files
+func (m *Repository) JobsPage(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	tasks, err := m.DB.GetTasksByUser(userID, 100)
 	if err != nil {
-		return err
+		helpers.ServerError(w, err)
+		return
 	}
 
-	// Read the generated PDF file into memory
-	pdfFilePath := fmt.Sprintf("./tmp/%s.pdf", task.ID)
-	pdfData, err := os.ReadFile(pdfFilePath)
-	if err != nil {
-		return err
+	data := make(map[string]interface{})
+	data["tasks"] = tasks
+
+	render.Template(w, r, "jobs.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// PreviewHandler renders a single diploma inline so the graduation office can
+// check how a name wraps before running a full batch
+func (m *Repository) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	form.Required("name", "degree", "major", "date")
+	if !form.Valid() {
+		http.Error(w, "name, degree, major and date are required", http.StatusBadRequest)
+		return
 	}
 
-	// Store the PDF file in the database
-	err = m.DB.InsertFile(task.ID, sessionID, fmt.Sprintf("%s.pdf", task.ID), "pdf", pdfData)
-	if err != nil {
-		return err
+	values := map[string]string{
+		"Full Name":   form.Get("name"),
+		"Degree":      form.Get("degree"),
+		"Major":       form.Get("major"),
+		"Honor":       form.Get("honor"),
+		"Date":        form.Get("date"),
+		"Degree Code": form.Get("degree_code"),
+		"Degree Type": form.Get("degree_type"),
 	}
 
-	// Optionally delete the PDF file from disk
-	err = os.Remove(pdfFilePath)
-	if err != nil {
-		m.App.ErrorLog.Println("Error removing PDF file from disk:", err)
+	pdfData, err := diplomapdfs.RenderPreview(r.Context(), values)
+	if errors.Is(err, diplomapdfs.ErrInvalidRow) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	return nil
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=\"preview.pdf\"")
+	w.Write(pdfData)
 }
 
 // TermSelectPage is the term select handler
@@ -252,6 +560,12 @@ func (m *Repository) TermSelectPage(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// SSEHandler streams the progress events of a task. Events are read from
+// the database, so the task may run in any process; the progress broker
+// says when to look for new ones. Each event carries its id, so a browser
+// that reconnects with Last-Event-ID picks up where it left off, and any
+// number of viewers can follow the same task. The stream ends with a done or
+// cancelled event once the task has finished.
 func (m *Repository) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -267,41 +581,72 @@ func (m *Repository) SSEHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve the task
-	task, err := m.App.TaskManager.GetTask(taskID)
-	if err != nil {
+	record, err := m.DB.GetTaskByID(taskID)
+	if err != nil || !m.canAccessTask(r, record.UserID) {
 		m.App.ErrorLog.Println("Task not found")
 		http.Error(w, "Task not found", http.StatusNotFound)
 		return
 	}
 
+	// Subscribe before reading, so progress recorded in between is not missed
+	wake, unsubscribe := m.App.Progress.Subscribe(taskID)
+	defer unsubscribe()
+
+	// Look again now and then in case a notification was lost
+	poll := time.NewTicker(5 * time.Second)
+	defer poll.Stop()
+
 	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*") // Adjust as needed
+	setSSEHeaders(w)
 
-	// Send the retry directive
-	fmt.Fprintf(w, "retry: 0\n\n")
+	// Have the browser reconnect shortly if the connection drops
+	fmt.Fprintf(w, "retry: 2000\n\n")
 	flusher.Flush()
 
-	// Listen to the progress channel and send updates
+	lastID := lastEventID(r)
 	for {
-		select {
-		case update, ok := <-task.ProgressChan:
-			if !ok {
-				// Channel closed, task completed
-				// Send final event
-				fmt.Fprintf(w, "event: done\ndata: Task completed\n\n")
-				flusher.Flush()
+		// Read the record before the events: every event is recorded before
+		// the task finishes, so a finished record means none are left
+		record, err = m.DB.GetTaskByID(taskID)
+		if err != nil {
+			m.App.ErrorLog.Println("Error reading task:", err)
+			return
+		}
+		events, err := m.DB.GetTaskProgress(taskID, lastID)
+		if err != nil {
+			m.App.ErrorLog.Println("Error reading task progress:", err)
+			return
+		}
+
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, event.Data)
+			lastID = event.ID
+		}
+		if lastID == 0 && len(events) == 0 {
+			// Nothing recorded yet, or the events were cleaned up, so
+			// describe the task from its record
+			if err := m.sendTaskRecord(w, record); err != nil {
+				m.App.ErrorLog.Println("Error reading task files:", err)
 				return
 			}
-			data, err := json.Marshal(update)
-			if err != nil {
-				continue
+		}
+
+		if diplomapdfs.IsFinished(record.Status) {
+			if record.Status == diplomapdfs.StatusCancelled {
+				fmt.Fprintf(w, "event: cancelled\ndata: Task cancelled\n\n")
+			} else {
+				fmt.Fprintf(w, "event: done\ndata: Task completed\n\n")
 			}
-			fmt.Fprintf(w, "data: %s\n\n", data)
 			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-wake:
+		case <-poll.C:
+			// Keep idle connections open through proxies
+			fmt.Fprintf(w, ": keep-alive\n\n")
 		case <-r.Context().Done():
 			// Client disconnected
 			return
@@ -309,21 +654,182 @@ func (m *Repository) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// lastEventID returns the id of the last progress event a reconnecting
+// browser received, or 0 for a new stream
+func lastEventID(r *http.Request) int64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// taskOwner returns the id of the user who started a task
+func (m *Repository) taskOwner(taskID string) (int, error) {
+	if task, err := m.App.TaskManager.GetTask(taskID); err == nil {
+		return task.UserID, nil
+	}
+	record, err := m.App.TaskManager.GetTaskRecord(taskID)
+	return record.UserID, err
+}
+
+// canAccessTask reports whether the signed-in user may see a task owned by
+// ownerID. Admins may see every task.
+func (m *Repository) canAccessTask(r *http.Request, ownerID int) bool {
+	if roles.Role(m.App.Session.GetInt(r.Context(), "access_level")).Can(roles.ViewAllJobs) {
+		return true
+	}
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	return userID != 0 && userID == ownerID
+}
+
+// setSSEHeaders sets the headers of an event stream response
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Adjust as needed
+}
+
+// sendTaskRecord sends the stored state of a task as a progress update,
+// listing its output files once it has finished
+func (m *Repository) sendTaskRecord(w http.ResponseWriter, record models.Task) error {
+	var names []string
+	if diplomapdfs.IsFinished(record.Status) {
+		files, err := m.DB.GetTaskFiles(record.ID)
+		if err != nil {
+			return err
+		}
+
+		// List the outputs, leaving out the uploaded workbook
+		for _, file := range files {
+			if file.FileType != "xlsx" {
+				names = append(names, file.FileName)
+			}
+		}
+	}
+
+	data, err := json.Marshal(diplomapdfs.RecordUpdate(record, names))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	return nil
+}
+
+// CancelTaskHandler stops a running task; its SSE stream ends with a cancelled event
+func (m *Repository) CancelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "id")
+
+	task, err := m.App.TaskManager.GetTask(taskID)
+	if err != nil {
+		// Not running here, so it may be waiting for or held by a worker
+		m.cancelJob(w, r, taskID)
+		return
+	}
+	if !m.canAccessTask(r, task.UserID) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	err = m.App.TaskManager.CancelTask(taskID)
+	if errors.Is(err, diplomapdfs.ErrTaskFinished) {
+		http.Error(w, "Task has already finished", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{"task_id": taskID, "status": "cancelling"}
+	json.NewEncoder(w).Encode(response)
+}
+
+// cancelJob cancels a task left in the jobs table. A job no worker has
+// claimed is removed and the task recorded as cancelled; a claimed one is
+// flagged, and the worker stops the task at its next heartbeat.
+func (m *Repository) cancelJob(w http.ResponseWriter, r *http.Request, taskID string) {
+	record, err := m.DB.GetTaskByID(taskID)
+	if err != nil || !m.canAccessTask(r, record.UserID) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	status, err := m.DB.CancelJob(taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Task has already finished", http.StatusConflict)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if status == "pending" {
+		err = m.DB.FinishTask(models.Task{
+			ID:         taskID,
+			Status:     diplomapdfs.StatusCancelled,
+			StatusText: "Cancelled",
+			FinishedAt: time.Now(),
+		})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	response := map[string]string{"task_id": taskID, "status": "cancelling"}
+	json.NewEncoder(w).Encode(response)
+}
+
+// StartCleanupJob removes files past their retention, and old progress
+// events, at every sweep interval of the retention policy
 func (m *Repository) StartCleanupJob() {
-	ticker := time.NewTicker(1 * time.Hour)
+	ticker := time.NewTicker(m.App.Retention.Interval)
 	go func() {
 		for range ticker.C {
-			err := m.DB.DeleteOldFiles(24 * time.Hour)
+			m.deleteExpiredFiles(time.Now())
+			err := m.DB.DeleteOldProgress(24 * time.Hour)
 			if err != nil {
-				m.App.ErrorLog.Println("Error cleaning up old files:", err)
+				m.App.ErrorLog.Println("Error cleaning up old progress:", err)
 			}
 		}
 	}()
 }
 
+// deleteExpiredFiles removes the files kept past the retention of their type,
+// except those of tasks on hold. Contents go first, so a record is only
+// dropped once nothing is left behind in storage.
+func (m *Repository) deleteExpiredFiles(now time.Time) {
+	for _, fileType := range retention.FileTypes {
+		keep, ok := m.App.Retention.Keep(fileType)
+		if !ok {
+			continue
+		}
+
+		files, err := m.DB.GetExpiredFiles(fileType, now.Add(-keep))
+		if err != nil {
+			m.App.ErrorLog.Printf("Error cleaning up %s files: %v", fileType, err)
+			continue
+		}
+		for _, f := range files {
+			err := m.App.Storage.Delete(context.Background(), f.ObjectKey)
+			if err == nil {
+				err = m.DB.DeleteFile(f.ID)
+			}
+			if err != nil {
+				m.App.ErrorLog.Printf("Error cleaning up file %s: %v", f.ObjectKey, err)
+			}
+		}
+	}
+}
+
 func (m *Repository) DownloadHandler(w http.ResponseWriter, r *http.Request) {
 	src := chi.URLParam(r, "src")
-	if src != "pdf" && src != "xlsx" {
+	if src != "pdf" && src != "xlsx" && src != "processed" && src != "zip" && src != "exceptions" {
 		http.Error(w, "Incorrect src", http.StatusBadRequest)
 		return
 	}
@@ -334,23 +840,26 @@ func (m *Repository) DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve the file from the database
-	fileData, err := m.DB.GetFile(taskID, src)
-	if err != nil {
+	ownerID, err := m.taskOwner(taskID)
+	if err != nil || !m.canAccessTask(r, ownerID) {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
-	contentType := "application/octet-stream"
-	if src == "pdf" {
-		contentType = "application/pdf"
-	} else if src == "xlsx" {
-		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	// Look up the file, by name when a task has more than one
+	var file models.File
+	if name := r.URL.Query().Get("name"); name != "" {
+		file, err = m.DB.GetFileByName(taskID, src, filepath.Base(name))
+	} else {
+		file, err = m.DB.GetFile(taskID, src)
+	}
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", taskID, src))
-	w.Write(fileData)
+	record, _ := m.App.TaskManager.GetTaskRecord(taskID)
+	m.writeFile(w, r, file, record.InputFile)
 
 	// Optionally, clean up the task and files
 	// m.App.TaskManager.DeleteTask(taskID)
@@ -360,46 +869,239 @@ func (m *Repository) DownloadHandler(w http.ResponseWriter, r *http.Request) {
 	// }
 }
 
+// SharedDownloadHandler serves a file of a task through a signed link from a
+// completion email, without signing in
+func (m *Repository) SharedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if m.App.Links == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	err := m.App.Links.Verify(query, time.Now())
+	if errors.Is(err, links.ErrExpired) {
+		http.Error(w, "This link has expired. Sign in to download the file from My Jobs.", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// The signature covers the task and file name; look up the file's type
+	taskID, fileName := query.Get("task_id"), query.Get("name")
+	files, err := m.DB.GetTaskFiles(taskID)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	for _, f := range files {
+		if f.FileName != fileName {
+			continue
+		}
+		record, err := m.DB.GetTaskByID(taskID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.writeFile(w, r, f, record.InputFile)
+		return
+	}
+	http.Error(w, "File not found", http.StatusNotFound)
+}
+
+// writeFile serves a stored file from storage as an attachment named after
+// the uploaded workbook. Range requests let slow downloads resume, and the
+// checksum is the ETag.
+func (m *Repository) writeFile(w http.ResponseWriter, r *http.Request, f models.File, inputFile string) {
+	object, err := m.App.Storage.Open(r.Context(), f.ObjectKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	defer object.Close()
+
+	contentType := "application/octet-stream"
+	if f.FileType == "pdf" {
+		contentType = "application/pdf"
+	} else if f.FileType == "xlsx" || f.FileType == "processed" || f.FileType == "exceptions" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	} else if f.FileType == "zip" {
+		contentType = "application/zip"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": f.DownloadName(inputFile),
+	}))
+	if f.Checksum != "" {
+		w.Header().Set("ETag", `"`+f.Checksum+`"`)
+	}
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, f.FileName, f.UploadTime, object)
+}
+
+// retentionHorizon is how far ahead the retention page looks for files due
+// to be removed
+const retentionHorizon = 7 * 24 * time.Hour
+
+// AdminRetention shows the retention rules, the files the cleanup job removes
+// next and the tasks on hold
+func (m *Repository) AdminRetention(w http.ResponseWriter, r *http.Request) {
+	policy := m.App.Retention
+	horizon := time.Now().Add(retentionHorizon)
+
+	var files []models.File
+	for _, fileType := range retention.FileTypes {
+		keep, ok := policy.Keep(fileType)
+		if !ok {
+			continue
+		}
+		expiring, err := m.DB.GetExpiredFiles(fileType, horizon.Add(-keep))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		files = append(files, expiring...)
+	}
+
+	held, err := m.DB.GetHeldTasks()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = policy.List()
+	data["interval"] = policy.Interval
+	data["upcoming"] = policy.Upcoming(files, horizon)
+	data["held"] = held
+	data["now"] = time.Now()
+
+	render.Template(w, r, "admin-retention.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminTaskHold places a task on hold, so cleanup keeps its files until it
+// is released, or releases it
+func (m *Repository) AdminTaskHold(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/retention", http.StatusSeeOther)
+		return
+	}
+
+	taskID := strings.TrimSpace(r.Form.Get("task_id"))
+	hold := r.Form.Get("hold") == "true"
+	if taskID == "" {
+		m.App.Session.Put(r.Context(), "error", "A task id is required")
+		http.Redirect(w, r, "/admin/retention", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.SetTaskHold(taskID, hold)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("No task with id %s", taskID))
+		http.Redirect(w, r, "/admin/retention", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if hold {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Task %s is on hold", taskID))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Task %s is released", taskID))
+	}
+	http.Redirect(w, r, "/admin/retention", http.StatusSeeOther)
+}
+
+// AdminUsers lists every user with their status
 func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := m.DB.AllUsers()
 	if err != nil {
-		helpers.ServerError(w, errors.New("error fetching users"))
+		helpers.ServerError(w, err)
+		return
 	}
 
 	data := make(map[string]interface{})
 	data["users"] = users
 	data["usersLength"] = len(users)
+	data["currentUserID"] = m.App.Session.GetInt(r.Context(), "user_id")
+	data["roles"] = roles.All
 
 	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
 
+// AdminEditUser saves the name, email and role of a user. Errors are sent as
+// JSON with a message for the users page to show. Admins cannot change their
+// own role, so they cannot lock themselves out.
 func (m *Repository) AdminEditUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&user); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		editUserError(w, http.StatusBadRequest, "Bad request")
 		return
 	}
+	user.Email = strings.TrimSpace(user.Email)
 
 	// Perform server-side validation
 	if user.FirstName == "" || user.LastName == "" || user.Email == "" || user.AccessLevel == 0 {
-		http.Error(w, "All fields are required", http.StatusBadRequest)
+		editUserError(w, http.StatusBadRequest, "All fields are required")
+		return
+	}
+	if !user.Role().Valid() {
+		editUserError(w, http.StatusBadRequest, "Unknown role")
 		return
 	}
 
 	// Validate email format (you can use regex or a package)
 	if !helpers.IsValidEmail(user.Email) {
-		http.Error(w, "Invalid email format", http.StatusBadRequest)
+		editUserError(w, http.StatusBadRequest, "Invalid email format")
 		return
 	}
 
-	// Update the user in the database
-	// Assume updateUser is a function that updates the user and returns an error if any
-	err := m.DB.UpdateUser(user)
+	existing, err := m.DB.GetUserByID(user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		editUserError(w, http.StatusNotFound, "No such user")
+		return
+	} else if err != nil {
+		editUserError(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	if m.isCurrentUser(r, user.ID) && user.AccessLevel != existing.AccessLevel {
+		editUserError(w, http.StatusBadRequest, "You cannot change your own role")
+		return
+	}
+	taken, err := m.emailTaken(user.Email, user.ID)
 	if err != nil {
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		editUserError(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	if taken {
+		editUserError(w, http.StatusConflict, "A user with this email already exists")
+		return
+	}
+
+	// Update only the user with this id
+	err = m.DB.UpdateUser(user)
+	if errors.Is(err, sql.ErrNoRows) {
+		editUserError(w, http.StatusNotFound, "No such user")
+		return
+	} else if err != nil {
+		editUserError(w, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
@@ -408,8 +1110,274 @@ func (m *Repository) AdminEditUser(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": "User updated successfully"})
 }
 
+// editUserError answers an edit on the users page with a status and a message
+func editUserError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// AdminAddUser shows the form for adding a user
 func (m *Repository) AdminAddUser(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["user"] = models.User{AccessLevel: int(roles.Operator)}
+	data["roles"] = roles.All
+
+	render.Template(w, r, "admin-add-user.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostAdminAddUser adds a user with the password an admin chose or, for an
+// invite, with no password they can sign in with and emails them a link to
+// choose one
+func (m *Repository) PostAdminAddUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/users/add", http.StatusSeeOther)
+		return
+	}
+
+	invite := r.Form.Get("invite") == "true"
+	accessLevel, _ := strconv.Atoi(r.Form.Get("access_level"))
+	user := models.User{
+		FirstName:   strings.TrimSpace(r.Form.Get("first_name")),
+		LastName:    strings.TrimSpace(r.Form.Get("last_name")),
+		Email:       strings.TrimSpace(r.Form.Get("email")),
+		AccessLevel: accessLevel,
+		Active:      true,
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+	if !user.Role().Valid() {
+		form.Errors.Add("access_level", "Choose a role")
+	}
+	if !invite {
+		form.MinLength("password", minPasswordLength)
+	}
+	if form.Errors.Get("email") == "" {
+		taken, err := m.emailTaken(user.Email, 0)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if taken {
+			form.Errors.Add("email", "A user with this email already exists")
+		}
+	}
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["user"] = user
+		data["invite"] = invite
+		data["roles"] = roles.All
 
+		render.Template(w, r, "admin-add-user.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	var hash []byte
+	if invite {
+		hash, err = unusablePassword()
+	} else {
+		hash, err = bcrypt.GenerateFromPassword([]byte(r.Form.Get("password")), passwordCost)
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user.ID, err = m.DB.InsertUser(user, string(hash))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if invite {
+		if err := m.emailPasswordLink(user, passwordLinkInvite); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invited %s", user.Email))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Added %s %s", user.FirstName, user.LastName))
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminSetUserActive deactivates a user, so they can no longer sign in, or
+// reactivates them. Admins cannot deactivate themselves.
+func (m *Repository) AdminSetUserActive(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.adminUserFromForm(w, r, "update")
+	if !ok {
+		return
+	}
+	active := r.Form.Get("active") == "true"
+	if !active && m.isCurrentUser(r, user.ID) {
+		m.App.Session.Put(r.Context(), "error", "You cannot deactivate yourself")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.SetUserActive(user.ID, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !active {
+		m.signOutUser(r.Context(), user.ID)
+	}
+
+	if active {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s is reactivated", user.Email))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s is deactivated", user.Email))
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDeleteUser removes a user for good. Their tasks are kept without an
+// owner. Admins cannot delete themselves.
+func (m *Repository) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.adminUserFromForm(w, r, "delete")
+	if !ok {
+		return
+	}
+	if m.isCurrentUser(r, user.ID) {
+		m.App.Session.Put(r.Context(), "error", "You cannot delete yourself")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.DeleteUser(user.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.signOutUser(r.Context(), user.ID)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Deleted %s", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminResetPassword stops a user's password from working, signs them out
+// and emails them a link to choose a new one
+func (m *Repository) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.adminUserFromForm(w, r, "reset")
+	if !ok {
+		return
+	}
+
+	hash, err := unusablePassword()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.UpdatePassword(user.ID, string(hash))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.signOutUser(r.Context(), user.ID)
+
+	if err := m.emailPasswordLink(user, passwordLinkReset); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("A link to choose a new password was emailed to %s", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// minPasswordLength is the shortest password that may be set
+const minPasswordLength = 8
+
+// passwordCost is the bcrypt cost new passwords are hashed with
+const passwordCost = 12
+
+// adminUserFromForm looks up the user named by the user_id form field. When
+// it returns false it has already redirected back to the users page with an
+// error saying what could not be done.
+func (m *Repository) adminUserFromForm(w http.ResponseWriter, r *http.Request, action string) (models.User, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return models.User{}, false
+	}
+
+	id, err := strconv.Atoi(r.Form.Get("user_id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Choose a user to %s", action))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return models.User{}, false
+	}
+
+	user, err := m.DB.GetUserByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("No user with id %d", id))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return models.User{}, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// isCurrentUser reports whether id is the signed in user
+func (m *Repository) isCurrentUser(r *http.Request, id int) bool {
+	return m.App.Session.GetInt(r.Context(), "user_id") == id
+}
+
+// signOutUser destroys every stored session of a user. The change it follows
+// is already saved, so a failure is logged rather than failing the request.
+func (m *Repository) signOutUser(ctx context.Context, id int) {
+	err := m.App.Session.Iterate(ctx, func(ctx context.Context) error {
+		if m.App.Session.GetInt(ctx, "user_id") != id {
+			return nil
+		}
+		return m.App.Session.Destroy(ctx)
+	})
+	if err != nil {
+		m.App.ErrorLog.Printf("Error signing out user %d: %v", id, err)
+	}
+}
+
+// emailTaken reports whether a user other than exceptID already has an
+// email, ignoring case. New users have no id, so pass 0 for them.
+func (m *Repository) emailTaken(email string, exceptID int) (bool, error) {
+	user, err := m.DB.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil && user.ID != exceptID, err
+}
+
+// unusablePassword returns the hash of a random password nobody is told, for
+// users who are to choose their own with an emailed link. It is a real hash,
+// so signing in as them takes as long as for anyone else.
+func unusablePassword() ([]byte, error) {
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+}
+
+// randomToken returns n random bytes encoded for use in URLs
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 // func (m *Repository) DownloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -1,9 +1,17 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"pawprintpublic/internal/models"
+	"strings"
 	"testing"
+	"time"
 )
 
 //type postData struct {
@@ -18,6 +26,15 @@ var theTests = []struct {
 	expectedStatusCode int
 }{
 	{"home", "/", "GET", http.StatusOK},
+	{"preview missing fields", "/preview?name=Jane+Doe", "GET", http.StatusBadRequest},
+	{"jobs", "/jobs", "GET", http.StatusOK},
+	{"admin dashboard", "/admin", "GET", http.StatusOK},
+	{"admin retention", "/admin/retention", "GET", http.StatusOK},
+	{"admin users", "/admin/users", "GET", http.StatusOK},
+	{"admin add user", "/admin/users/add", "GET", http.StatusOK},
+	{"forgot password", "/forgot-password", "GET", http.StatusOK},
+	{"sse task of another user", "/sse?task_id=finished", "GET", http.StatusNotFound},
+	{"sse unknown task", "/sse?task_id=missing", "GET", http.StatusNotFound},
 	// {"sa", "/search-availability", "GET", http.StatusOK},
 	// {"contact", "/contact", "GET", http.StatusOK},
 	// {"non-existent", "/green/eggs/and/ham", "GET", http.StatusNotFound},
@@ -50,6 +67,103 @@ func TestHandlers(t *testing.T) {
 	}
 }
 
+// data for the SSE ownership tests; the test repo's "finished" task belongs to user 1
+var taskOwnershipTests = []struct {
+	name               string
+	userID             int
+	accessLevel        int
+	expectedStatusCode int
+}{
+	{"owner", 1, 1, http.StatusOK},
+	{"other user", 2, 1, http.StatusNotFound},
+	{"operator", 2, 2, http.StatusNotFound},
+	{"admin", 2, 3, http.StatusOK},
+	{"signed out", 0, 0, http.StatusNotFound},
+}
+
+func TestTaskOwnership(t *testing.T) {
+	for _, e := range taskOwnershipTests {
+		req, _ := http.NewRequest("GET", "/sse?task_id=finished", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+		session.Put(ctx, "access_level", e.accessLevel)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.SSEHandler)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+// data for the SSE stream tests; the test repo records two progress events
+// for the "finished" task and none for the "expired" one
+var sseTests = []struct {
+	name        string
+	url         string
+	lastEventID string
+	contains    []string
+	excludes    []string
+}{
+	{
+		name:     "replay from the start",
+		url:      "/sse?task_id=finished",
+		contains: []string{"retry: 2000\n\n", "id: 1\ndata: {\"status\":\"Starting\"", "id: 2\ndata: "},
+	},
+	{
+		name:        "resume after the last event",
+		url:         "/sse?task_id=finished",
+		lastEventID: "1",
+		contains:    []string{"id: 2\ndata: "},
+		excludes:    []string{"id: 1\n"},
+	},
+	{
+		name:     "resume from the query string",
+		url:      "/sse?task_id=finished&last_event_id=2",
+		excludes: []string{"id: 1\n", "id: 2\n"},
+	},
+	{
+		name:     "events cleaned up",
+		url:      "/sse?task_id=expired",
+		contains: []string{`"summary":{"printed":1`},
+		excludes: []string{"id: "},
+	},
+}
+
+func TestSSEStream(t *testing.T) {
+	for _, e := range sseTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		if e.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", e.lastEventID)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.SSEHandler)
+		handler.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		for _, want := range e.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: expected %q in %q", e.name, want, body)
+			}
+		}
+		for _, unwanted := range e.excludes {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: did not expect %q in %q", e.name, unwanted, body)
+			}
+		}
+		if !strings.HasSuffix(body, "event: done\ndata: Task completed\n\n") {
+			t.Errorf("%s: expected the stream to end with a done event, got %q", e.name, body)
+		}
+	}
+}
+
 // // data for the Reservation handler, /make-reservation route
 // var reservationTests = []struct {
 // 	name               string
@@ -955,10 +1069,473 @@ func TestHandlers(t *testing.T) {
 // }
 
 // gets the context
-// func getCtx(req *http.Request) context.Context {
-// 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
-// 	if err != nil {
-// 		log.Println(err)
-// 	}
-// 	return ctx
-// }
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+// data for the signed link tests; links are checked without a session
+var sharedDownloadTests = []struct {
+	name               string
+	query              func() url.Values
+	expectedStatusCode int
+}{
+	{"valid", func() url.Values {
+		return app.Links.Query("finished", "finished.pdf", time.Now().Add(time.Hour))
+	}, http.StatusOK},
+	{"expired", func() url.Values {
+		return app.Links.Query("finished", "finished.pdf", time.Now().Add(-time.Minute))
+	}, http.StatusGone},
+	{"other file", func() url.Values {
+		q := app.Links.Query("finished", "finished.pdf", time.Now().Add(time.Hour))
+		q.Set("name", "finished.xlsx")
+		return q
+	}, http.StatusNotFound},
+	{"unsigned", func() url.Values {
+		return url.Values{"task_id": {"finished"}, "name": {"finished.pdf"}}
+	}, http.StatusNotFound},
+	{"missing file", func() url.Values {
+		return app.Links.Query("finished", "gone.zip", time.Now().Add(time.Hour))
+	}, http.StatusNotFound},
+}
+
+func TestSharedDownload(t *testing.T) {
+	for _, e := range sharedDownloadTests {
+		req, _ := http.NewRequest("GET", "/shared/download?"+e.query().Encode(), nil)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.SharedDownloadHandler)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusOK && rr.Header().Get("Content-Type") != "application/pdf" {
+			t.Errorf("%s: expected a PDF, got %q", e.name, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// data for the download header tests; the test files contain "test"
+var downloadHeaderTests = []struct {
+	name               string
+	headers            map[string]string
+	expectedStatusCode int
+	expectedBody       string
+}{
+	{"whole file", nil, http.StatusOK, "test"},
+	{"range", map[string]string{"Range": "bytes=2-"}, http.StatusPartialContent, "st"},
+	{"unchanged", map[string]string{"If-None-Match": `"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`}, http.StatusNotModified, ""},
+	{"changed", map[string]string{"If-None-Match": `"stale"`}, http.StatusOK, "test"},
+	{"resume unchanged", map[string]string{"Range": "bytes=1-2", "If-Range": `"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`}, http.StatusPartialContent, "es"},
+	{"resume changed", map[string]string{"Range": "bytes=1-2", "If-Range": `"stale"`}, http.StatusOK, "test"},
+}
+
+func TestDownloadHeaders(t *testing.T) {
+	query := app.Links.Query("finished", "finished.pdf", time.Now().Add(time.Hour)).Encode()
+	for _, e := range downloadHeaderTests {
+		req, _ := http.NewRequest("GET", "/shared/download?"+query, nil)
+		for key, value := range e.headers {
+			req.Header.Set(key, value)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.SharedDownloadHandler)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Body.String() != e.expectedBody {
+			t.Errorf("%s: expected body %q but got %q", e.name, e.expectedBody, rr.Body.String())
+		}
+		if rr.Code == http.StatusOK {
+			// Named after the uploaded spring.xlsx rather than the task id
+			if got := rr.Header().Get("Content-Disposition"); got != "attachment; filename=spring.pdf" {
+				t.Errorf("%s: unexpected Content-Disposition %q", e.name, got)
+			}
+			if got := rr.Header().Get("Content-Length"); got != "4" {
+				t.Errorf("%s: expected Content-Length 4, got %q", e.name, got)
+			}
+			if rr.Header().Get("Accept-Ranges") != "bytes" {
+				t.Errorf("%s: expected range support to be advertised", e.name)
+			}
+		}
+	}
+}
+
+// data for the hold tests; the test repo knows the "finished" task
+var taskHoldTests = []struct {
+	name          string
+	postedData    url.Values
+	expectedFlash string
+	expectedError string
+}{
+	{"hold", url.Values{"task_id": {"finished"}, "hold": {"true"}}, "Task finished is on hold", ""},
+	{"release", url.Values{"task_id": {"finished"}, "hold": {"false"}}, "Task finished is released", ""},
+	{"unknown task", url.Values{"task_id": {"missing"}, "hold": {"true"}}, "", "No task with id missing"},
+	{"missing task id", url.Values{"hold": {"true"}}, "", "A task id is required"},
+}
+
+func TestAdminTaskHold(t *testing.T) {
+	for _, e := range taskHoldTests {
+		req, _ := http.NewRequest("POST", "/admin/retention/hold", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminTaskHold)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if got := session.GetString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, got)
+		}
+		if got := session.GetString(ctx, "error"); got != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, got)
+		}
+	}
+}
+
+// data for the PostLogin tests; the test repo knows me@here.ca, and
+// gone@here.ca is deactivated
+var postLoginTests = []struct {
+	name          string
+	email         string
+	expectedUser  int
+	expectedError string
+}{
+	{"valid", "me@here.ca", 1, ""},
+	{"deactivated", "gone@here.ca", 0, "This account is deactivated; ask an admin to reactivate it"},
+	{"unknown", "who@here.ca", 0, "Invalid login credentials"},
+}
+
+func TestPostLogin(t *testing.T) {
+	for _, e := range postLoginTests {
+		postedData := url.Values{"email": {e.email}, "password": {"password"}}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if got := session.GetInt(ctx, "user_id"); got != e.expectedUser {
+			t.Errorf("%s: expected user %d signed in but got %d", e.name, e.expectedUser, got)
+		}
+		if got := session.GetString(ctx, "error"); got != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, got)
+		}
+	}
+}
+
+// data for the PostAdminAddUser tests; jane@here.ca is already a user
+var addUserTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      string
+}{
+	{"with password", url.Values{"first_name": {"Sam"}, "last_name": {"Lee"}, "email": {"sam@here.ca"}, "access_level": {"1"}, "password": {"long enough"}}, http.StatusSeeOther, "Added Sam Lee"},
+	{"invite", url.Values{"first_name": {"Sam"}, "last_name": {"Lee"}, "email": {"sam@here.ca"}, "access_level": {"2"}, "invite": {"true"}}, http.StatusSeeOther, "Invited sam@here.ca"},
+	{"short password", url.Values{"first_name": {"Sam"}, "last_name": {"Lee"}, "email": {"sam@here.ca"}, "access_level": {"1"}, "password": {"short"}}, http.StatusOK, ""},
+	{"email taken", url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"Jane@here.ca"}, "access_level": {"1"}, "invite": {"true"}}, http.StatusOK, ""},
+	{"no access level", url.Values{"first_name": {"Sam"}, "last_name": {"Lee"}, "email": {"sam@here.ca"}, "invite": {"true"}}, http.StatusOK, ""},
+	{"missing name", url.Values{"email": {"sam@here.ca"}, "access_level": {"1"}, "invite": {"true"}}, http.StatusOK, ""},
+}
+
+func TestPostAdminAddUser(t *testing.T) {
+	for _, e := range addUserTests {
+		req, _ := http.NewRequest("POST", "/admin/users/add", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostAdminAddUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if got := session.GetString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, got)
+		}
+	}
+}
+
+// data for the AdminEditUser tests; the signed in admin is user 1, me@here.ca,
+// and user 2 is jane@here.ca, a viewer
+var editUserTests = []struct {
+	name               string
+	user               models.User
+	expectedStatusCode int
+	expectedMessage    string
+}{
+	{"valid", models.User{ID: 2, FirstName: "Jane", LastName: "Smith", Email: "jane@here.ca", AccessLevel: 2}, http.StatusOK, "User updated successfully"},
+	{"own email in other case", models.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "Jane@Here.ca", AccessLevel: 1}, http.StatusOK, "User updated successfully"},
+	{"email taken", models.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "ME@here.ca", AccessLevel: 1}, http.StatusConflict, "A user with this email already exists"},
+	{"demote self", models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca", AccessLevel: 1}, http.StatusBadRequest, "You cannot change your own role"},
+	{"rename self", models.User{ID: 1, FirstName: "Ada", LastName: "User", Email: "me@here.ca", AccessLevel: 3}, http.StatusOK, "User updated successfully"},
+	{"unknown user", models.User{ID: 9, FirstName: "Sam", LastName: "Lee", Email: "sam@here.ca", AccessLevel: 1}, http.StatusNotFound, "No such user"},
+	{"unknown role", models.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@here.ca", AccessLevel: 7}, http.StatusBadRequest, "Unknown role"},
+}
+
+func TestAdminEditUser(t *testing.T) {
+	for _, e := range editUserTests {
+		body, _ := json.Marshal(map[string]any{
+			"id": e.user.ID, "first_name": e.user.FirstName, "last_name": e.user.LastName,
+			"email": e.user.Email, "access_level": e.user.AccessLevel,
+		})
+		req, _ := http.NewRequest("POST", "/admin/users/edit", bytes.NewReader(body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminEditUser)
+		handler.ServeHTTP(rr, req)
+
+		var reply map[string]string
+		_ = json.Unmarshal(rr.Body.Bytes(), &reply)
+		if rr.Code != e.expectedStatusCode || reply["message"] != e.expectedMessage {
+			t.Errorf("%s: expected %d %q but got %d %q", e.name, e.expectedStatusCode, e.expectedMessage, rr.Code, reply["message"])
+		}
+	}
+}
+
+// data for the user management tests; the signed in admin is user 1 and the
+// test repo also knows user 2
+var adminUserTests = []struct {
+	name          string
+	url           string
+	handler       func(*Repository, http.ResponseWriter, *http.Request)
+	postedData    url.Values
+	expectedFlash string
+	expectedError string
+}{
+	{"deactivate", "/admin/users/active", (*Repository).AdminSetUserActive, url.Values{"user_id": {"2"}, "active": {"false"}}, "jane@here.ca is deactivated", ""},
+	{"reactivate", "/admin/users/active", (*Repository).AdminSetUserActive, url.Values{"user_id": {"2"}, "active": {"true"}}, "jane@here.ca is reactivated", ""},
+	{"deactivate self", "/admin/users/active", (*Repository).AdminSetUserActive, url.Values{"user_id": {"1"}, "active": {"false"}}, "", "You cannot deactivate yourself"},
+	{"deactivate unknown", "/admin/users/active", (*Repository).AdminSetUserActive, url.Values{"user_id": {"9"}, "active": {"false"}}, "", "No user with id 9"},
+	{"delete", "/admin/users/delete", (*Repository).AdminDeleteUser, url.Values{"user_id": {"2"}}, "Deleted jane@here.ca", ""},
+	{"delete self", "/admin/users/delete", (*Repository).AdminDeleteUser, url.Values{"user_id": {"1"}}, "", "You cannot delete yourself"},
+	{"delete without id", "/admin/users/delete", (*Repository).AdminDeleteUser, url.Values{}, "", "Choose a user to delete"},
+	{"reset password", "/admin/users/reset-password", (*Repository).AdminResetPassword, url.Values{"user_id": {"2"}}, "A link to choose a new password was emailed to jane@here.ca", ""},
+	{"reset unknown", "/admin/users/reset-password", (*Repository).AdminResetPassword, url.Values{"user_id": {"9"}}, "", "No user with id 9"},
+}
+
+func TestAdminUserActions(t *testing.T) {
+	for _, e := range adminUserTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if got := session.GetString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, got)
+		}
+		if got := session.GetString(ctx, "error"); got != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, got)
+		}
+	}
+}
+
+// data for the sign out tests; each action signs user 2 out everywhere
+var signOutTests = []struct {
+	name       string
+	url        string
+	handler    func(*Repository, http.ResponseWriter, *http.Request)
+	postedData url.Values
+}{
+	{"deactivate", "/admin/users/active", (*Repository).AdminSetUserActive, url.Values{"user_id": {"2"}, "active": {"false"}}},
+	{"delete", "/admin/users/delete", (*Repository).AdminDeleteUser, url.Values{"user_id": {"2"}}},
+	{"reset password", "/admin/users/reset-password", (*Repository).AdminResetPassword, url.Values{"user_id": {"2"}}},
+}
+
+func TestAdminUserActionsSignOut(t *testing.T) {
+	for _, e := range signOutTests {
+		// Sign user 2 in elsewhere
+		other, _ := http.NewRequest("GET", "/", nil)
+		otherCtx := getCtx(other)
+		session.Put(otherCtx, "user_id", 2)
+		token, _, err := session.Commit(otherCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if _, found, _ := session.Store.Find(token); found {
+			t.Errorf("%s: expected the session of user 2 to be destroyed", e.name)
+		}
+	}
+}
+
+// data for the navigation tests; links are shown only to roles that may
+// follow them
+var navigationTests = []struct {
+	name        string
+	accessLevel int
+	shown       []string
+	hidden      []string
+}{
+	{"viewer", 1, []string{`href="/jobs"`}, []string{`href="/file-upload"`, `href="/admin"`, `href="/admin/users"`}},
+	{"operator", 2, []string{`href="/file-upload"`}, []string{`href="/admin"`, `href="/admin/users"`, `href="/admin/retention"`}},
+	{"admin", 3, []string{`href="/file-upload"`, `href="/admin"`, `href="/admin/users"`, `href="/admin/retention"`}, nil},
+}
+
+func TestNavigationByRole(t *testing.T) {
+	for _, e := range navigationTests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "access_level", e.accessLevel)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.Home)
+		handler.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		for _, link := range e.shown {
+			if !strings.Contains(body, link) {
+				t.Errorf("%s: expected the page to link %s", e.name, link)
+			}
+		}
+		for _, link := range e.hidden {
+			if strings.Contains(body, link) {
+				t.Errorf("%s: expected the page not to link %s", e.name, link)
+			}
+		}
+	}
+}
+
+// data for the PostForgotPassword tests; jane@here.ca has an account
+var forgotPasswordTests = []struct {
+	name               string
+	email              string
+	expectedStatusCode int
+	expectedFlash      string
+}{
+	{"known email", "Jane@here.ca", http.StatusSeeOther, "If an account uses that email, a link to reset its password is on its way"},
+	{"unknown email", "who@here.ca", http.StatusSeeOther, "If an account uses that email, a link to reset its password is on its way"},
+	{"invalid email", "jane", http.StatusOK, ""},
+}
+
+func TestPostForgotPassword(t *testing.T) {
+	for _, e := range forgotPasswordTests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if got := session.GetString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, got)
+		}
+	}
+}
+
+// data for the ResetPassword tests; the test repo accepts only valid-token
+var resetPasswordTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid token", "valid-token", http.StatusOK, ""},
+	{"unknown token", "made-up", http.StatusSeeOther, "/forgot-password"},
+	{"no token", "", http.StatusSeeOther, "/forgot-password"},
+}
+
+func TestResetPassword(t *testing.T) {
+	for _, e := range resetPasswordTests {
+		req, _ := http.NewRequest("GET", "/reset-password?"+url.Values{"token": {e.token}}.Encode(), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if got := rr.Header().Get("Location"); got != e.expectedLocation {
+			t.Errorf("%s: expected location %q but got %q", e.name, e.expectedLocation, got)
+		}
+		if e.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), `value="valid-token"`) {
+			t.Errorf("%s: expected the form to carry the token", e.name)
+		}
+	}
+}
+
+// data for the PostResetPassword tests
+var postResetPasswordTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      string
+	expectedError      string
+}{
+	{"valid", url.Values{"token": {"valid-token"}, "password": {"a new password"}, "confirm_password": {"a new password"}}, http.StatusSeeOther, "Your password has been reset. Log in with the new one.", ""},
+	{"mismatch", url.Values{"token": {"valid-token"}, "password": {"a new password"}, "confirm_password": {"another password"}}, http.StatusOK, "", ""},
+	{"too short", url.Values{"token": {"valid-token"}, "password": {"short"}, "confirm_password": {"short"}}, http.StatusOK, "", ""},
+	{"unknown token", url.Values{"token": {"made-up"}, "password": {"a new password"}, "confirm_password": {"a new password"}}, http.StatusSeeOther, "", "This reset link has expired or was already used. Ask for a new one."},
+}
+
+func TestPostResetPassword(t *testing.T) {
+	for _, e := range postResetPasswordTests {
+		req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if got := session.GetString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, got)
+		}
+		if got := session.GetString(ctx, "error"); got != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, got)
+		}
+	}
+}
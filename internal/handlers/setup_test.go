@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"html/template"
@@ -9,9 +10,15 @@ import (
 	"os"
 	"path/filepath"
 	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/links"
 	"pawprintpublic/internal/mailer"
 	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/progress"
 	"pawprintpublic/internal/render"
+	"pawprintpublic/internal/retention"
+	"pawprintpublic/internal/storage"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -83,13 +90,35 @@ func TestMain(m *testing.M) {
 
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
+	app.TaskManager = diplomapdfs.NewTaskManager(repo.DB, 1)
+	app.Progress = progress.NewLocalBroker()
+	app.Retention = retention.Default()
+	app.Links = links.NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	app.BaseURL = "http://localhost:8080"
+
+	// Store the contents of the test repo's files
+	storageDir, err := os.MkdirTemp("", "pawprint-files")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Storage, err = storage.NewFilesystem(storageDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, key := range []string{"tasks/finished/finished.xlsx", "tasks/finished/finished.pdf"} {
+		if err := app.Storage.Put(context.Background(), key, strings.NewReader("test"), 4); err != nil {
+			log.Fatal(err)
+		}
+	}
 	render.NewRenderer(&app)
 
 	go app.Mailer.ListenForMail()
 	go app.ListenForShutdown()
 	go app.ListenForErrors()
 
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(storageDir)
+	os.Exit(code)
 }
 
 //func listenForMail() {
@@ -107,7 +136,14 @@ func getRoutes() http.Handler {
 	//mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
-	// mux.Get("/", Repo.Home)
+	mux.Get("/", Repo.Home)
+	mux.Get("/sse", Repo.SSEHandler)
+	mux.Get("/jobs", Repo.JobsPage)
+	mux.Get("/admin", Repo.AdminDashboard)
+	mux.Get("/admin/retention", Repo.AdminRetention)
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Get("/admin/users/add", Repo.AdminAddUser)
+	mux.Get("/forgot-password", Repo.ForgotPassword)
 	// mux.Get("/about", Repo.About)
 	// mux.Get("/generals-quarters", Repo.Generals)
 	// mux.Get("/majors-suite", Repo.Majors)
@@ -138,6 +174,8 @@ func getRoutes() http.Handler {
 	// mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	// mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/preview", Repo.PreviewHandler)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/roles"
 	"runtime/debug"
 )
 
@@ -32,6 +33,12 @@ func IsAuthenticated(r *http.Request) bool {
 	return exists
 }
 
+// UserRole returns the role of the signed in user, which Auth keeps in step
+// with the database. It is not valid when nobody is signed in.
+func UserRole(r *http.Request) roles.Role {
+	return roles.Role(app.Session.GetInt(r.Context(), "access_level"))
+}
+
 func IsValidEmail(email string) bool {
 	// Implement email validation
 	return true
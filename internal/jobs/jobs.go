@@ -0,0 +1,170 @@
+// Package jobs runs diploma tasks, either in the web process or in worker
+// processes that take them from the jobs table
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/storage"
+	"strconv"
+	"time"
+)
+
+// Ways the web process can run tasks, set with TASK_EXECUTION
+const (
+	ExecutionLocal = "local" // run tasks in the web process
+	ExecutionQueue = "queue" // leave tasks in the jobs table for cmd/worker
+)
+
+// Store is the part of the repository that running tasks needs
+type Store interface {
+	diplomapdfs.TaskStore
+	GetFile(taskID, fileType string) (models.File, error)
+	InsertFile(f models.File) error
+	InsertJob(j models.Job) error
+	ClaimJob(workerID string, staleBefore time.Time) (models.Job, error)
+	HeartbeatJob(taskID, workerID string) (bool, error)
+	DeleteJob(taskID, workerID string) error
+}
+
+// ExecutionFromEnv reads TASK_EXECUTION, which defaults to local
+func ExecutionFromEnv() (string, error) {
+	switch value := os.Getenv("TASK_EXECUTION"); value {
+	case "", ExecutionLocal:
+		return ExecutionLocal, nil
+	case ExecutionQueue:
+		return ExecutionQueue, nil
+	default:
+		return "", fmt.Errorf("TASK_EXECUTION must be %q or %q, got %q", ExecutionLocal, ExecutionQueue, value)
+	}
+}
+
+// MaxConcurrentFromEnv reads MAX_CONCURRENT_TASKS, the number of tasks one
+// process runs at once, which defaults to 2
+func MaxConcurrentFromEnv() (int, error) {
+	value := os.Getenv("MAX_CONCURRENT_TASKS")
+	if value == "" {
+		return 2, nil
+	}
+	maxTasks, err := strconv.Atoi(value)
+	if err != nil || maxTasks < 1 {
+		return 0, fmt.Errorf("MAX_CONCURRENT_TASKS must be a positive number, got %q", value)
+	}
+	return maxTasks, nil
+}
+
+// Submit leaves a task in the jobs table for a worker. The task record and
+// its uploaded workbook must already be stored.
+func Submit(store Store, taskID, sessionID string, opts diplomapdfs.Options) error {
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	return store.InsertJob(models.Job{TaskID: taskID, SessionID: sessionID, Options: string(options)})
+}
+
+// decodeOptions reads the output options stored with a job
+func decodeOptions(job models.Job) (diplomapdfs.Options, error) {
+	var opts diplomapdfs.Options
+	err := json.Unmarshal([]byte(job.Options), &opts)
+	return opts, err
+}
+
+// ProcessedFileName is the name the processed workbook of a task is stored under
+func ProcessedFileName(taskID string) string {
+	return fmt.Sprintf("%s_processed.xlsx", taskID)
+}
+
+// Process runs the diploma pipeline for a task whose workbook is in storage,
+// storing the processed workbook and every output file
+func Process(tm *diplomapdfs.TaskManager, store Store, files storage.Storage, task *diplomapdfs.Task, sessionID string, opts diplomapdfs.Options) error {
+	ctx := context.Background()
+
+	// Copy the uploaded workbook to a temporary file
+	upload, err := store.GetFile(task.ID, "xlsx")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll("./tmp", 0755); err != nil {
+		return err
+	}
+	tmpXlsxFilePath := fmt.Sprintf("./tmp/%s.xlsx", task.ID)
+	err = copyObject(ctx, files, upload.ObjectKey, tmpXlsxFilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpXlsxFilePath)
+
+	// Proceed with processing
+	err = tm.ProcessData(task, tmpXlsxFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Keep the processed workbook, with its Output sheet, next to the upload
+	processed := models.File{
+		TaskID:    task.ID,
+		SessionID: sessionID,
+		UserID:    task.UserID,
+		FileName:  ProcessedFileName(task.ID),
+		FileType:  "processed",
+	}
+	err = storage.SaveFile(ctx, files, store, processed, tmpXlsxFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Generate PDFs
+	outputs, err := tm.GeneratePdfs(task, tmpXlsxFilePath, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, output := range outputs {
+		// Store the generated file without reading it into memory
+		err = storage.SaveFile(ctx, files, store, models.File{
+			TaskID:    task.ID,
+			SessionID: sessionID,
+			UserID:    task.UserID,
+			FileName:  filepath.Base(output.Path),
+			FileType:  output.Type,
+		}, output.Path)
+		if err != nil {
+			return err
+		}
+
+		// Optionally delete the file from disk
+		err = os.Remove(output.Path)
+		if err != nil {
+			log.Println("Error removing output file from disk:", err)
+		}
+	}
+
+	return nil
+}
+
+// copyObject writes the object stored under key to a file at path
+func copyObject(ctx context.Context, files storage.Storage, key, path string) error {
+	object, err := files.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, object)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/storage"
+	"sync"
+	"time"
+)
+
+// Worker takes jobs from the jobs table and runs them on a local
+// TaskManager, which limits how many run at once. Progress and results are
+// written to the task records, where the web process reads them.
+type Worker struct {
+	ID           string
+	Store        Store
+	Files        storage.Storage // where uploaded and generated files are kept
+	Tasks        *diplomapdfs.TaskManager
+	PollInterval time.Duration // how often to look for jobs and send heartbeats
+	StaleAfter   time.Duration // how long a claimed job may go without a heartbeat before another worker takes it
+	MaxAttempts  int           // claims of one job before its task is failed
+	InfoLog      *log.Logger
+	ErrorLog     *log.Logger
+
+	process func(task *diplomapdfs.Task, job models.Job) error
+	mu      sync.Mutex
+	claimed map[string]bool // tasks whose jobs this worker holds
+}
+
+// NewWorker creates a worker identified by id
+func NewWorker(id string, store Store, files storage.Storage, tasks *diplomapdfs.TaskManager, infoLog, errorLog *log.Logger) *Worker {
+	w := &Worker{
+		ID:           id,
+		Store:        store,
+		Files:        files,
+		Tasks:        tasks,
+		PollInterval: 2 * time.Second,
+		StaleAfter:   2 * time.Minute,
+		MaxAttempts:  3,
+		InfoLog:      infoLog,
+		ErrorLog:     errorLog,
+		claimed:      make(map[string]bool),
+	}
+	w.process = w.runJob
+	return w
+}
+
+// Run claims and runs jobs until ctx is cancelled, then stops claiming and
+// returns once the tasks it holds have finished
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	w.InfoLog.Printf("Worker %s waiting for jobs", w.ID)
+	done := ctx.Done()
+	for {
+		w.heartbeat()
+		if ctx.Err() == nil {
+			w.claimJobs()
+		} else if w.holding() == 0 {
+			w.InfoLog.Printf("Worker %s stopped", w.ID)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			// Stop claiming, but keep sending heartbeats until the running tasks finish
+			w.InfoLog.Printf("Worker %s finishing %d tasks", w.ID, w.holding())
+			done = nil
+		}
+	}
+}
+
+// holding returns how many jobs the worker holds
+func (w *Worker) holding() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.claimed)
+}
+
+// claimJobs claims jobs while the TaskManager has free slots
+func (w *Worker) claimJobs() {
+	for {
+		stats := w.Tasks.QueueStats()
+		if stats.Queued+stats.Running >= stats.MaxRunning {
+			return
+		}
+
+		job, err := w.Store.ClaimJob(w.ID, time.Now().Add(-w.StaleAfter))
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		} else if err != nil {
+			w.ErrorLog.Println("Error claiming job:", err)
+			return
+		}
+		w.start(job)
+	}
+}
+
+// start runs a claimed job on the TaskManager
+func (w *Worker) start(job models.Job) {
+	record, err := w.Store.GetTaskByID(job.TaskID)
+	if err != nil {
+		w.ErrorLog.Printf("Job %s: cannot load task: %v", job.TaskID, err)
+		w.deleteJob(job.TaskID)
+		return
+	}
+
+	if diplomapdfs.IsFinished(record.Status) {
+		// The previous worker stopped between finishing the task and removing its job
+		w.deleteJob(job.TaskID)
+		return
+	}
+
+	w.mu.Lock()
+	w.claimed[job.TaskID] = true
+	w.mu.Unlock()
+
+	task := w.Tasks.AdoptTask(record)
+	if job.CancelRequested {
+		w.Tasks.CancelTask(task.ID)
+	}
+
+	if job.Attempts > w.MaxAttempts {
+		w.ErrorLog.Printf("Job %s: giving up after %d attempts", job.TaskID, w.MaxAttempts)
+		w.Tasks.Enqueue(task, func() error {
+			return fmt.Errorf("the task was interrupted %d times and was not retried", w.MaxAttempts)
+		})
+		return
+	}
+
+	w.InfoLog.Printf("Job %s: claimed (attempt %d)", job.TaskID, job.Attempts)
+	w.Tasks.Enqueue(task, func() error {
+		return w.process(task, job)
+	})
+}
+
+// runJob runs the diploma pipeline for a job
+func (w *Worker) runJob(task *diplomapdfs.Task, job models.Job) error {
+	opts, err := decodeOptions(job)
+	if err != nil {
+		return fmt.Errorf("invalid job options: %w", err)
+	}
+	return Process(w.Tasks, w.Store, w.Files, task, job.SessionID, opts)
+}
+
+// heartbeat keeps the jobs of running tasks claimed, stops tasks whose jobs
+// were cancelled and removes the jobs of finished tasks. A task is finished
+// once the TaskManager has stored its result and stopped tracking it.
+func (w *Worker) heartbeat() {
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.claimed))
+	for id := range w.claimed {
+		ids = append(ids, id)
+	}
+	w.mu.Unlock()
+
+	for _, id := range ids {
+		if _, err := w.Tasks.GetTask(id); err != nil {
+			w.deleteJob(id)
+			continue
+		}
+
+		cancelRequested, err := w.Store.HeartbeatJob(id, w.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Another worker took the job over after a missed heartbeat
+			w.ErrorLog.Printf("Job %s: no longer held by this worker", id)
+			w.mu.Lock()
+			delete(w.claimed, id)
+			w.mu.Unlock()
+			continue
+		} else if err != nil {
+			w.ErrorLog.Printf("Job %s: heartbeat failed: %v", id, err)
+			continue
+		}
+
+		if cancelRequested {
+			w.Tasks.CancelTask(id)
+		}
+	}
+}
+
+// deleteJob removes a job the worker no longer needs
+func (w *Worker) deleteJob(taskID string) {
+	if err := w.Store.DeleteJob(taskID, w.ID); err != nil {
+		w.ErrorLog.Printf("Job %s: cannot remove job: %v", taskID, err)
+		return
+	}
+	w.mu.Lock()
+	delete(w.claimed, taskID)
+	w.mu.Unlock()
+}
@@ -0,0 +1,277 @@
+package jobs
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/models"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store that keeps tasks and jobs in maps
+type memoryStore struct {
+	mu    sync.Mutex
+	tasks map[string]models.Task
+	jobs  map[string]models.Job
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{tasks: make(map[string]models.Task), jobs: make(map[string]models.Job)}
+}
+
+func (s *memoryStore) InsertTask(t models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[t.ID] = t
+	return nil
+}
+
+func (s *memoryStore) StartTask(id string, startedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tasks[id]
+	t.Status, t.StartedAt = diplomapdfs.StatusRunning, startedAt
+	s.tasks[id] = t
+	return nil
+}
+
+func (s *memoryStore) AddTaskProgress(id string, progress int, statusText string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tasks[id]
+	t.Progress, t.StatusText = progress, statusText
+	s.tasks[id] = t
+	return nil
+}
+
+func (s *memoryStore) FinishTask(t models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[t.ID] = t
+	return nil
+}
+
+func (s *memoryStore) GetTaskByID(id string) (models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return t, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (s *memoryStore) HeartbeatTask(id string, now time.Time) error {
+	return nil
+}
+
+func (s *memoryStore) InterruptRunningTasks(staleBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *memoryStore) GetFile(taskID, fileType string) (models.File, error) {
+	return models.File{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) InsertFile(f models.File) error {
+	return nil
+}
+
+func (s *memoryStore) InsertJob(j models.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.Status = "pending"
+	j.CreatedAt = time.Now()
+	s.jobs[j.TaskID] = j
+	return nil
+}
+
+func (s *memoryStore) ClaimJob(workerID string, staleBefore time.Time) (models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id, j := range s.jobs {
+		if j.Status == "pending" || j.HeartbeatAt.Before(staleBefore) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return models.Job{}, sql.ErrNoRows
+	}
+	sort.Slice(ids, func(a, b int) bool { return s.jobs[ids[a]].CreatedAt.Before(s.jobs[ids[b]].CreatedAt) })
+
+	j := s.jobs[ids[0]]
+	j.Status, j.ClaimedBy, j.HeartbeatAt = "claimed", workerID, time.Now()
+	j.Attempts++
+	s.jobs[j.TaskID] = j
+	return j, nil
+}
+
+func (s *memoryStore) HeartbeatJob(taskID, workerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[taskID]
+	if !ok || j.ClaimedBy != workerID {
+		return false, sql.ErrNoRows
+	}
+	j.HeartbeatAt = time.Now()
+	s.jobs[taskID] = j
+	return j.CancelRequested, nil
+}
+
+func (s *memoryStore) DeleteJob(taskID, workerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[taskID]; ok && j.ClaimedBy == workerID {
+		delete(s.jobs, taskID)
+	}
+	return nil
+}
+
+// job returns the stored job of a task, if there is one
+func (s *memoryStore) job(taskID string) (models.Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[taskID]
+	return j, ok
+}
+
+// submit stores a queued task and its job, as the web process does
+func (s *memoryStore) submit(t *testing.T, taskID string, opts diplomapdfs.Options) {
+	t.Helper()
+	s.InsertTask(models.Task{ID: taskID, UserID: 1, Status: diplomapdfs.StatusQueued})
+	if err := Submit(s, taskID, "session", opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond) // keep created_at in submission order
+}
+
+func newTestWorker(store *memoryStore, maxRunning int, process func(*diplomapdfs.Task, models.Job) error) *Worker {
+	discard := log.New(io.Discard, "", 0)
+	w := NewWorker("worker-1", store, nil, diplomapdfs.NewTaskManager(store, maxRunning), discard, discard)
+	w.process = process
+	return w
+}
+
+// waitFinished waits for the worker to finish a task and remove its job
+func waitFinished(t *testing.T, w *Worker, store *memoryStore, taskID string) models.Task {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		w.heartbeat()
+		if _, ok := store.job(taskID); !ok {
+			record, _ := store.GetTaskByID(taskID)
+			return record
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not finish", taskID)
+	return models.Task{}
+}
+
+func TestWorkerRunsJob(t *testing.T) {
+	store := newMemoryStore()
+	store.submit(t, "spring", diplomapdfs.Options{BatchSize: 50, SortBy: "name", Separators: true})
+
+	var got diplomapdfs.Options
+	w := newTestWorker(store, 1, func(task *diplomapdfs.Task, job models.Job) error {
+		var err error
+		got, err = decodeOptions(job)
+		return err
+	})
+	w.claimJobs()
+
+	record := waitFinished(t, w, store, "spring")
+	if record.Status != diplomapdfs.StatusCompleted {
+		t.Errorf("expected status %q, got %q (%s)", diplomapdfs.StatusCompleted, record.Status, record.Error)
+	}
+	if got.BatchSize != 50 || got.SortBy != "name" || !got.Separators {
+		t.Errorf("options did not survive the queue: %+v", got)
+	}
+	if w.holding() != 0 {
+		t.Errorf("expected the worker to hold no jobs, holds %d", w.holding())
+	}
+}
+
+func TestWorkerClaimsOnlyFreeSlots(t *testing.T) {
+	store := newMemoryStore()
+	store.submit(t, "first", diplomapdfs.Options{})
+	store.submit(t, "second", diplomapdfs.Options{})
+
+	release := make(chan struct{})
+	w := newTestWorker(store, 1, func(task *diplomapdfs.Task, job models.Job) error {
+		<-release
+		return nil
+	})
+	w.claimJobs()
+
+	if job, _ := store.job("first"); job.Status != "claimed" {
+		t.Errorf("expected the first job to be claimed, got %q", job.Status)
+	}
+	if job, _ := store.job("second"); job.Status != "pending" {
+		t.Errorf("expected the second job to wait for a free slot, got %q", job.Status)
+	}
+
+	close(release)
+	waitFinished(t, w, store, "first")
+	w.claimJobs()
+	waitFinished(t, w, store, "second")
+}
+
+func TestWorkerCancelsJob(t *testing.T) {
+	store := newMemoryStore()
+	store.submit(t, "spring", diplomapdfs.Options{})
+
+	started := make(chan struct{})
+	w := newTestWorker(store, 1, func(task *diplomapdfs.Task, job models.Job) error {
+		close(started)
+		<-task.Ctx.Done()
+		return diplomapdfs.ErrCancelled
+	})
+	w.claimJobs()
+	<-started
+
+	// The web process flags the claimed job; the next heartbeat stops the task
+	store.mu.Lock()
+	job := store.jobs["spring"]
+	job.CancelRequested = true
+	store.jobs["spring"] = job
+	store.mu.Unlock()
+
+	record := waitFinished(t, w, store, "spring")
+	if record.Status != diplomapdfs.StatusCancelled {
+		t.Errorf("expected status %q, got %q", diplomapdfs.StatusCancelled, record.Status)
+	}
+}
+
+func TestWorkerGivesUpAfterMaxAttempts(t *testing.T) {
+	store := newMemoryStore()
+	store.submit(t, "spring", diplomapdfs.Options{})
+
+	// Earlier workers claimed the job and stopped sending heartbeats
+	store.mu.Lock()
+	job := store.jobs["spring"]
+	job.Status, job.ClaimedBy, job.Attempts = "claimed", "worker-0", 3
+	store.jobs["spring"] = job
+	store.mu.Unlock()
+
+	ran := false
+	w := newTestWorker(store, 1, func(task *diplomapdfs.Task, job models.Job) error {
+		ran = true
+		return nil
+	})
+	w.claimJobs()
+
+	record := waitFinished(t, w, store, "spring")
+	if ran {
+		t.Error("expected the job not to run again")
+	}
+	if record.Status != diplomapdfs.StatusFailed {
+		t.Errorf("expected status %q, got %q", diplomapdfs.StatusFailed, record.Status)
+	}
+}
@@ -0,0 +1,112 @@
+// Package links signs download links that work without signing in until
+// they expire
+package links
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrExpired is returned for a link past its expiry time
+var ErrExpired = errors.New("link has expired")
+
+// ErrInvalid is returned for a link whose signature does not match
+var ErrInvalid = errors.New("link is not valid")
+
+// Signer makes and checks signed links to the files of a task
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a signer using key
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// KeyFromEnv reads the signing key from LINK_SIGNING_KEY, or from the file
+// named by LINK_SIGNING_KEY_FILE. When neither is set a random key is made
+// and generated is true; links then stop working when the process restarts
+// and are not accepted by other replicas.
+func KeyFromEnv() (key []byte, generated bool, err error) {
+	value := os.Getenv("LINK_SIGNING_KEY")
+	if value == "" {
+		keyFile := os.Getenv("LINK_SIGNING_KEY_FILE")
+		if keyFile != "" {
+			content, err := os.ReadFile(keyFile)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to read link signing key file: %v", err)
+			}
+			value = strings.TrimSpace(string(content))
+		}
+	}
+	if value != "" {
+		if len(value) < 32 {
+			return nil, false, errors.New("the link signing key must be at least 32 characters")
+		}
+		return []byte(value), false, nil
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// TTLFromEnv reads DOWNLOAD_LINK_TTL, how long emailed links stay valid,
+// which defaults to 24 hours
+func TTLFromEnv() (time.Duration, error) {
+	value := os.Getenv("DOWNLOAD_LINK_TTL")
+	if value == "" {
+		return 24 * time.Hour, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("DOWNLOAD_LINK_TTL must be a positive duration such as 24h, got %q", value)
+	}
+	return ttl, nil
+}
+
+// sign returns the signature of a file of a task valid until expires
+func (s *Signer) sign(taskID, fileName string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%d", taskID, fileName, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Query returns the query string of a link to a file of a task that
+// expires at the given time
+func (s *Signer) Query(taskID, fileName string, expires time.Time) url.Values {
+	return url.Values{
+		"task_id": {taskID},
+		"name":    {fileName},
+		"expires": {strconv.FormatInt(expires.Unix(), 10)},
+		"sig":     {s.sign(taskID, fileName, expires.Unix())},
+	}
+}
+
+// Verify checks the query string of a link at time now
+func (s *Signer) Verify(query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	want := s.sign(query.Get("task_id"), query.Get("name"), expires)
+	if !hmac.Equal([]byte(want), []byte(query.Get("sig"))) {
+		return ErrInvalid
+	}
+	if now.Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
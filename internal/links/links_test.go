@@ -0,0 +1,34 @@
+package links
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	now := time.Now()
+	query := signer.Query("task-1", "task-1.pdf", now.Add(time.Hour))
+
+	if err := signer.Verify(query, now); err != nil {
+		t.Errorf("expected a fresh link to be valid, got %v", err)
+	}
+	if err := signer.Verify(query, now.Add(2*time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+
+	// Changing any part of the link breaks the signature
+	for _, field := range []string{"task_id", "name", "expires"} {
+		tampered := signer.Query("task-1", "task-1.pdf", now.Add(time.Hour))
+		tampered.Set(field, tampered.Get(field)+"0")
+		if err := signer.Verify(tampered, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid after changing %s, got %v", field, err)
+		}
+	}
+
+	other := NewSigner([]byte("fedcba9876543210fedcba9876543210"))
+	if err := other.Verify(query, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a link signed with another key to be invalid, got %v", err)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	mail "github.com/xhit/go-simple-mail/v2"
 	"html/template"
 	"log"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	textTemplate "text/template"
 	"time"
 	"unicode/utf8"
 )
@@ -57,6 +60,43 @@ type MailConfig struct {
 	ErrorLog    *log.Logger
 }
 
+// ConfigFromEnv reads the SMTP settings from the MAIL_* environment
+// variables. The defaults send through a local mailhog on port 1025.
+func ConfigFromEnv() (MailConfig, error) {
+	port, err := strconv.Atoi(envOr("MAIL_PORT", "1025"))
+	if err != nil {
+		return MailConfig{}, fmt.Errorf("MAIL_PORT must be a number: %v", err)
+	}
+
+	password := os.Getenv("MAIL_PASSWORD")
+	if passwordFile := os.Getenv("MAIL_PASSWORD_FILE"); passwordFile != "" {
+		content, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return MailConfig{}, fmt.Errorf("failed to read mail password file: %v", err)
+		}
+		password = strings.TrimSpace(string(content))
+	}
+
+	return MailConfig{
+		Domain:      envOr("MAIL_DOMAIN", "localhost"),
+		Host:        envOr("MAIL_HOST", "localhost"),
+		Port:        port,
+		Username:    os.Getenv("MAIL_USERNAME"),
+		Password:    password,
+		Encryption:  envOr("MAIL_ENCRYPTION", "none"),
+		FromAddress: envOr("MAIL_FROM_ADDRESS", "info@mycompany.com"),
+		FromName:    envOr("MAIL_FROM_NAME", "Info"),
+	}, nil
+}
+
+// envOr returns the value of an environment variable, or fallback when unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
 // CreateMail initializes and returns a Mail instance based on MailerConfig
 func CreateMail(cfg MailConfig) Mail {
 	errorChan := make(chan error)
@@ -67,6 +107,8 @@ func CreateMail(cfg MailConfig) Mail {
 		Domain:      cfg.Domain,
 		Host:        cfg.Host,
 		Port:        cfg.Port,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
 		Encryption:  cfg.Encryption,
 		FromName:    cfg.FromName,
 		FromAddress: cfg.FromAddress,
@@ -151,6 +193,7 @@ func (m *Mail) sendMail(msg Message, errorChan chan error) {
 	smtpClient, err := server.Connect()
 	if err != nil {
 		errorChan <- err
+		return
 	}
 
 	email := mail.NewMSG()
@@ -208,7 +251,8 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
 	templateToRender := fmt.Sprintf("./email-templates/%s.plain.tmpl", msg.Template)
 
-	t, err := template.New("email-plain").ParseFiles(templateToRender)
+	// Plain text is not HTML escaped, so links keep their query strings
+	t, err := textTemplate.New("email-plain").ParseFiles(templateToRender)
 	if err != nil {
 		return "", err
 	}
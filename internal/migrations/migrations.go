@@ -0,0 +1,379 @@
+// Package migrations keeps the database schema up to date with ordered SQL
+// files embedded in the binary. The applied versions are recorded in the
+// schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/storage"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// ErrNewerSchema is returned when the database has migrations applied that
+// this binary does not know, so it was last migrated by a newer release
+var ErrNewerSchema = errors.New("the database schema is newer than this binary")
+
+// ErrPending is returned by Check when migrations have not been applied yet
+var ErrPending = errors.New("the database schema has migrations waiting to be applied")
+
+// Migration is one numbered schema change with the SQL to apply and undo it
+type Migration struct {
+	Version    int
+	Name       string
+	Up         string
+	Down       string
+	AfterUp    Step // runs after Up, in the same transaction
+	BeforeDown Step // runs before Down, in the same transaction
+}
+
+// Step is Go code a migration runs for changes SQL cannot make, such as
+// moving file contents into file storage
+type Step func(ctx context.Context, tx *sql.Tx, files storage.Storage) error
+
+// Dialect holds what differs between the databases migrations run on
+type Dialect struct {
+	Name        string
+	Placeholder func(n int) string // the nth query parameter, from 1
+	HasTable    string             // counts the tables named by its one parameter
+	Lock        string             // taken on the connection while migrating; empty for none
+	Unlock      string
+	Adopt       bool // record tables made by create_tables.sql as the first migration
+}
+
+// Postgres is the dialect of the Postgres database. An advisory lock keeps
+// replicas that start together from migrating at the same time.
+var Postgres = Dialect{
+	Name:        "postgres",
+	Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	HasTable:    `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`,
+	Lock:        `SELECT pg_advisory_lock(7201)`,
+	Unlock:      `SELECT pg_advisory_unlock(7201)`,
+	Adopt:       true,
+}
+
+// SQLite is the dialect of a SQLite database file. SQLite lets one writer in
+// at a time, so migrators need no lock of their own.
+var SQLite = Dialect{
+	Name:        "sqlite",
+	Placeholder: func(n int) string { return "?" },
+	HasTable:    `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+}
+
+// Migrator applies and undoes migrations on a database
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    Dialect
+	Migrations []Migration     // in version order
+	Storage    storage.Storage // for steps that move file contents; may be nil when there are none to move
+	InfoLog    *log.Logger
+}
+
+// New returns a migrator for the migrations embedded for the database db
+// was connected to
+func New(db *driver.DB, infoLog *log.Logger) (*Migrator, error) {
+	dialect := Postgres
+	if db.Driver == driver.SQLite {
+		dialect = SQLite
+	}
+	return NewDialect(db.SQL, dialect, infoLog)
+}
+
+// NewDialect returns a migrator for the migrations embedded for a dialect,
+// with their Go steps
+func NewDialect(db *sql.DB, dialect Dialect, infoLog *log.Logger) (*Migrator, error) {
+	dir, err := fs.Sub(files, dialect.Name)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range migrations {
+		if step, ok := steps[dialect.Name][m.Name]; ok {
+			migrations[i].AfterUp, migrations[i].BeforeDown = step.AfterUp, step.BeforeDown
+		}
+	}
+	return &Migrator{DB: db, Dialect: dialect, Migrations: migrations, InfoLog: infoLog}, nil
+}
+
+// fileName matches migration files such as 0002_task_hold.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the top directory of fsys. Every version from
+// 1 up needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s must be named like 0001_name.up.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest is the version the migrations bring the schema to
+func (m *Migrator) Latest() int {
+	return len(m.Migrations)
+}
+
+// Version returns the version of the schema, 0 for an empty database
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	exists, err := m.hasTable(ctx, conn, "schema_migrations")
+	if err != nil || !exists {
+		return 0, err
+	}
+	return m.version(ctx, conn)
+}
+
+// Check returns ErrNewerSchema or ErrPending unless the schema is at the
+// latest version
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: it is at version %d, this binary knows up to %d", ErrNewerSchema, version, m.Latest())
+	}
+	if version < m.Latest() {
+		return fmt.Errorf("%w: it is at version %d of %d", ErrPending, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies every migration the database has not had yet, each in its own
+// transaction, and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sql.Conn, version int) error {
+		for _, migration := range m.Migrations[version:] {
+			m.InfoLog.Printf("Applying migration %d %s", migration.Version, migration.Name)
+			err := m.apply(ctx, conn, nil, migration.Up, func(tx *sql.Tx) error {
+				if err := m.step(ctx, tx, migration.AfterUp); err != nil {
+					return err
+				}
+				query := fmt.Sprintf(`INSERT INTO schema_migrations (version, name) VALUES (%s, %s)`,
+					m.Dialect.Placeholder(1), m.Dialect.Placeholder(2))
+				_, err := tx.ExecContext(ctx, query, migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down undoes the latest steps migrations, newest first, and returns how
+// many were undone. It is meant for local development.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	undone := 0
+	err := m.locked(ctx, func(conn *sql.Conn, version int) error {
+		for ; undone < steps && version > 0; version-- {
+			migration := m.Migrations[version-1]
+			m.InfoLog.Printf("Undoing migration %d %s", migration.Version, migration.Name)
+			before := func(tx *sql.Tx) error { return m.step(ctx, tx, migration.BeforeDown) }
+			err := m.apply(ctx, conn, before, migration.Down, func(tx *sql.Tx) error {
+				query := fmt.Sprintf(`DELETE FROM schema_migrations WHERE version = %s`, m.Dialect.Placeholder(1))
+				_, err := tx.ExecContext(ctx, query, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			undone++
+		}
+		return nil
+	})
+	return undone, err
+}
+
+// OnStartup brings the schema up to date unless AUTO_MIGRATE is false, in
+// which case it only checks that it is. A schema newer than the binary is
+// refused either way.
+func (m *Migrator) OnStartup(ctx context.Context) error {
+	if os.Getenv("AUTO_MIGRATE") == "false" {
+		return m.Check(ctx)
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		m.InfoLog.Printf("Applied %d migrations; the schema is at version %d", applied, m.Latest())
+	}
+	return nil
+}
+
+// locked runs fn on one connection holding the dialect's lock, with the
+// schema_migrations table in place and the current version, which must not
+// be newer than the binary
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, version int) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.Dialect.Lock != "" {
+		if _, err := conn.ExecContext(ctx, m.Dialect.Lock); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), m.Dialect.Unlock)
+	}
+
+	if err := m.prepare(ctx, conn); err != nil {
+		return err
+	}
+	version, err := m.version(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: it is at version %d, this binary knows up to %d", ErrNewerSchema, version, m.Latest())
+	}
+	return fn(conn, version)
+}
+
+// prepare creates the schema_migrations table. A database set up by
+// create_tables.sql before migrations existed already has the tables of the
+// first migration, which is then recorded as applied.
+func (m *Migrator) prepare(ctx context.Context, conn *sql.Conn) error {
+	exists, err := m.hasTable(ctx, conn, "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+	adopt := false
+	if m.Dialect.Adopt {
+		adopt, err = m.hasTable(ctx, conn, "users")
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.apply(ctx, conn, nil, `CREATE TABLE schema_migrations (
+	    version INTEGER PRIMARY KEY,
+	    name TEXT NOT NULL,
+	    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+	)`, func(tx *sql.Tx) error {
+		if !adopt || len(m.Migrations) == 0 {
+			return nil
+		}
+		m.InfoLog.Println("Recording the existing tables as migration 1")
+		query := fmt.Sprintf(`INSERT INTO schema_migrations (version, name) VALUES (1, %s)`, m.Dialect.Placeholder(1))
+		_, err := tx.ExecContext(ctx, query, m.Migrations[0].Name)
+		return err
+	})
+}
+
+// apply runs before when it is set, a migration's SQL and then record in
+// one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, before func(tx *sql.Tx) error, statements string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if before != nil {
+		if err := before(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// step runs a migration's Go step, if it has one
+func (m *Migrator) step(ctx context.Context, tx *sql.Tx, step Step) error {
+	if step == nil {
+		return nil
+	}
+	return step(ctx, tx, m.Storage)
+}
+
+// version reads the latest applied version from schema_migrations
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// hasTable reports whether a table exists
+func (m *Migrator) hasTable(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var count int
+	err := conn.QueryRowContext(ctx, m.Dialect.HasTable, name).Scan(&count)
+	return count > 0, err
+}
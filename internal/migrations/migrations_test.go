@@ -0,0 +1,303 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/storage"
+	"testing"
+	"testing/fstest"
+)
+
+// sqlFile is the content of a migration file in a test file system
+func sqlFile(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+var loadTests = []struct {
+	name  string
+	files fstest.MapFS
+	valid bool
+}{
+	{"ordered", fstest.MapFS{
+		"0002_second.up.sql":   sqlFile("up 2"),
+		"0002_second.down.sql": sqlFile("down 2"),
+		"0001_first.up.sql":    sqlFile("up 1"),
+		"0001_first.down.sql":  sqlFile("down 1"),
+		"README.md":            sqlFile("ignored"),
+	}, true},
+	{"missing down", fstest.MapFS{
+		"0001_first.up.sql": sqlFile("up 1"),
+	}, false},
+	{"gap", fstest.MapFS{
+		"0001_first.up.sql":   sqlFile("up 1"),
+		"0001_first.down.sql": sqlFile("down 1"),
+		"0003_third.up.sql":   sqlFile("up 3"),
+		"0003_third.down.sql": sqlFile("down 3"),
+	}, false},
+	{"names differ", fstest.MapFS{
+		"0001_first.up.sql":   sqlFile("up 1"),
+		"0001_other.down.sql": sqlFile("down 1"),
+	}, false},
+	{"bad name", fstest.MapFS{
+		"first.sql": sqlFile("up 1"),
+	}, false},
+}
+
+func TestLoad(t *testing.T) {
+	for _, e := range loadTests {
+		migrations, err := Load(e.files)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid to be %v, got error %v", e.name, e.valid, err)
+			continue
+		}
+		if !e.valid {
+			continue
+		}
+		if len(migrations) != 2 || migrations[0].Name != "first" || migrations[1].Up != "up 2" || migrations[1].Down != "down 2" {
+			t.Errorf("%s: unexpected migrations %+v", e.name, migrations)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for _, dialect := range []Dialect{Postgres, SQLite} {
+		m, err := NewDialect(nil, dialect, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect.Name, err)
+		}
+		if m.Latest() < 2 {
+			t.Errorf("%s: expected at least 2 migrations, got %d", dialect.Name, m.Latest())
+		}
+		if m.Migrations[0].Name != "initial" {
+			t.Errorf("%s: expected the first migration to create the initial schema, got %s", dialect.Name, m.Migrations[0].Name)
+		}
+		for name := range steps[dialect.Name] {
+			if !hasStep(m.Migrations, name) {
+				t.Errorf("%s: expected migration %s to have its steps", dialect.Name, name)
+			}
+		}
+	}
+}
+
+// hasStep reports whether the migration named name has both of its steps
+func hasStep(migrations []Migration, name string) bool {
+	for _, m := range migrations {
+		if m.Name == name {
+			return m.AfterUp != nil && m.BeforeDown != nil
+		}
+	}
+	return false
+}
+
+func TestMigrator(t *testing.T) {
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	m, err := New(db, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := m.Check(ctx); !errors.Is(err, ErrPending) {
+		t.Errorf("expected an empty database to have pending migrations, got %v", err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != m.Latest() {
+		t.Errorf("expected %d migrations applied, got %d", m.Latest(), applied)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Errorf("expected the schema to be current, got %v", err)
+	}
+	if applied, err := m.Up(ctx); err != nil || applied != 0 {
+		t.Errorf("expected nothing left to apply, got %d, %v", applied, err)
+	}
+
+	undone, err := m.Down(ctx, 1)
+	if err != nil || undone != 1 {
+		t.Fatalf("expected one migration undone, got %d, %v", undone, err)
+	}
+	if version, _ := m.Version(ctx); version != m.Latest()-1 {
+		t.Errorf("expected version %d after going down, got %d", m.Latest()-1, version)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A binary that knows fewer migrations refuses the schema
+	older := *m
+	older.Migrations = m.Migrations[:1]
+	if _, err := older.Up(ctx); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("expected ErrNewerSchema from Up, got %v", err)
+	}
+	if err := older.Check(ctx); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("expected ErrNewerSchema from Check, got %v", err)
+	}
+
+	undone, err = m.Down(ctx, m.Latest()+1)
+	if err != nil || undone != m.Latest() {
+		t.Errorf("expected every migration undone, got %d, %v", undone, err)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	files, err := storage.NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ran []string
+	m := &Migrator{DB: db.SQL, Dialect: SQLite, Storage: files, InfoLog: log.New(io.Discard, "", 0)}
+	m.Migrations = []Migration{{
+		Version: 1,
+		Name:    "notes",
+		Up:      `CREATE TABLE notes (text TEXT NOT NULL)`,
+		Down:    `DROP TABLE notes`,
+		// Each step sees the notes table and the migrator's storage
+		AfterUp: func(ctx context.Context, tx *sql.Tx, s storage.Storage) error {
+			ran = append(ran, "after up")
+			if s != files {
+				return errors.New("the step was not given the storage")
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO notes (text) VALUES ('up')`)
+			return err
+		},
+		BeforeDown: func(ctx context.Context, tx *sql.Tx, s storage.Storage) error {
+			ran = append(ran, "before down")
+			var count int
+			return tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM notes`).Scan(&count)
+		},
+	}}
+	ctx := context.Background()
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 || ran[0] != "after up" || ran[1] != "before down" {
+		t.Errorf("expected the steps to run after up and before down, got %v", ran)
+	}
+
+	// A failing step rolls its migration back
+	m.Migrations[0].AfterUp = func(ctx context.Context, tx *sql.Tx, s storage.Storage) error {
+		return errNoStorage
+	}
+	if _, err := m.Up(ctx); !errors.Is(err, errNoStorage) {
+		t.Errorf("expected the step's error, got %v", err)
+	}
+	if version, _ := m.Version(ctx); version != 0 {
+		t.Errorf("expected version 0 after a failed step, got %d", version)
+	}
+}
+
+// TestAdoptPostgres builds the schema the old create_tables.sql made, with a
+// file kept in file_data, and migrates it to the latest version and back. It
+// needs an empty Postgres database, for example
+//
+//	POSTGRES_TEST_DSN="host=localhost dbname=pawprint_test user=postgres sslmode=disable" go test ./internal/migrations
+func TestAdoptPostgres(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	ctx := context.Background()
+
+	// create_tables.sql changes settings of its session, so it runs on a
+	// pool of its own
+	baseline, err := os.ReadFile(filepath.Join("testdata", "create_tables.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup, err := driver.NewDatabase(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setup.ExecContext(ctx, string(baseline)); err != nil {
+		setup.Close()
+		t.Fatalf("the database must be empty: %v", err)
+	}
+	contents := []byte("first_name,last_name\nAda,Lovelace\n")
+	_, err = setup.ExecContext(ctx, `INSERT INTO public.files (task_id, session_id, file_name, file_type, file_data)
+		VALUES ('task-1', 'session-1', 'graduates.csv', 'csv', $1)`, contents)
+	setup.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := driver.NewDatabase(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	m, err := New(&driver.DB{SQL: pool, Driver: driver.Postgres}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		m.Down(ctx, m.Latest())
+		pool.ExecContext(ctx, `DROP TABLE IF EXISTS schema_migrations`)
+	}()
+
+	// The file contents cannot be moved without storage
+	if _, err := m.Up(ctx); !errors.Is(err, errNoStorage) {
+		t.Fatalf("expected errNoStorage, got %v", err)
+	}
+	m.Storage, err = storage.NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var key, checksum string
+	var size int64
+	err = pool.QueryRowContext(ctx, `SELECT f.object_key, f.size, f.checksum FROM public.files f
+		JOIN public.tasks t ON t.id = f.task_id WHERE f.task_id = 'task-1'`).Scan(&key, &size, &checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := storage.ReadAll(ctx, m.Storage, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != storage.Key("task-1", "graduates.csv") || size != int64(len(contents)) || checksum == "" || !bytes.Equal(stored, contents) {
+		t.Errorf("unexpected file %s of %d bytes with checksum %q: %q", key, size, checksum, stored)
+	}
+
+	// Going back to the baseline restores file_data
+	if _, err := m.Down(ctx, m.Latest()-1); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	if err := pool.QueryRowContext(ctx, `SELECT file_data FROM public.files WHERE task_id = 'task-1'`).Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, contents) {
+		t.Errorf("expected file_data restored, got %q", data)
+	}
+}
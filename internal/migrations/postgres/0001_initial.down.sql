@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.files;
+DROP TABLE IF EXISTS public.users;
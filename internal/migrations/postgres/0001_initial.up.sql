@@ -0,0 +1,39 @@
+-- The schema as create_tables.sql created it before migrations were added.
+-- The admin user is inserted rather than copied in with psql.
+
+-- ------------------------
+-- Create the users table
+-- ------------------------
+CREATE TABLE public.users (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    first_name VARCHAR(255) DEFAULT '' NOT NULL,
+    last_name VARCHAR(255) DEFAULT '' NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(60) NOT NULL,
+    access_level INTEGER DEFAULT 1 NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Create a unique index on the email column
+CREATE UNIQUE INDEX users_email_idx ON public.users (email);
+
+-- Insert the initial admin user
+INSERT INTO public.users (id, first_name, last_name, email, password, access_level, created_at, updated_at)
+VALUES (1, 'Timothy', 'Boudreau', 'admin@admin.com', '$2a$12$Wm8SHtNb7v9oRF6RmPP/c.PHE5tERA6mAfvShxcWJWT7i5nwXg94i', 3, '2024-11-28 00:00:00', '2024-11-28 00:00:00');
+
+-- Adjust the sequence to start from the next available id
+SELECT pg_catalog.setval(pg_get_serial_sequence('public.users', 'id'), (SELECT MAX(id) FROM public.users), true);
+
+-- ------------------------
+-- Create the files table
+-- ------------------------
+CREATE TABLE public.files (
+    id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    task_id TEXT NOT NULL,
+    session_id TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    file_type TEXT CHECK (file_type IN ('csv', 'xlsx', 'pdf')) NOT NULL,
+    file_data BYTEA NOT NULL,
+    upload_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+DELETE FROM public.files WHERE file_type IN ('zip', 'exceptions');
+
+ALTER TABLE public.files DROP CONSTRAINT files_file_type_check;
+ALTER TABLE public.files ADD CONSTRAINT files_file_type_check
+    CHECK (file_type IN ('csv', 'xlsx', 'pdf'));
@@ -0,0 +1,5 @@
+-- A task also stores a ZIP of one PDF per graduate and a spreadsheet of the
+-- rows it skipped or failed
+ALTER TABLE public.files DROP CONSTRAINT files_file_type_check;
+ALTER TABLE public.files ADD CONSTRAINT files_file_type_check
+    CHECK (file_type IN ('csv', 'xlsx', 'pdf', 'zip', 'exceptions'));
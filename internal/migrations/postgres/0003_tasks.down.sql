@@ -0,0 +1,2 @@
+ALTER TABLE public.files DROP CONSTRAINT files_task_id_fkey;
+DROP TABLE IF EXISTS public.tasks;
@@ -0,0 +1,33 @@
+-- ------------------------
+-- Create the tasks table
+-- ------------------------
+CREATE TABLE public.tasks (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER REFERENCES public.users (id) ON DELETE SET NULL,
+    input_file TEXT DEFAULT '' NOT NULL,
+    status TEXT CHECK (status IN ('running', 'completed', 'failed', 'cancelled')) NOT NULL,
+    progress INTEGER DEFAULT 0 NOT NULL,
+    status_text TEXT DEFAULT '' NOT NULL,
+    error TEXT DEFAULT '' NOT NULL,
+    printed INTEGER DEFAULT 0 NOT NULL,
+    skipped INTEGER DEFAULT 0 NOT NULL,
+    failed INTEGER DEFAULT 0 NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Find a user's tasks, newest first
+CREATE INDEX tasks_user_id_idx ON public.tasks (user_id, created_at DESC);
+
+-- Tasks used to be kept in memory only. Files stored by them get a finished
+-- task record without an owner, so they can reference it.
+INSERT INTO public.tasks (id, status, progress, created_at, finished_at, updated_at)
+SELECT task_id, 'completed', 100, COALESCE(MIN(upload_time), CURRENT_TIMESTAMP),
+    MAX(upload_time), COALESCE(MAX(upload_time), CURRENT_TIMESTAMP)
+FROM public.files
+GROUP BY task_id;
+
+ALTER TABLE public.files ADD CONSTRAINT files_task_id_fkey
+    FOREIGN KEY (task_id) REFERENCES public.tasks (id) ON DELETE CASCADE;
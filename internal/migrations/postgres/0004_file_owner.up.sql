@@ -0,0 +1,3 @@
+-- Files are served only to the user who uploaded them. Files stored before
+-- this have no owner.
+ALTER TABLE public.files ADD COLUMN user_id INTEGER REFERENCES public.users (id) ON DELETE SET NULL;
@@ -0,0 +1,6 @@
+UPDATE public.tasks SET status = 'cancelled', finished_at = COALESCE(finished_at, CURRENT_TIMESTAMP)
+WHERE status = 'queued';
+
+ALTER TABLE public.tasks DROP CONSTRAINT tasks_status_check;
+ALTER TABLE public.tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('running', 'completed', 'failed', 'cancelled'));
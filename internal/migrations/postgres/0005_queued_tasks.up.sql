@@ -0,0 +1,4 @@
+-- Uploads wait as queued tasks while the most tasks allowed are running
+ALTER TABLE public.tasks DROP CONSTRAINT tasks_status_check;
+ALTER TABLE public.tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('queued', 'running', 'completed', 'failed', 'cancelled'));
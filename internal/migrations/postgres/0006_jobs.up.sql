@@ -0,0 +1,22 @@
+-- ------------------------
+-- Create the jobs table
+-- ------------------------
+-- Tasks waiting for a worker process. A worker claims a job with
+-- SELECT ... FOR UPDATE SKIP LOCKED, sends heartbeats while it runs and
+-- deletes the job once the task record is finished. A claimed job whose
+-- heartbeat stops is taken over by another worker.
+CREATE TABLE public.jobs (
+    task_id TEXT PRIMARY KEY REFERENCES public.tasks (id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    options TEXT DEFAULT '{}' NOT NULL,
+    status TEXT DEFAULT 'pending' CHECK (status IN ('pending', 'claimed')) NOT NULL,
+    claimed_by TEXT DEFAULT '' NOT NULL,
+    attempts INTEGER DEFAULT 0 NOT NULL,
+    cancel_requested BOOLEAN DEFAULT FALSE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    claimed_at TIMESTAMP,
+    heartbeat_at TIMESTAMP
+);
+
+-- Find the oldest job to claim
+CREATE INDEX jobs_status_idx ON public.jobs (status, created_at);
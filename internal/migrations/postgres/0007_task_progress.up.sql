@@ -0,0 +1,15 @@
+-- ------------------------
+-- Create the task_progress table
+-- ------------------------
+-- Every progress update a task reports, in order. Each insert is announced
+-- with pg_notify on the task_progress channel (the payload is the task id)
+-- so any web replica can stream it.
+CREATE TABLE public.task_progress (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    task_id TEXT NOT NULL REFERENCES public.tasks (id) ON DELETE CASCADE,
+    data TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Read a task's events in order
+CREATE INDEX task_progress_task_id_idx ON public.task_progress (task_id, id);
@@ -0,0 +1,5 @@
+-- The migrator has copied the contents back into file_data by now
+ALTER TABLE public.files ALTER COLUMN file_data SET NOT NULL;
+ALTER TABLE public.files DROP COLUMN object_key;
+ALTER TABLE public.files DROP COLUMN size;
+ALTER TABLE public.files DROP COLUMN checksum;
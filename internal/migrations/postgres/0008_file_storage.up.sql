@@ -0,0 +1,7 @@
+-- File contents move out of file_data into file storage, and the files table
+-- records where each is kept, its size and the SHA-256 of its contents. The
+-- migrator copies the contents of existing rows after these columns are
+-- added; the next migration drops file_data.
+ALTER TABLE public.files ADD COLUMN object_key TEXT;
+ALTER TABLE public.files ADD COLUMN size BIGINT;
+ALTER TABLE public.files ADD COLUMN checksum TEXT;
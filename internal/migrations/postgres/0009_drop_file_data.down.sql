@@ -0,0 +1,4 @@
+ALTER TABLE public.files ADD COLUMN file_data BYTEA;
+ALTER TABLE public.files ALTER COLUMN object_key DROP NOT NULL;
+ALTER TABLE public.files ALTER COLUMN size DROP NOT NULL;
+ALTER TABLE public.files ALTER COLUMN checksum DROP NOT NULL;
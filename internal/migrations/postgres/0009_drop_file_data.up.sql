@@ -0,0 +1,5 @@
+-- Every file's contents are in file storage now
+ALTER TABLE public.files ALTER COLUMN object_key SET NOT NULL;
+ALTER TABLE public.files ALTER COLUMN size SET NOT NULL;
+ALTER TABLE public.files ALTER COLUMN checksum SET NOT NULL;
+ALTER TABLE public.files DROP COLUMN file_data;
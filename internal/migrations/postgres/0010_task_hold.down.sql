@@ -0,0 +1 @@
+ALTER TABLE public.tasks DROP COLUMN hold;
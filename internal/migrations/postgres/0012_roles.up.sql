@@ -0,0 +1,5 @@
+-- Access levels become roles: 1 viewer, 2 operator, 3 admin. Users who
+-- could upload (1) become operators and admins (2) become admins; the
+-- seeded admin is already 3. The column default stays 1, as in SQLite, so a
+-- user inserted without a role can do the least.
+UPDATE public.users SET access_level = access_level + 1 WHERE access_level < 3;
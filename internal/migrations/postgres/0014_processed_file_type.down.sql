@@ -0,0 +1,5 @@
+UPDATE public.files SET file_type = 'xlsx' WHERE file_type = 'processed';
+
+ALTER TABLE public.files DROP CONSTRAINT files_file_type_check;
+ALTER TABLE public.files ADD CONSTRAINT files_file_type_check
+    CHECK (file_type IN ('csv', 'xlsx', 'pdf', 'zip', 'exceptions'));
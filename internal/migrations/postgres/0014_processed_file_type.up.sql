@@ -0,0 +1,8 @@
+-- The processed workbook of a task gets its own type, so it is kept by its
+-- own retention rule rather than the one for uploads
+ALTER TABLE public.files DROP CONSTRAINT files_file_type_check;
+ALTER TABLE public.files ADD CONSTRAINT files_file_type_check
+    CHECK (file_type IN ('csv', 'xlsx', 'pdf', 'zip', 'exceptions', 'processed'));
+
+UPDATE public.files SET file_type = 'processed'
+WHERE file_type = 'xlsx' AND file_name = task_id || '_processed.xlsx';
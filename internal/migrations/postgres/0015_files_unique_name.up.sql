@@ -0,0 +1,6 @@
+-- A task has one record per file name, so a retried task replaces the
+-- records of the files it stores again. Earlier retries left duplicates,
+-- which point at the same object; the newest is kept.
+DELETE FROM public.files WHERE id NOT IN (SELECT MAX(id) FROM public.files GROUP BY task_id, file_name);
+
+CREATE UNIQUE INDEX files_task_id_file_name_idx ON public.files (task_id, file_name);
@@ -0,0 +1 @@
+ALTER TABLE public.tasks DROP COLUMN heartbeat_at;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS task_progress;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,76 @@
+-- The initial schema, matching the first Postgres migration
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name VARCHAR(255) DEFAULT '' NOT NULL,
+    last_name VARCHAR(255) DEFAULT '' NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(60) NOT NULL,
+    access_level INTEGER DEFAULT 1 NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX users_email_idx ON users (email);
+
+-- Insert the initial admin user
+INSERT INTO users (id, first_name, last_name, email, password, access_level, created_at, updated_at)
+VALUES (1, 'Timothy', 'Boudreau', 'admin@admin.com', '$2a$12$Wm8SHtNb7v9oRF6RmPP/c.PHE5tERA6mAfvShxcWJWT7i5nwXg94i', 3, '2024-11-28 00:00:00', '2024-11-28 00:00:00');
+
+CREATE TABLE tasks (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    input_file TEXT DEFAULT '' NOT NULL,
+    status TEXT CHECK (status IN ('queued', 'running', 'completed', 'failed', 'cancelled')) NOT NULL,
+    progress INTEGER DEFAULT 0 NOT NULL,
+    status_text TEXT DEFAULT '' NOT NULL,
+    error TEXT DEFAULT '' NOT NULL,
+    printed INTEGER DEFAULT 0 NOT NULL,
+    skipped INTEGER DEFAULT 0 NOT NULL,
+    failed INTEGER DEFAULT 0 NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX tasks_user_id_idx ON tasks (user_id, created_at DESC);
+
+-- Every progress update a task reports, in order
+CREATE TABLE task_progress (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    data TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX task_progress_task_id_idx ON task_progress (task_id, id);
+
+CREATE TABLE files (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    user_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    file_name TEXT NOT NULL,
+    file_type TEXT CHECK (file_type IN ('csv', 'xlsx', 'pdf', 'zip', 'exceptions')) NOT NULL,
+    object_key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    checksum TEXT NOT NULL,
+    upload_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tasks waiting for a worker process
+CREATE TABLE jobs (
+    task_id TEXT PRIMARY KEY REFERENCES tasks (id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    options TEXT DEFAULT '{}' NOT NULL,
+    status TEXT DEFAULT 'pending' CHECK (status IN ('pending', 'claimed')) NOT NULL,
+    claimed_by TEXT DEFAULT '' NOT NULL,
+    attempts INTEGER DEFAULT 0 NOT NULL,
+    cancel_requested BOOLEAN DEFAULT FALSE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    claimed_at TIMESTAMP,
+    heartbeat_at TIMESTAMP
+);
+
+CREATE INDEX jobs_status_idx ON jobs (status, created_at);
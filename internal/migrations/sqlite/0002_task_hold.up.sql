@@ -0,0 +1,2 @@
+-- Keeps a task's files past their retention, e.g. for an audit
+ALTER TABLE tasks ADD COLUMN hold BOOLEAN DEFAULT FALSE NOT NULL;
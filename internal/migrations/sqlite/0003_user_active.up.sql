@@ -0,0 +1,2 @@
+-- Deactivated users keep their tasks but can no longer sign in
+ALTER TABLE users ADD COLUMN active BOOLEAN DEFAULT TRUE NOT NULL;
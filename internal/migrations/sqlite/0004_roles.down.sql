@@ -0,0 +1 @@
+UPDATE users SET access_level = access_level - 1 WHERE access_level > 1;
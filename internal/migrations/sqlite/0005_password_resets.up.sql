@@ -0,0 +1,13 @@
+-- Links emailed by "forgot password". Only the SHA-256 of each token is kept,
+-- and a token works once, before it expires.
+CREATE TABLE password_resets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX password_resets_token_hash_idx ON password_resets (token_hash);
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
@@ -0,0 +1,21 @@
+CREATE TABLE files_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    user_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    file_name TEXT NOT NULL,
+    file_type TEXT CHECK (file_type IN ('csv', 'xlsx', 'pdf', 'zip', 'exceptions')) NOT NULL,
+    object_key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    checksum TEXT NOT NULL,
+    upload_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO files_old (id, task_id, session_id, user_id, file_name, file_type, object_key, size, checksum, upload_time)
+SELECT id, task_id, session_id, user_id, file_name,
+    CASE WHEN file_type = 'processed' THEN 'xlsx' ELSE file_type END,
+    object_key, size, checksum, upload_time
+FROM files;
+
+DROP TABLE files;
+ALTER TABLE files_old RENAME TO files;
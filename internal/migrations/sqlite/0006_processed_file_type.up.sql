@@ -0,0 +1,24 @@
+-- The processed workbook of a task gets its own type, so it is kept by its
+-- own retention rule rather than the one for uploads. SQLite cannot change
+-- a CHECK constraint, so the table is rebuilt.
+CREATE TABLE files_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    user_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    file_name TEXT NOT NULL,
+    file_type TEXT CHECK (file_type IN ('csv', 'xlsx', 'pdf', 'zip', 'exceptions', 'processed')) NOT NULL,
+    object_key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    checksum TEXT NOT NULL,
+    upload_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO files_new (id, task_id, session_id, user_id, file_name, file_type, object_key, size, checksum, upload_time)
+SELECT id, task_id, session_id, user_id, file_name,
+    CASE WHEN file_type = 'xlsx' AND file_name = task_id || '_processed.xlsx' THEN 'processed' ELSE file_type END,
+    object_key, size, checksum, upload_time
+FROM files;
+
+DROP TABLE files;
+ALTER TABLE files_new RENAME TO files;
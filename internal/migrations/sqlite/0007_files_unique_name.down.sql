@@ -0,0 +1 @@
+DROP INDEX files_task_id_file_name_idx;
@@ -0,0 +1,3 @@
+-- Processes running tasks themselves send heartbeats, so a restarting
+-- replica only fails the tasks of processes that have stopped
+ALTER TABLE tasks ADD COLUMN heartbeat_at TIMESTAMP;
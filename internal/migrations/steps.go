@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"pawprintpublic/internal/storage"
+)
+
+// steps are the Go steps of the embedded migrations, by dialect and then
+// migration name
+var steps = map[string]map[string]Migration{
+	"postgres": {
+		"file_storage": {AfterUp: moveFileData, BeforeDown: restoreFileData},
+	},
+}
+
+// errNoStorage is returned by steps that have file contents to move but were
+// given no storage to move them to or from
+var errNoStorage = errors.New("file contents need moving, but the migrator has no file storage")
+
+// moveFileData stores the contents of every file still kept in file_data
+// under its object key and records the key, size and checksum. Rows are
+// moved one at a time so only one file is held in memory.
+func moveFileData(ctx context.Context, tx *sql.Tx, files storage.Storage) error {
+	ids, err := fileIDs(ctx, tx, `SELECT id FROM public.files WHERE object_key IS NULL ORDER BY id`)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	if files == nil {
+		return errNoStorage
+	}
+
+	for _, id := range ids {
+		var taskID, fileName string
+		var data []byte
+		err := tx.QueryRowContext(ctx, `SELECT task_id, file_name, file_data FROM public.files WHERE id = $1`, id).
+			Scan(&taskID, &fileName, &data)
+		if err != nil {
+			return err
+		}
+
+		key := storage.Key(taskID, fileName)
+		if err := files.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+			return fmt.Errorf("failed to store file %d: %w", id, err)
+		}
+		sum := sha256.Sum256(data)
+		_, err = tx.ExecContext(ctx, `UPDATE public.files SET object_key = $1, size = $2, checksum = $3 WHERE id = $4`,
+			key, len(data), hex.EncodeToString(sum[:]), id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFileData reads the contents of every file back into file_data. The
+// objects are left in storage.
+func restoreFileData(ctx context.Context, tx *sql.Tx, files storage.Storage) error {
+	ids, err := fileIDs(ctx, tx, `SELECT id FROM public.files WHERE file_data IS NULL ORDER BY id`)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	if files == nil {
+		return errNoStorage
+	}
+
+	for _, id := range ids {
+		var key string
+		if err := tx.QueryRowContext(ctx, `SELECT object_key FROM public.files WHERE id = $1`, id).Scan(&key); err != nil {
+			return err
+		}
+		data, err := storage.ReadAll(ctx, files, key)
+		if err != nil {
+			return fmt.Errorf("failed to read file %d: %w", id, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE public.files SET file_data = $1 WHERE id = $2`, data, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileIDs reads the ids of the files a query selects
+func fileIDs(ctx context.Context, tx *sql.Tx, query string) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -1,3 +1,6 @@
+-- create_tables.sql as it was before migrations were added, with the COPY
+-- of the admin user, which only psql can run, turned into an INSERT
+
 SET statement_timeout = 0;
 SET lock_timeout = 0;
 SET idle_in_transaction_session_timeout = 0;
@@ -34,9 +37,8 @@ CREATE UNIQUE INDEX users_email_idx ON public.users (email);
 -- Temporarily disable identity insert restrictions
 SET session_replication_role = 'replica';
 
-COPY public.users (id, first_name, last_name, email, password, access_level, created_at, updated_at) FROM stdin;
-1	Timothy	Boudreau	admin@admin.com	$2a$12$Wm8SHtNb7v9oRF6RmPP/c.PHE5tERA6mAfvShxcWJWT7i5nwXg94i	3	2024-11-28 00:00:00	2024-11-28 00:00:00
-\.
+INSERT INTO public.users (id, first_name, last_name, email, password, access_level, created_at, updated_at)
+VALUES (1, 'Timothy', 'Boudreau', 'admin@admin.com', '$2a$12$Wm8SHtNb7v9oRF6RmPP/c.PHE5tERA6mAfvShxcWJWT7i5nwXg94i', 3, '2024-11-28 00:00:00', '2024-11-28 00:00:00');
 
 -- Re-enable identity insert restrictions
 SET session_replication_role = 'origin';
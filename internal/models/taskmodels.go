@@ -0,0 +1,74 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Task is the task model, one record per diploma run
+type Task struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	InputFile  string    `json:"input_file"`
+	Status     string    `json:"status"`
+	Progress   int       `json:"progress"`
+	StatusText string    `json:"status_text"`
+	Error      string    `json:"error"`
+	Printed    int       `json:"printed"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"` // zero until the task stops
+	UpdatedAt  time.Time `json:"updated_at"`
+	Hold       bool      `json:"hold"`            // keeps the task's files past their retention
+	Files      []File    `json:"files,omitempty"` // filled in by listings
+}
+
+// File describes a stored file without its contents
+type File struct {
+	ID         int       `json:"id"`
+	TaskID     string    `json:"task_id"`
+	SessionID  string    `json:"-"`
+	UserID     int       `json:"-"`
+	FileName   string    `json:"file_name"`
+	FileType   string    `json:"file_type"`
+	ObjectKey  string    `json:"-"`    // where the contents are kept in storage
+	Size       int64     `json:"size"` // in bytes
+	Checksum   string    `json:"-"`    // hex SHA-256 of the contents
+	UploadTime time.Time `json:"upload_time"`
+}
+
+// DownloadName names a stored file after the workbook it came from, so
+// spring.xlsx gives spring.pdf and spring_exceptions.xlsx rather than names
+// built from the task id
+func (f File) DownloadName(inputFile string) string {
+	base := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	if inputFile == "" || base == "" || !strings.Contains(f.FileName, f.TaskID) {
+		return f.FileName
+	}
+	return strings.Replace(f.FileName, f.TaskID, base, 1)
+}
+
+// Job is a task waiting for, or held by, a worker process
+type Job struct {
+	TaskID          string    `json:"task_id"`
+	SessionID       string    `json:"session_id"`
+	Options         string    `json:"options"` // JSON encoded output options
+	Status          string    `json:"status"`  // pending or claimed
+	ClaimedBy       string    `json:"claimed_by"`
+	Attempts        int       `json:"attempts"`
+	CancelRequested bool      `json:"cancel_requested"`
+	CreatedAt       time.Time `json:"created_at"`
+	ClaimedAt       time.Time `json:"claimed_at"`
+	HeartbeatAt     time.Time `json:"heartbeat_at"`
+}
+
+// ProgressEvent is one progress update a task recorded
+type ProgressEvent struct {
+	ID        int64     `json:"id"`
+	TaskID    string    `json:"task_id"`
+	Data      string    `json:"data"` // JSON encoded progress update
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "pawprintpublic/internal/forms"
+import (
+	"pawprintpublic/internal/forms"
+	"pawprintpublic/internal/roles"
+)
 
 // TemplateData holds data sent from handlers to templates
 type TemplateData struct {
@@ -17,3 +20,9 @@ type TemplateData struct {
 	IsAuthenticated int
 	UserRole        int
 }
+
+// Can reports whether the signed in user's role has a permission, e.g.
+// {{if .Can "users:manage"}}, so pages hide what the routes would refuse
+func (td *TemplateData) Can(permission string) bool {
+	return roles.Role(td.UserRole).Can(roles.Permission(permission))
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"pawprintpublic/internal/roles"
+	"time"
+)
 
 // User is the user model
 type User struct {
@@ -10,6 +13,12 @@ type User struct {
 	Email       string    `json:"email"`
 	Password    string    `json:"password"`
 	AccessLevel int       `json:"access_level"`
+	Active      bool      `json:"active"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"created_by"`
 }
+
+// Role is what the user's access level lets them do
+func (u User) Role() roles.Role {
+	return roles.Role(u.AccessLevel)
+}
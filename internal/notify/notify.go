@@ -0,0 +1,159 @@
+// Package notify emails uploaders when their tasks finish, since large runs
+// take long enough that people close the tab
+package notify
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/links"
+	"pawprintpublic/internal/mailer"
+	"pawprintpublic/internal/models"
+	"strings"
+	"time"
+)
+
+// Template is the email template completion emails are written with
+const Template = "task-finished"
+
+// Store is what a Completion needs to find the uploader and their files
+type Store interface {
+	GetUserByID(id int) (models.User, error)
+	GetTaskFiles(taskID string) ([]models.File, error)
+}
+
+// Completion sends the uploader of a finished task a summary with signed
+// links to its files
+type Completion struct {
+	Store    Store
+	Mail     *mailer.Mail
+	Signer   *links.Signer
+	BaseURL  string        // where the web tier is reached, e.g. https://pawprint.example.com
+	LinkTTL  time.Duration // how long the links stay valid
+	ErrorLog *log.Logger
+}
+
+// FromEnv sets up completion emails from the LINK_SIGNING_KEY,
+// DOWNLOAD_LINK_TTL and APP_URL settings. Every process that finishes tasks
+// or serves the links needs the same key.
+func FromEnv(store Store, mail *mailer.Mail, errorLog *log.Logger) (*Completion, error) {
+	key, generated, err := links.KeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		errorLog.Println("LINK_SIGNING_KEY is not set; emailed download links stop working when the process restarts")
+	}
+	ttl, err := links.TTLFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := "http://localhost:8080"
+	if value := os.Getenv("APP_URL"); value != "" {
+		baseURL = strings.TrimRight(value, "/")
+	}
+
+	return &Completion{
+		Store:    store,
+		Mail:     mail,
+		Signer:   links.NewSigner(key),
+		BaseURL:  baseURL,
+		LinkTTL:  ttl,
+		ErrorLog: errorLog,
+	}, nil
+}
+
+// TaskFinished queues the email for a finished task. Cancelled tasks and
+// tasks without an uploader get none.
+func (c *Completion) TaskFinished(record models.Task) {
+	if record.Status == diplomapdfs.StatusCancelled || record.UserID == 0 {
+		return
+	}
+
+	user, err := c.Store.GetUserByID(record.UserID)
+	if err != nil {
+		c.ErrorLog.Printf("Task %s: cannot find the uploader to email: %v", record.ID, err)
+		return
+	}
+	if user.Email == "" {
+		return
+	}
+
+	var files []models.File
+	if record.Status == diplomapdfs.StatusCompleted {
+		files, err = c.Store.GetTaskFiles(record.ID)
+		if err != nil {
+			c.ErrorLog.Printf("Task %s: cannot list files to email: %v", record.ID, err)
+			return
+		}
+	}
+
+	// The mailer calls Done once the message is sent
+	c.Mail.Wait.Add(1)
+	c.Mail.MailerChan <- c.message(record, user, files, time.Now())
+}
+
+// Link is a signed download link in a completion email
+type Link struct {
+	Name string
+	URL  string
+}
+
+// message writes the email for a finished task at time now
+func (c *Completion) message(record models.Task, user models.User, files []models.File, now time.Time) mailer.Message {
+	expires := now.Add(c.LinkTTL)
+
+	var downloads []Link
+	for _, f := range files {
+		// The upload is stored with the outputs under its own type; they have
+		// it already
+		if f.FileType == "xlsx" {
+			continue
+		}
+		query := c.Signer.Query(record.ID, f.FileName, expires)
+		downloads = append(downloads, Link{
+			Name: fileLabel(f.FileType) + " (" + f.DownloadName(record.InputFile) + ")",
+			URL:  c.BaseURL + "/shared/download?" + query.Encode(),
+		})
+	}
+
+	subject := fmt.Sprintf("Your diplomas for %s are ready", record.InputFile)
+	if record.Status == diplomapdfs.StatusFailed {
+		subject = fmt.Sprintf("Diploma generation for %s failed", record.InputFile)
+	}
+
+	return mailer.Message{
+		To:       user.Email,
+		Subject:  subject,
+		Template: Template,
+		DataMap: map[string]any{
+			"firstName": user.FirstName,
+			"inputFile": record.InputFile,
+			"failed":    record.Status == diplomapdfs.StatusFailed,
+			"error":     record.Error,
+			"printed":   record.Printed,
+			"skipped":   record.Skipped + record.Failed,
+			"links":     downloads,
+			"expires":   expires.Format("Jan 2, 2006 at 15:04 MST"),
+			"jobsURL":   c.BaseURL + "/jobs",
+		},
+	}
+}
+
+// fileLabel names a type of output file for people
+func fileLabel(fileType string) string {
+	switch fileType {
+	case "pdf":
+		return "PDF"
+	case "zip":
+		return "ZIP of individual diplomas"
+	case "exceptions":
+		return "Exceptions spreadsheet"
+	case "processed":
+		return "Processed workbook"
+	default:
+		return fileType
+	}
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/url"
+	"pawprintpublic/internal/diplomapdfs"
+	"pawprintpublic/internal/links"
+	"pawprintpublic/internal/mailer"
+	"pawprintpublic/internal/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryStore struct{}
+
+func (memoryStore) GetUserByID(id int) (models.User, error) {
+	if id != 7 {
+		return models.User{}, errors.New("user not found")
+	}
+	return models.User{ID: 7, FirstName: "Ada", Email: "ada@example.com"}, nil
+}
+
+func (memoryStore) GetTaskFiles(taskID string) ([]models.File, error) {
+	return []models.File{
+		{TaskID: taskID, FileName: taskID + ".xlsx", FileType: "xlsx"},
+		{TaskID: taskID, FileName: taskID + "_processed.xlsx", FileType: "processed"},
+		{TaskID: taskID, FileName: taskID + ".pdf", FileType: "pdf"},
+		{TaskID: taskID, FileName: taskID + "_exceptions.xlsx", FileType: "exceptions"},
+	}, nil
+}
+
+func newTestCompletion() (*Completion, *mailer.Mail) {
+	mail := &mailer.Mail{Wait: &sync.WaitGroup{}, MailerChan: make(chan mailer.Message, 10)}
+	return &Completion{
+		Store:    memoryStore{},
+		Mail:     mail,
+		Signer:   links.NewSigner([]byte("0123456789abcdef0123456789abcdef")),
+		BaseURL:  "https://pawprint.example.com",
+		LinkTTL:  time.Hour,
+		ErrorLog: log.New(io.Discard, "", 0),
+	}, mail
+}
+
+func TestTaskFinished(t *testing.T) {
+	c, mail := newTestCompletion()
+	c.TaskFinished(models.Task{
+		ID: "spring", UserID: 7, InputFile: "spring.xlsx",
+		Status: diplomapdfs.StatusCompleted, Printed: 812, Skipped: 3, Failed: 1,
+	})
+
+	var msg mailer.Message
+	select {
+	case msg = <-mail.MailerChan:
+	default:
+		t.Fatal("expected an email to be queued")
+	}
+	if msg.To != "ada@example.com" || msg.Template != Template {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if msg.DataMap["printed"] != 812 || msg.DataMap["skipped"] != 4 {
+		t.Errorf("unexpected summary %v", msg.DataMap)
+	}
+
+	// The uploaded workbook is left out, and every link verifies
+	downloads := msg.DataMap["links"].([]Link)
+	if len(downloads) != 3 {
+		t.Fatalf("expected links to the processed workbook, the PDF and the exceptions, got %+v", downloads)
+	}
+	if downloads[0].Name != "Processed workbook (spring_processed.xlsx)" {
+		t.Errorf("expected the processed workbook first, got %q", downloads[0].Name)
+	}
+	for _, link := range downloads {
+		u, err := url.Parse(link.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(link.URL, "https://pawprint.example.com/shared/download?") {
+			t.Errorf("unexpected link %s", link.URL)
+		}
+		if err := c.Signer.Verify(u.Query(), time.Now()); err != nil {
+			t.Errorf("link %s does not verify: %v", link.URL, err)
+		}
+	}
+
+	// Both templates render the summary
+	for _, name := range []string{"task-finished.html.tmpl", "task-finished.plain.tmpl"} {
+		tmpl, err := template.ParseFiles("../../email-templates/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&out, "body", msg.DataMap); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.Contains(out.String(), "Diplomas produced: 812") {
+			t.Errorf("%s does not show the summary:\n%s", name, out.String())
+		}
+	}
+}
+
+func TestTaskFinishedSkips(t *testing.T) {
+	c, mail := newTestCompletion()
+	c.TaskFinished(models.Task{ID: "stopped", UserID: 7, Status: diplomapdfs.StatusCancelled})
+	c.TaskFinished(models.Task{ID: "unknown", UserID: 9, Status: diplomapdfs.StatusCompleted})
+
+	select {
+	case msg := <-mail.MailerChan:
+		t.Errorf("expected no email, got %+v", msg)
+	default:
+	}
+
+	c.TaskFinished(models.Task{ID: "bad", UserID: 7, InputFile: "bad.xlsx", Status: diplomapdfs.StatusFailed, Error: "no Output sheet"})
+	msg := <-mail.MailerChan
+	if msg.DataMap["failed"] != true || len(msg.DataMap["links"].([]Link)) != 0 {
+		t.Errorf("expected a failure email without links, got %v", msg.DataMap)
+	}
+}
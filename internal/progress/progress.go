@@ -0,0 +1,127 @@
+// Package progress wakes SSE handlers when a task they follow records new
+// progress. The events themselves live in the task_progress table; a broker
+// only says when to read them again.
+package progress
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres notification channel task progress is announced on
+const Channel = "task_progress"
+
+// Broker tells subscribers when a task has new progress
+type Broker interface {
+	// Notify wakes the subscribers of a task
+	Notify(taskID string)
+	// Subscribe returns a channel that receives a value whenever the task
+	// may have new progress, and a function that ends the subscription
+	Subscribe(taskID string) (<-chan struct{}, func())
+}
+
+// LocalBroker passes notifications between goroutines of one process
+type LocalBroker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+// NewLocalBroker creates a broker for tasks run in this process
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+// Notify wakes the subscribers of a task. It never blocks: a subscriber that
+// has not read its last wake-up already knows to look again.
+func (b *LocalBroker) Notify(taskID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[taskID] {
+		wake(ch)
+	}
+}
+
+// Subscribe follows a task until the returned function is called
+func (b *LocalBroker) Subscribe(taskID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[taskID] == nil {
+		b.subs[taskID] = make(map[chan struct{}]struct{})
+	}
+	b.subs[taskID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[taskID], ch)
+		if len(b.subs[taskID]) == 0 {
+			delete(b.subs, taskID)
+		}
+	}
+}
+
+// wakeAll wakes every subscriber, for when notifications may have been lost
+func (b *LocalBroker) wakeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subs := range b.subs {
+		for ch := range subs {
+			wake(ch)
+		}
+	}
+}
+
+// wake signals ch unless a signal is already waiting
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// PostgresBroker hears about progress recorded by any process through
+// LISTEN on the task_progress channel
+type PostgresBroker struct {
+	*LocalBroker
+	listener *pq.Listener
+}
+
+// NewPostgresBroker listens for progress notifications on the database at dsn
+func NewPostgresBroker(dsn string, errorLog *log.Logger) (*PostgresBroker, error) {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			errorLog.Println("Progress listener:", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	b := &PostgresBroker{LocalBroker: NewLocalBroker(), listener: listener}
+	go b.listen()
+	return b, nil
+}
+
+// listen passes notifications on to the subscribers of each task
+func (b *PostgresBroker) listen() {
+	for n := range b.listener.Notify {
+		if n == nil {
+			// The connection was re-established and notifications sent in
+			// the meantime are lost, so have everyone check again
+			b.wakeAll()
+			continue
+		}
+		b.LocalBroker.Notify(n.Extra)
+	}
+}
+
+// Close stops listening
+func (b *PostgresBroker) Close() error {
+	return b.listener.Close()
+}
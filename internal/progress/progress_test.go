@@ -0,0 +1,44 @@
+package progress
+
+import "testing"
+
+func TestLocalBroker(t *testing.T) {
+	b := NewLocalBroker()
+
+	spring, unsubscribe := b.Subscribe("spring")
+	fall, _ := b.Subscribe("fall")
+
+	// Notifications never block, and repeated ones collapse into one wake-up
+	b.Notify("spring")
+	b.Notify("spring")
+	select {
+	case <-spring:
+	default:
+		t.Fatal("expected the spring subscriber to be woken")
+	}
+	select {
+	case <-spring:
+		t.Error("expected repeated notifications to collapse")
+	default:
+	}
+	select {
+	case <-fall:
+		t.Error("expected the fall subscriber not to be woken")
+	default:
+	}
+
+	unsubscribe()
+	b.Notify("spring")
+	select {
+	case <-spring:
+		t.Error("expected no wake-up after unsubscribing")
+	default:
+	}
+
+	b.wakeAll()
+	select {
+	case <-fall:
+	default:
+		t.Error("expected wakeAll to wake every subscriber")
+	}
+}
@@ -3,6 +3,7 @@ package dbrepo
 import (
 	"database/sql"
 	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/driver"
 	"pawprintpublic/internal/repository"
 )
 
@@ -28,6 +29,21 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 	}
 }
 
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqliteDBRepo{
+		App: a,
+		DB:  conn,
+	}
+}
+
+// NewRepo returns the repository for the database db was connected to
+func NewRepo(db *driver.DB, a *config.AppConfig) repository.DatabaseRepo {
+	if db.Driver == driver.SQLite {
+		return NewSQLiteRepo(db.SQL, a)
+	}
+	return NewPostgresRepo(db.SQL, a)
+}
+
 func NewTestingsRepo(a *config.AppConfig) repository.DatabaseRepo {
 	return &testDBRepo{
 		App: a,
@@ -0,0 +1,130 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"pawprintpublic/internal/models"
+	"time"
+)
+
+// InsertJob queues a task for a worker process
+func (m *postgresDBRepo) InsertJob(j models.Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO jobs (task_id, session_id, options) VALUES ($1, $2, $3)`
+	_, err := m.DB.ExecContext(ctx, query, j.TaskID, j.SessionID, j.Options)
+	return err
+}
+
+// ClaimJob hands the oldest pending job to workerID, or a claimed job whose
+// last heartbeat is before staleBefore. Jobs locked by other workers are
+// skipped. It returns sql.ErrNoRows when there is nothing to claim.
+func (m *postgresDBRepo) ClaimJob(workerID string, staleBefore time.Time) (models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE jobs SET status = 'claimed', claimed_by = $1, claimed_at = $2, heartbeat_at = $2,
+	          attempts = attempts + 1
+	          WHERE task_id = (
+	              SELECT task_id FROM jobs
+	              WHERE status = 'pending' OR (status = 'claimed' AND heartbeat_at < $3)
+	              ORDER BY created_at
+	              LIMIT 1
+	              FOR UPDATE SKIP LOCKED
+	          )
+	          RETURNING task_id, session_id, options, status, claimed_by, attempts,
+	          cancel_requested, created_at, claimed_at, heartbeat_at`
+	return scanJob(m.DB.QueryRowContext(ctx, query, workerID, time.Now(), staleBefore))
+}
+
+// HeartbeatJob records that workerID is still running a job and reports
+// whether the job has been cancelled. It returns sql.ErrNoRows when the job
+// is no longer held by workerID.
+func (m *postgresDBRepo) HeartbeatJob(taskID, workerID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE jobs SET heartbeat_at = $1 WHERE task_id = $2 AND claimed_by = $3
+	          RETURNING cancel_requested`
+
+	var cancelRequested bool
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), taskID, workerID).Scan(&cancelRequested)
+	return cancelRequested, err
+}
+
+// CancelJob removes a job no worker has claimed yet, or asks the worker
+// holding it to stop. It returns the status the job had, and sql.ErrNoRows
+// when there is no job for the task.
+func (m *postgresDBRepo) CancelJob(taskID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM jobs WHERE task_id = $1 AND status = 'pending'`, taskID)
+	if err != nil {
+		return "", err
+	}
+	if removed, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if removed > 0 {
+		return "pending", nil
+	}
+
+	result, err = m.DB.ExecContext(ctx, `UPDATE jobs SET cancel_requested = TRUE WHERE task_id = $1`, taskID)
+	if err != nil {
+		return "", err
+	}
+	if flagged, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if flagged == 0 {
+		return "", sql.ErrNoRows
+	}
+	return "claimed", nil
+}
+
+// DeleteJob removes a job held by workerID once its task has finished
+func (m *postgresDBRepo) DeleteJob(taskID, workerID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM jobs WHERE task_id = $1 AND claimed_by = $2`, taskID, workerID)
+	return err
+}
+
+// CountJobs returns how many jobs are waiting for a worker and how many are running
+func (m *postgresDBRepo) CountJobs() (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FILTER (WHERE status = 'pending'), COUNT(*) FILTER (WHERE status = 'claimed') FROM jobs`
+
+	var pending, claimed int
+	err := m.DB.QueryRowContext(ctx, query).Scan(&pending, &claimed)
+	return pending, claimed, err
+}
+
+// scanJob reads a job selected in the column order used by ClaimJob
+func scanJob(row rowScanner) (models.Job, error) {
+	var j models.Job
+	var claimedAt, heartbeatAt sql.NullTime
+
+	err := row.Scan(
+		&j.TaskID,
+		&j.SessionID,
+		&j.Options,
+		&j.Status,
+		&j.ClaimedBy,
+		&j.Attempts,
+		&j.CancelRequested,
+		&j.CreatedAt,
+		&claimedAt,
+		&heartbeatAt,
+	)
+	if err != nil {
+		return j, err
+	}
+
+	j.ClaimedAt = claimedAt.Time
+	j.HeartbeatAt = heartbeatAt.Time
+	return j, nil
+}
@@ -2,51 +2,114 @@ package dbrepo
 
 import (
 	"context"
+	"pawprintpublic/internal/models"
 	"time"
 )
 
-// InsertFile stores a file in the database
-func (m *postgresDBRepo) InsertFile(taskID, sessionID, fileName, fileType string, fileData []byte) error {
+// InsertFile records a file whose contents are already in storage. A task has
+// one record per file name, so storing a file again, as a retried task does,
+// replaces its record.
+func (m *postgresDBRepo) InsertFile(f models.File) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO files (task_id, session_id, file_name, file_type, file_data)
-	          VALUES ($1, $2, $3, $4, $5)`
-	_, err := m.DB.ExecContext(ctx, query, taskID, sessionID, fileName, fileType, fileData)
+	query := `INSERT INTO files (task_id, session_id, user_id, file_name, file_type, object_key, size, checksum)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	          ON CONFLICT (task_id, file_name) DO UPDATE SET
+	              session_id = excluded.session_id, user_id = excluded.user_id, file_type = excluded.file_type,
+	              object_key = excluded.object_key, size = excluded.size, checksum = excluded.checksum,
+	              upload_time = CURRENT_TIMESTAMP`
+	_, err := m.DB.ExecContext(ctx, query, f.TaskID, f.SessionID, nullUserID(f.UserID), f.FileName, f.FileType, f.ObjectKey, f.Size, f.Checksum)
 	return err
 }
 
-// GetFile retrieves a file from the database based on taskID and fileType
-func (m *postgresDBRepo) GetFile(taskID, fileType string) ([]byte, error) {
+// GetFile returns the first file of a type stored for a task
+func (m *postgresDBRepo) GetFile(taskID, fileType string) (models.File, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `SELECT file_data FROM files WHERE task_id = $1 AND file_type = $2`
-	var fileData []byte
-	err := m.DB.QueryRowContext(ctx, query, taskID, fileType).Scan(&fileData)
-	return fileData, err
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time
+	          FROM files WHERE task_id = $1 AND file_type = $2 ORDER BY id LIMIT 1`
+	return scanFile(m.DB.QueryRowContext(ctx, query, taskID, fileType))
 }
 
-// DeleteFilesByTask deletes files associated with a task
-func (m *postgresDBRepo) DeleteFilesByTask(taskID string) error {
+// GetFileByName returns one of several files of the same type stored for a task
+func (m *postgresDBRepo) GetFileByName(taskID, fileType, fileName string) (models.File, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `DELETE FROM files WHERE task_id = $1`
-	_, err := m.DB.ExecContext(ctx, query, taskID)
-	return err
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time
+	          FROM files WHERE task_id = $1 AND file_type = $2 AND file_name = $3`
+	return scanFile(m.DB.QueryRowContext(ctx, query, taskID, fileType, fileName))
+}
+
+// DeleteFilesByTask deletes the records of a task's files and returns their
+// object keys, whose contents the caller removes from storage
+func (m *postgresDBRepo) DeleteFilesByTask(taskID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM files WHERE task_id = $1 RETURNING object_key`
+	rows, err := m.DB.QueryContext(ctx, query, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetAllFiles returns every stored file, oldest first
+func (m *postgresDBRepo) GetAllFiles() ([]models.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time
+	          FROM files ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
 }
 
-// DeleteOldFiles deletes files older than the specified duration
-func (m *postgresDBRepo) DeleteOldFiles(olderThan time.Duration) error {
+// GetExpiredFiles returns the files of a type uploaded at or before a time,
+// oldest first, leaving out the files of tasks on hold
+func (m *postgresDBRepo) GetExpiredFiles(fileType string, uploadedBefore time.Time) ([]models.File, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Calculate the cutoff time
-	cutoff := time.Now().UTC().Add(-olderThan)
-	query := `DELETE FROM files WHERE upload_time <= $1`
+	query := `SELECT f.id, f.task_id, f.file_name, f.file_type, f.object_key, f.size, f.checksum, f.upload_time
+	          FROM files f JOIN tasks t ON t.id = f.task_id
+	          WHERE f.file_type = $1 AND f.upload_time <= $2 AND NOT t.hold
+	          ORDER BY f.upload_time, f.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, fileType, uploadedBefore.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
+}
+
+// DeleteFile deletes the record of a file
+func (m *postgresDBRepo) DeleteFile(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	// Execute the DELETE statement
-	_, err := m.DB.ExecContext(ctx, query, cutoff)
+	query := `DELETE FROM files WHERE id = $1`
+	_, err := m.DB.ExecContext(ctx, query, id)
 	return err
 }
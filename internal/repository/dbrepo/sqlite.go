@@ -2,50 +2,623 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/repository"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-// InsertFile stores a file in the database
-func (m *sqliteDBRepo) InsertFile(taskID, sessionID, fileName, fileType string, fileData []byte) error {
+// AllUsers returns every user, without their passwords
+func (m *sqliteDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, first_name, last_name, email, access_level, active, created_at, updated_at FROM users ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return []models.User{}, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		err = rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			&user.AccessLevel,
+			&user.Active,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUserByID returns a user by id
+func (m *sqliteDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, first_name, last_name, email, password, access_level, active, created_at, updated_at FROM users WHERE id = ?`
+
+	var u models.User
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.Active,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	return u, err
+}
+
+// GetUserByEmail returns the user with an email, ignoring case
+func (m *sqliteDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, first_name, last_name, email, access_level, active, created_at, updated_at FROM users WHERE lower(email) = lower(?)`
+
+	var u models.User
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.AccessLevel,
+		&u.Active,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	return u, err
+}
+
+// UpdateUser updates a user's name, email and access level, returning
+// sql.ErrNoRows when there is no user with its id
+func (m *sqliteDBRepo) UpdateUser(u models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE users SET first_name = ?, last_name = ?, email = ?, access_level = ?, updated_at = ? WHERE id = ?`
+	result, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// InsertUser adds an active user with an already hashed password and
+// returns its id
+func (m *sqliteDBRepo) InsertUser(u models.User, hashedPassword string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+	          VALUES (?, ?, ?, ?, ?, TRUE, ?, ?) RETURNING id`
+
+	now := time.Now()
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, u.FirstName, u.LastName, u.Email, hashedPassword, u.AccessLevel, now, now).Scan(&id)
+	return id, err
+}
+
+// SetUserActive deactivates a user, so they can no longer sign in, or
+// reactivates them
+func (m *sqliteDBRepo) SetUserActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE users SET active = ?, updated_at = ? WHERE id = ?`, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// UpdatePassword replaces a user's password with an already hashed one
+func (m *sqliteDBRepo) UpdatePassword(id int, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE users SET password = ?, updated_at = ? WHERE id = ?`, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// DeleteUser removes a user. Their tasks and files are kept without an owner.
+func (m *sqliteDBRepo) DeleteUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Authenticate authenticates a user. Deactivated users get ErrInactiveUser
+// once their password is checked.
+func (m *sqliteDBRepo) Authenticate(email, testPassword string) (int, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+	var accessLevel int
+	var active bool
+
+	row := m.DB.QueryRowContext(ctx, "SELECT id, password, access_level, active FROM users WHERE lower(email) = lower(?)", email)
+	err := row.Scan(&id, &hashedPassword, &accessLevel, &active)
+	if err != nil {
+		return id, "", accessLevel, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return 0, "", 0, errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", 0, err
+	}
+	if !active {
+		return 0, "", 0, repository.ErrInactiveUser
+	}
+
+	return id, hashedPassword, accessLevel, nil
+}
+
+// InsertPasswordReset records the hash of a token emailed to a user to reset
+// their password
+func (m *sqliteDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, ?)`
+	_, err := m.DB.ExecContext(ctx, query, userID, tokenHash, expiresAt.UTC())
+	return err
+}
+
+// GetPasswordReset returns the id of the user a token was sent to, or
+// sql.ErrNoRows when the token is unknown, used or expired at now
+func (m *sqliteDBRepo) GetPasswordReset(tokenHash string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`
+
+	var userID int
+	err := m.DB.QueryRowContext(ctx, query, tokenHash, now.UTC()).Scan(&userID)
+	return userID, err
+}
+
+// UsePasswordReset spends a token and sets the password of the user it was
+// sent to, returning their id. Their other outstanding tokens are spent too.
+// It returns sql.ErrNoRows when the token is unknown, used or expired at now.
+func (m *sqliteDBRepo) UsePasswordReset(tokenHash, hashedPassword string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING user_id`
+	err = tx.QueryRowContext(ctx, query, now.UTC(), tokenHash, now.UTC()).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, now.UTC(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET password = ?, updated_at = ? WHERE id = ?`, hashedPassword, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// InsertFile records a file whose contents are already in storage. A task has
+// one record per file name, so storing a file again, as a retried task does,
+// replaces its record.
+func (m *sqliteDBRepo) InsertFile(f models.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO files (task_id, session_id, user_id, file_name, file_type, object_key, size, checksum)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	          ON CONFLICT (task_id, file_name) DO UPDATE SET
+	              session_id = excluded.session_id, user_id = excluded.user_id, file_type = excluded.file_type,
+	              object_key = excluded.object_key, size = excluded.size, checksum = excluded.checksum,
+	              upload_time = CURRENT_TIMESTAMP`
+	_, err := m.DB.ExecContext(ctx, query, f.TaskID, f.SessionID, nullUserID(f.UserID), f.FileName, f.FileType, f.ObjectKey, f.Size, f.Checksum)
+	return err
+}
+
+// GetFile returns the first file of a type stored for a task
+func (m *sqliteDBRepo) GetFile(taskID, fileType string) (models.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time
+	          FROM files WHERE task_id = ? AND file_type = ? ORDER BY id LIMIT 1`
+	return scanFile(m.DB.QueryRowContext(ctx, query, taskID, fileType))
+}
+
+// GetFileByName returns one of several files of the same type stored for a task
+func (m *sqliteDBRepo) GetFileByName(taskID, fileType, fileName string) (models.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time
+	          FROM files WHERE task_id = ? AND file_type = ? AND file_name = ?`
+	return scanFile(m.DB.QueryRowContext(ctx, query, taskID, fileType, fileName))
+}
+
+// DeleteFilesByTask deletes the records of a task's files and returns their
+// object keys, whose contents the caller removes from storage
+func (m *sqliteDBRepo) DeleteFilesByTask(taskID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM files WHERE task_id = ? RETURNING object_key`
+	rows, err := m.DB.QueryContext(ctx, query, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetAllFiles returns every stored file, oldest first
+func (m *sqliteDBRepo) GetAllFiles() ([]models.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time
+	          FROM files ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
+}
+
+// GetExpiredFiles returns the files of a type uploaded at or before a time,
+// oldest first, leaving out the files of tasks on hold
+func (m *sqliteDBRepo) GetExpiredFiles(fileType string, uploadedBefore time.Time) ([]models.File, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO files (task_id, session_id, file_name, file_type, file_data) VALUES (?, ?, ?, ?, ?)`
-	_, err := m.DB.ExecContext(ctx, query, taskID, sessionID, fileName, fileType, fileData)
+	cutoff := uploadedBefore.UTC().Format("2006-01-02 15:04:05")
+	query := `SELECT f.id, f.task_id, f.file_name, f.file_type, f.object_key, f.size, f.checksum, f.upload_time
+	          FROM files f JOIN tasks t ON t.id = f.task_id
+	          WHERE f.file_type = ? AND f.upload_time <= ? AND NOT t.hold ORDER BY f.upload_time, f.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, fileType, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
+}
+
+// DeleteFile deletes the record of a file
+func (m *sqliteDBRepo) DeleteFile(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM files WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, query, id)
 	return err
 }
 
-// GetFile retrieves a file from the database based on taskID and fileType
-func (m *sqliteDBRepo) GetFile(taskID, fileType string) ([]byte, error) {
+// InsertTask creates the record for a new task
+func (m *sqliteDBRepo) InsertTask(t models.Task) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `SELECT file_data FROM files WHERE task_id = ? AND file_type = ?`
-	var fileData []byte
-	err := m.DB.QueryRowContext(ctx, query, taskID, fileType).Scan(&fileData)
-	return fileData, err
+	query := `INSERT INTO tasks (id, user_id, input_file, status, progress, status_text, started_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := m.DB.ExecContext(ctx, query, t.ID, nullUserID(t.UserID), t.InputFile, t.Status, t.Progress, t.StatusText, nullTime(t.StartedAt))
+	return err
 }
 
-// DeleteFilesByTask deletes files associated with a task
-func (m *sqliteDBRepo) DeleteFilesByTask(taskID string) error {
+// StartTask marks a queued task as running
+func (m *sqliteDBRepo) StartTask(id string, startedAt time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `DELETE FROM files WHERE task_id = ?`
-	_, err := m.DB.ExecContext(ctx, query, taskID)
+	query := `UPDATE tasks SET status = 'running', started_at = ?, updated_at = ? WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, query, startedAt, startedAt, id)
 	return err
 }
 
-// DeleteOldFiles deletes files older than the specified duration
-func (m *sqliteDBRepo) DeleteOldFiles(olderThan time.Duration) error {
+// AddTaskProgress records a progress update of a running task as its latest
+// status and as a new event
+func (m *sqliteDBRepo) AddTaskProgress(id string, progress int, statusText string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE tasks SET progress = ?, status_text = ?, updated_at = ? WHERE id = ?`, progress, statusText, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO task_progress (task_id, data) VALUES (?, ?)`, id, string(data))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTaskProgress returns the progress events of a task recorded after the
+// event afterID, oldest first
+func (m *sqliteDBRepo) GetTaskProgress(taskID string, afterID int64) ([]models.ProgressEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, task_id, data, created_at FROM task_progress WHERE task_id = ? AND id > ? ORDER BY id`, taskID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanProgressEvents(rows)
+}
+
+// DeleteOldProgress removes progress events older than the given duration
+func (m *sqliteDBRepo) DeleteOldProgress(olderThan time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Calculate the cutoff time in UTC
 	cutoff := time.Now().UTC().Add(-olderThan).Format("2006-01-02 15:04:05")
-	query := `DELETE FROM files WHERE upload_time <= ?`
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM task_progress WHERE created_at < ?`, cutoff)
+	return err
+}
+
+// FinishTask records how a task ended
+func (m *sqliteDBRepo) FinishTask(t models.Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE tasks SET status = ?, progress = ?, status_text = ?, error = ?, printed = ?, skipped = ?, failed = ?, finished_at = ?, updated_at = ? WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, query, t.Status, t.Progress, t.StatusText, t.Error, t.Printed, t.Skipped, t.Failed, t.FinishedAt, time.Now(), t.ID)
+	return err
+}
+
+// GetTaskByID returns a task record by id
+func (m *sqliteDBRepo) GetTaskByID(id string) (models.Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, input_file, status, progress, status_text, error, printed, skipped, failed, created_at, started_at, finished_at, updated_at, hold FROM tasks WHERE id = ?`
+	return scanTask(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// HeartbeatTask records that the process running a task is still alive. The
+// time is written in the format of CURRENT_TIMESTAMP, which it is compared
+// with.
+func (m *sqliteDBRepo) HeartbeatTask(id string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	// Execute the DELETE statement
-	_, err := m.DB.ExecContext(ctx, query, cutoff)
+	_, err := m.DB.ExecContext(ctx, `UPDATE tasks SET heartbeat_at = ? WHERE id = ?`, now.UTC().Format("2006-01-02 15:04:05"), id)
 	return err
 }
+
+// InterruptRunningTasks marks tasks left queued or running by a process that
+// has sent no heartbeat since staleBefore as failed. Tasks with a job belong
+// to the workers and are left alone.
+func (m *sqliteDBRepo) InterruptRunningTasks(staleBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cutoff := staleBefore.UTC().Format("2006-01-02 15:04:05")
+	query := `UPDATE tasks SET status = 'failed', status_text = 'Interrupted', error = 'interrupted by a server restart', finished_at = ?, updated_at = ? WHERE status IN ('queued', 'running') AND COALESCE(heartbeat_at, created_at) < ? AND NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.task_id = tasks.id)`
+	result, err := m.DB.ExecContext(ctx, query, now, now, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetTaskHold places a task on hold, keeping its files past their retention,
+// or releases it
+func (m *sqliteDBRepo) SetTaskHold(id string, hold bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE tasks SET hold = ?, updated_at = ? WHERE id = ?`, hold, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// GetHeldTasks returns the tasks on hold, newest first
+func (m *sqliteDBRepo) GetHeldTasks() ([]models.Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, input_file, status, progress, status_text, error, printed, skipped, failed, created_at, started_at, finished_at, updated_at, hold FROM tasks WHERE hold ORDER BY created_at DESC`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTasks(rows)
+}
+
+// GetTaskFiles lists the files stored for a task, without their contents
+func (m *sqliteDBRepo) GetTaskFiles(taskID string) ([]models.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time FROM files WHERE task_id = ? ORDER BY id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
+}
+
+// GetTasksByUser returns a user's most recent tasks, newest first, with the
+// files still stored for each
+func (m *sqliteDBRepo) GetTasksByUser(userID, limit int) ([]models.Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, input_file, status, progress, status_text, error, printed, skipped, failed, created_at, started_at, finished_at, updated_at, hold FROM tasks WHERE user_id = ? ORDER BY created_at DESC, id LIMIT ?`
+	rows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		return tasks, err
+	}
+
+	// Only the files of the tasks above, not of every task the user ran
+	query = `SELECT f.id, f.task_id, f.file_name, f.file_type, f.object_key, f.size, f.checksum, f.upload_time FROM files f WHERE f.task_id IN (SELECT id FROM tasks WHERE user_id = ? ORDER BY created_at DESC, id LIMIT ?) ORDER BY f.id`
+	fileRows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return tasks, err
+	}
+	defer fileRows.Close()
+
+	files, err := scanFiles(fileRows)
+	if err != nil {
+		return tasks, err
+	}
+
+	return attachFiles(tasks, files), nil
+}
+
+// InsertJob queues a task for a worker process
+func (m *sqliteDBRepo) InsertJob(j models.Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `INSERT INTO jobs (task_id, session_id, options) VALUES (?, ?, ?)`, j.TaskID, j.SessionID, j.Options)
+	return err
+}
+
+// ClaimJob hands the oldest pending job to workerID, or a claimed job whose
+// last heartbeat is before staleBefore. SQLite serialises writers, so no row
+// locking is needed. It returns sql.ErrNoRows when there is nothing to claim.
+func (m *sqliteDBRepo) ClaimJob(workerID string, staleBefore time.Time) (models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	query := `UPDATE jobs SET status = 'claimed', claimed_by = ?, claimed_at = ?, heartbeat_at = ?, attempts = attempts + 1 WHERE task_id = (SELECT task_id FROM jobs WHERE status = 'pending' OR (status = 'claimed' AND heartbeat_at < ?) ORDER BY created_at LIMIT 1) RETURNING task_id, session_id, options, status, claimed_by, attempts, cancel_requested, created_at, claimed_at, heartbeat_at`
+	return scanJob(m.DB.QueryRowContext(ctx, query, workerID, now, now, staleBefore))
+}
+
+// HeartbeatJob records that workerID is still running a job and reports
+// whether the job has been cancelled
+func (m *sqliteDBRepo) HeartbeatJob(taskID, workerID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var cancelRequested bool
+	query := `UPDATE jobs SET heartbeat_at = ? WHERE task_id = ? AND claimed_by = ? RETURNING cancel_requested`
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), taskID, workerID).Scan(&cancelRequested)
+	return cancelRequested, err
+}
+
+// CancelJob removes a job no worker has claimed yet, or asks the worker
+// holding it to stop. It returns the status the job had.
+func (m *sqliteDBRepo) CancelJob(taskID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM jobs WHERE task_id = ? AND status = 'pending'`, taskID)
+	if err != nil {
+		return "", err
+	}
+	if removed, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if removed > 0 {
+		return "pending", nil
+	}
+
+	result, err = m.DB.ExecContext(ctx, `UPDATE jobs SET cancel_requested = TRUE WHERE task_id = ?`, taskID)
+	if err != nil {
+		return "", err
+	}
+	if flagged, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if flagged == 0 {
+		return "", sql.ErrNoRows
+	}
+	return "claimed", nil
+}
+
+// DeleteJob removes a job held by workerID once its task has finished
+func (m *sqliteDBRepo) DeleteJob(taskID, workerID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM jobs WHERE task_id = ? AND claimed_by = ?`, taskID, workerID)
+	return err
+}
+
+// CountJobs returns how many jobs are waiting for a worker and how many are running
+func (m *sqliteDBRepo) CountJobs() (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var pending, claimed int
+	query := `SELECT COUNT(CASE WHEN status = 'pending' THEN 1 END), COUNT(CASE WHEN status = 'claimed' THEN 1 END) FROM jobs`
+	err := m.DB.QueryRowContext(ctx, query).Scan(&pending, &claimed)
+	return pending, claimed, err
+}
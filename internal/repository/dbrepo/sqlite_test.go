@@ -0,0 +1,343 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"pawprintpublic/internal/config"
+	"pawprintpublic/internal/driver"
+	"pawprintpublic/internal/migrations"
+	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/repository"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newSQLiteTestRepo returns a repository on a new, migrated SQLite database
+// with a second user, jane@here.ca, whose password is "secret"
+func newSQLiteTestRepo(t *testing.T) repository.DatabaseRepo {
+	t.Helper()
+
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "pawprint.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	migrator, err := migrations.New(db, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.SQL.Exec(`INSERT INTO users (id, first_name, last_name, email, password) VALUES (2, 'Jane', 'Doe', 'jane@here.ca', ?)`, string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRepo(db, &config.AppConfig{})
+}
+
+func TestSQLiteUsers(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+
+	id, _, accessLevel, err := repo.Authenticate("jane@here.ca", "secret")
+	if err != nil || id != 2 || accessLevel != 1 {
+		t.Errorf("expected jane to sign in as user 2 with access level 1, got %d, %d, %v", id, accessLevel, err)
+	}
+	if id, _, _, err := repo.Authenticate("Jane@Here.ca", "secret"); err != nil || id != 2 {
+		t.Errorf("expected the email to match regardless of case, got %d, %v", id, err)
+	}
+	if _, _, _, err := repo.Authenticate("jane@here.ca", "wrong"); err == nil {
+		t.Error("expected a wrong password to be refused")
+	}
+
+	err = repo.UpdateUser(models.User{ID: 2, FirstName: "Janet", LastName: "Doe", Email: "janet@here.ca", AccessLevel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := repo.AllUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d, %v", len(users), err)
+	}
+	admin, err := repo.GetUserByID(1)
+	if err != nil || admin.Email != "admin@admin.com" {
+		t.Errorf("expected the update to leave the admin alone, got %q, %v", admin.Email, err)
+	}
+	jane, _ := repo.GetUserByID(2)
+	if jane.FirstName != "Janet" || jane.AccessLevel != 2 || !jane.Active {
+		t.Errorf("expected user 2 to be updated, got %+v", jane)
+	}
+	if err := repo.UpdateUser(models.User{ID: 9, Email: "nobody@here.ca"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows updating an unknown user, got %v", err)
+	}
+}
+
+func TestSQLiteUserManagement(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("first"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.InsertUser(models.User{FirstName: "Sam", LastName: "Lee", Email: "sam@here.ca", AccessLevel: 1}, string(hash))
+	if err != nil || id != 3 {
+		t.Fatalf("expected Sam to be user 3, got %d, %v", id, err)
+	}
+	if _, err := repo.InsertUser(models.User{Email: "sam@here.ca", AccessLevel: 1}, string(hash)); err == nil {
+		t.Error("expected a second user with the same email to be refused")
+	}
+	if got, _, _, err := repo.Authenticate("sam@here.ca", "first"); err != nil || got != id {
+		t.Errorf("expected Sam to sign in, got %d, %v", got, err)
+	}
+
+	// Deactivating one user leaves the others able to sign in
+	if err := repo.SetUserActive(id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := repo.Authenticate("sam@here.ca", "first"); !errors.Is(err, repository.ErrInactiveUser) {
+		t.Errorf("expected ErrInactiveUser for a deactivated user, got %v", err)
+	}
+	if _, _, _, err := repo.Authenticate("sam@here.ca", "wrong"); err == nil || errors.Is(err, repository.ErrInactiveUser) {
+		t.Errorf("expected a wrong password to be refused before the status is checked, got %v", err)
+	}
+	if _, _, _, err := repo.Authenticate("jane@here.ca", "secret"); err != nil {
+		t.Errorf("expected jane to still sign in, got %v", err)
+	}
+	if err := repo.SetUserActive(id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err = bcrypt.GenerateFromPassword([]byte("second"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdatePassword(id, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := repo.Authenticate("sam@here.ca", "second"); err != nil {
+		t.Errorf("expected the new password to work, got %v", err)
+	}
+	if _, _, _, err := repo.Authenticate("jane@here.ca", "secret"); err != nil {
+		t.Errorf("expected jane's password to be unchanged, got %v", err)
+	}
+
+	// Deleting a user keeps their tasks without an owner
+	if err := repo.InsertTask(models.Task{ID: "task-1", UserID: id, Status: "queued"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteUser(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUserByID(id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the user to be gone, got %v", err)
+	}
+	if task, err := repo.GetTaskByID("task-1"); err != nil || task.UserID != 0 {
+		t.Errorf("expected the task to be kept without an owner, got %+v, %v", task, err)
+	}
+	if users, _ := repo.AllUsers(); len(users) != 2 {
+		t.Errorf("expected the other users to remain, got %d", len(users))
+	}
+
+	for name, err := range map[string]error{
+		"SetUserActive":  repo.SetUserActive(id, false),
+		"UpdatePassword": repo.UpdatePassword(id, string(hash)),
+		"DeleteUser":     repo.DeleteUser(id),
+	} {
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s: expected sql.ErrNoRows for an unknown user, got %v", name, err)
+		}
+	}
+}
+
+func TestSQLiteTasksAndFiles(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+
+	err := repo.InsertTask(models.Task{ID: "task-1", UserID: 2, InputFile: "spring.xlsx", Status: "queued"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.StartTask("task-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddTaskProgress("task-1", 50, "Halfway", []byte(`{"progress":50}`)); err != nil {
+		t.Fatal(err)
+	}
+	events, err := repo.GetTaskProgress("task-1", 0)
+	if err != nil || len(events) != 1 || events[0].Data != `{"progress":50}` {
+		t.Errorf("expected the progress event back, got %v, %v", events, err)
+	}
+
+	for _, f := range []models.File{
+		{TaskID: "task-1", SessionID: "s", UserID: 2, FileName: "task-1.xlsx", FileType: "xlsx", ObjectKey: "tasks/task-1/task-1.xlsx", Size: 4, Checksum: "c"},
+		{TaskID: "task-1", SessionID: "s", UserID: 2, FileName: "task-1_processed.xlsx", FileType: "processed", ObjectKey: "tasks/task-1/task-1_processed.xlsx", Size: 4, Checksum: "c"},
+		{TaskID: "task-1", SessionID: "s", UserID: 2, FileName: "task-1.pdf", FileType: "pdf", ObjectKey: "tasks/task-1/task-1.pdf", Size: 4, Checksum: "c"},
+	} {
+		if err := repo.InsertFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := repo.GetFile("task-1", "pdf")
+	if err != nil || file.ObjectKey != "tasks/task-1/task-1.pdf" || file.UploadTime.IsZero() {
+		t.Errorf("expected the PDF with its upload time, got %+v, %v", file, err)
+	}
+	if file, err := repo.GetFile("task-1", "processed"); err != nil || file.FileName != "task-1_processed.xlsx" {
+		t.Errorf("expected the processed workbook, got %+v, %v", file, err)
+	}
+
+	// A retried task stores its files again, replacing their records
+	retried := models.File{TaskID: "task-1", SessionID: "s", UserID: 2, FileName: "task-1.pdf", FileType: "pdf", ObjectKey: "tasks/task-1/task-1.pdf", Size: 5, Checksum: "d"}
+	if err := repo.InsertFile(retried); err != nil {
+		t.Fatal(err)
+	}
+	if file, err := repo.GetFile("task-1", "pdf"); err != nil || file.Size != 5 || file.Checksum != "d" {
+		t.Errorf("expected the record of the PDF to be replaced, got %+v, %v", file, err)
+	}
+
+	err = repo.FinishTask(models.Task{ID: "task-1", Status: "completed", Progress: 100, Printed: 3, FinishedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := repo.GetTasksByUser(2, 10)
+	if err != nil || len(tasks) != 1 || tasks[0].Printed != 3 || len(tasks[0].Files) != 3 {
+		t.Errorf("expected the finished task with its files, got %+v, %v", tasks, err)
+	}
+
+	// Files expire unless their task is on hold
+	later := time.Now().Add(time.Hour)
+	expired, err := repo.GetExpiredFiles("pdf", later)
+	if err != nil || len(expired) != 1 {
+		t.Errorf("expected the PDF to have expired, got %v, %v", expired, err)
+	}
+	if err := repo.SetTaskHold("task-1", true); err != nil {
+		t.Fatal(err)
+	}
+	if expired, _ := repo.GetExpiredFiles("pdf", later); len(expired) != 0 {
+		t.Errorf("expected held files to be kept, got %v", expired)
+	}
+	if held, err := repo.GetHeldTasks(); err != nil || len(held) != 1 || !held[0].Hold {
+		t.Errorf("expected the task on hold, got %v, %v", held, err)
+	}
+	if err := repo.SetTaskHold("missing", true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown task, got %v", err)
+	}
+
+	keys, err := repo.DeleteFilesByTask("task-1")
+	if err != nil || len(keys) != 3 {
+		t.Errorf("expected every object key back, got %v, %v", keys, err)
+	}
+}
+
+func TestSQLiteJobs(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+
+	if err := repo.InsertTask(models.Task{ID: "task-1", Status: "queued"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsertJob(models.Job{TaskID: "task-1", SessionID: "s", Options: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if pending, claimed, err := repo.CountJobs(); err != nil || pending != 1 || claimed != 0 {
+		t.Errorf("expected one pending job, got %d, %d, %v", pending, claimed, err)
+	}
+
+	job, err := repo.ClaimJob("worker-1", time.Now().Add(-time.Minute))
+	if err != nil || job.TaskID != "task-1" || job.ClaimedBy != "worker-1" || job.Attempts != 1 {
+		t.Fatalf("expected worker-1 to claim the job, got %+v, %v", job, err)
+	}
+	if _, err := repo.ClaimJob("worker-2", time.Now().Add(-time.Minute)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected nothing left to claim, got %v", err)
+	}
+
+	if status, err := repo.CancelJob("task-1"); err != nil || status != "claimed" {
+		t.Errorf("expected the claimed job to be asked to stop, got %q, %v", status, err)
+	}
+	if cancelled, err := repo.HeartbeatJob("task-1", "worker-1"); err != nil || !cancelled {
+		t.Errorf("expected the heartbeat to report the cancellation, got %v, %v", cancelled, err)
+	}
+	later := time.Now().Add(time.Minute)
+	if interrupted, err := repo.InterruptRunningTasks(later); err != nil || interrupted != 0 {
+		t.Errorf("expected a task with a job to be left to the workers, got %d, %v", interrupted, err)
+	}
+	if err := repo.DeleteJob("task-1", "worker-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a job, the task is interrupted once its heartbeats stop
+	if err := repo.HeartbeatTask("task-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if interrupted, err := repo.InterruptRunningTasks(time.Now().Add(-time.Minute)); err != nil || interrupted != 0 {
+		t.Errorf("expected a task with a recent heartbeat to be left alone, got %d, %v", interrupted, err)
+	}
+	if interrupted, err := repo.InterruptRunningTasks(later); err != nil || interrupted != 1 {
+		t.Errorf("expected the queued task to be interrupted, got %d, %v", interrupted, err)
+	}
+	if task, err := repo.GetTaskByID("task-1"); err != nil || task.Status != "failed" || task.StatusText != "Interrupted" {
+		t.Errorf("expected the task failed as interrupted, got %+v, %v", task, err)
+	}
+}
+
+func TestSQLitePasswordResets(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+
+	jane, err := repo.GetUserByEmail("JANE@here.ca")
+	if err != nil || jane.ID != 2 || !jane.Active {
+		t.Fatalf("expected jane by email whatever its case, got %+v, %v", jane, err)
+	}
+	if _, err := repo.GetUserByEmail("who@here.ca"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown email, got %v", err)
+	}
+
+	now := time.Now()
+	for _, token := range []string{"first", "second"} {
+		if err := repo.InsertPasswordReset(2, token, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.InsertPasswordReset(1, "expired", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if userID, err := repo.GetPasswordReset("first", now); err != nil || userID != 2 {
+		t.Errorf("expected the token to be for user 2, got %d, %v", userID, err)
+	}
+	if _, err := repo.GetPasswordReset("first", now.Add(2*time.Hour)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the token to expire, got %v", err)
+	}
+	if _, err := repo.UsePasswordReset("expired", "hash", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an expired token to be refused, got %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("changed"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := repo.UsePasswordReset("first", string(hash), now); err != nil || userID != 2 {
+		t.Fatalf("expected the token to reset user 2, got %d, %v", userID, err)
+	}
+	if _, _, _, err := repo.Authenticate("jane@here.ca", "changed"); err != nil {
+		t.Errorf("expected the new password to work, got %v", err)
+	}
+	if _, _, _, err := repo.Authenticate("admin@admin.com", "changed"); err == nil {
+		t.Error("expected other users' passwords to be unchanged")
+	}
+
+	// A token works once, and using one spends the user's others
+	for _, token := range []string{"first", "second"} {
+		if _, err := repo.UsePasswordReset(token, string(hash), now); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s: expected a spent token to be refused, got %v", token, err)
+		}
+	}
+}
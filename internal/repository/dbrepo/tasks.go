@@ -0,0 +1,373 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"pawprintpublic/internal/models"
+	"time"
+)
+
+// InsertTask creates the record for a new task
+func (m *postgresDBRepo) InsertTask(t models.Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO tasks (id, user_id, input_file, status, progress, status_text, started_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := m.DB.ExecContext(ctx, query,
+		t.ID,
+		nullUserID(t.UserID),
+		t.InputFile,
+		t.Status,
+		t.Progress,
+		t.StatusText,
+		nullTime(t.StartedAt),
+	)
+	return err
+}
+
+// StartTask marks a queued task as running
+func (m *postgresDBRepo) StartTask(id string, startedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE tasks SET status = 'running', started_at = $1, updated_at = $1 WHERE id = $2`
+	_, err := m.DB.ExecContext(ctx, query, startedAt, id)
+	return err
+}
+
+// AddTaskProgress records a progress update of a running task as its latest
+// status and as a new event, and announces the event to listening replicas
+func (m *postgresDBRepo) AddTaskProgress(id string, progress int, statusText string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE tasks SET progress = $1, status_text = $2, updated_at = $3 WHERE id = $4`
+	_, err = tx.ExecContext(ctx, query, progress, statusText, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO task_progress (task_id, data) VALUES ($1, $2)`, id, string(data))
+	if err != nil {
+		return err
+	}
+
+	// Delivered when the transaction commits
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify('task_progress', $1)`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTaskProgress returns the progress events of a task recorded after the
+// event afterID, oldest first
+func (m *postgresDBRepo) GetTaskProgress(taskID string, afterID int64) ([]models.ProgressEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, task_id, data, created_at FROM task_progress WHERE task_id = $1 AND id > $2 ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, taskID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanProgressEvents(rows)
+}
+
+// DeleteOldProgress removes progress events older than the given duration
+func (m *postgresDBRepo) DeleteOldProgress(olderThan time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM task_progress WHERE created_at < $1`, time.Now().Add(-olderThan))
+	return err
+}
+
+// FinishTask records how a task ended
+func (m *postgresDBRepo) FinishTask(t models.Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE tasks SET status = $1, progress = $2, status_text = $3, error = $4,
+	          printed = $5, skipped = $6, failed = $7, finished_at = $8, updated_at = $9
+	          WHERE id = $10`
+	_, err := m.DB.ExecContext(ctx, query,
+		t.Status,
+		t.Progress,
+		t.StatusText,
+		t.Error,
+		t.Printed,
+		t.Skipped,
+		t.Failed,
+		t.FinishedAt,
+		time.Now(),
+		t.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Wake the SSE handlers following the task so they send the final event
+	_, err = m.DB.ExecContext(ctx, `SELECT pg_notify('task_progress', $1)`, t.ID)
+	return err
+}
+
+// GetTaskByID returns a task record by id
+func (m *postgresDBRepo) GetTaskByID(id string) (models.Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, input_file, status, progress, status_text, error,
+	          printed, skipped, failed, created_at, started_at, finished_at, updated_at, hold
+	          FROM tasks WHERE id = $1`
+
+	return scanTask(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// HeartbeatTask records that the process running a task is still alive
+func (m *postgresDBRepo) HeartbeatTask(id string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE tasks SET heartbeat_at = $1 WHERE id = $2`, now, id)
+	return err
+}
+
+// InterruptRunningTasks marks tasks left queued or running by a process that
+// has sent no heartbeat since staleBefore as failed. Tasks with a job belong
+// to the workers and are left alone.
+func (m *postgresDBRepo) InterruptRunningTasks(staleBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE tasks SET status = 'failed', status_text = 'Interrupted', error = 'interrupted by a server restart',
+	          finished_at = $1, updated_at = $1
+	          WHERE status IN ('queued', 'running') AND COALESCE(heartbeat_at, created_at) < $2
+	          AND NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.task_id = tasks.id)`
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), staleBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetTaskHold places a task on hold, keeping its files past their retention,
+// or releases it
+func (m *postgresDBRepo) SetTaskHold(id string, hold bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE tasks SET hold = $1, updated_at = $2 WHERE id = $3`
+	result, err := m.DB.ExecContext(ctx, query, hold, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// GetHeldTasks returns the tasks on hold, newest first
+func (m *postgresDBRepo) GetHeldTasks() ([]models.Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, input_file, status, progress, status_text, error,
+	          printed, skipped, failed, created_at, started_at, finished_at, updated_at, hold
+	          FROM tasks WHERE hold ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTasks(rows)
+}
+
+// GetTaskFiles lists the files stored for a task, without their contents
+func (m *postgresDBRepo) GetTaskFiles(taskID string) ([]models.File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, task_id, file_name, file_type, object_key, size, checksum, upload_time FROM files WHERE task_id = $1 ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanFiles(rows)
+}
+
+// GetTasksByUser returns a user's most recent tasks, newest first, with the
+// files still stored for each
+func (m *postgresDBRepo) GetTasksByUser(userID, limit int) ([]models.Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, input_file, status, progress, status_text, error,
+	          printed, skipped, failed, created_at, started_at, finished_at, updated_at, hold
+	          FROM tasks WHERE user_id = $1 ORDER BY created_at DESC, id LIMIT $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		return tasks, err
+	}
+
+	// Only the files of the tasks above, not of every task the user ran
+	query = `SELECT f.id, f.task_id, f.file_name, f.file_type, f.object_key, f.size, f.checksum, f.upload_time
+	         FROM files f
+	         WHERE f.task_id IN (SELECT id FROM tasks WHERE user_id = $1 ORDER BY created_at DESC, id LIMIT $2)
+	         ORDER BY f.id`
+
+	fileRows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return tasks, err
+	}
+	defer fileRows.Close()
+
+	files, err := scanFiles(fileRows)
+	if err != nil {
+		return tasks, err
+	}
+
+	return attachFiles(tasks, files), nil
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTask reads a task record selected in the column order used by GetTaskByID
+func scanTask(row rowScanner) (models.Task, error) {
+	var t models.Task
+	var userID sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(
+		&t.ID,
+		&userID,
+		&t.InputFile,
+		&t.Status,
+		&t.Progress,
+		&t.StatusText,
+		&t.Error,
+		&t.Printed,
+		&t.Skipped,
+		&t.Failed,
+		&t.CreatedAt,
+		&startedAt,
+		&finishedAt,
+		&t.UpdatedAt,
+		&t.Hold,
+	)
+	if err != nil {
+		return t, err
+	}
+
+	t.UserID = int(userID.Int64)
+	t.StartedAt = startedAt.Time
+	t.FinishedAt = finishedAt.Time
+	return t, nil
+}
+
+// scanTasks reads task records selected in the column order used by GetTaskByID
+func scanTasks(rows *sql.Rows) ([]models.Task, error) {
+	var tasks []models.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// attachFiles adds each file to the task it belongs to
+func attachFiles(tasks []models.Task, files []models.File) []models.Task {
+	index := make(map[string]int, len(tasks))
+	for i, t := range tasks {
+		index[t.ID] = i
+	}
+	for _, f := range files {
+		if i, ok := index[f.TaskID]; ok {
+			tasks[i].Files = append(tasks[i].Files, f)
+		}
+	}
+	return tasks
+}
+
+// scanFile reads a file description selected in the column order used by GetTaskFiles
+func scanFile(row rowScanner) (models.File, error) {
+	var f models.File
+	err := row.Scan(&f.ID, &f.TaskID, &f.FileName, &f.FileType, &f.ObjectKey, &f.Size, &f.Checksum, &f.UploadTime)
+	return f, err
+}
+
+// scanFiles reads file descriptions selected in the column order used by GetTaskFiles
+func scanFiles(rows *sql.Rows) ([]models.File, error) {
+	var files []models.File
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// scanProgressEvents reads events selected in the column order used by GetTaskProgress
+func scanProgressEvents(rows *sql.Rows) ([]models.ProgressEvent, error) {
+	var events []models.ProgressEvent
+	for rows.Next() {
+		var e models.ProgressEvent
+		err := rows.Scan(&e.ID, &e.TaskID, &e.Data, &e.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// requireRow returns sql.ErrNoRows when a statement changed nothing
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// nullUserID stores tasks started without a signed-in user as NULL
+func nullUserID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
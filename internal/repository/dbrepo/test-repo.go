@@ -1,13 +1,24 @@
 package dbrepo
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"errors"
+	"fmt"
 	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/repository"
+	"strings"
 	"time"
 )
 
+// testUsers are the users the test repository knows, by id
+var testUsers = map[int]models.User{
+	1: {ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca", AccessLevel: 3, Active: true},
+	2: {ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@here.ca", AccessLevel: 1, Active: true},
+}
+
 func (m *testDBRepo) AllUsers() ([]models.User, error) {
-	return []models.User{}, nil
+	return []models.User{testUsers[1], testUsers[2]}, nil
 }
 
 // // InsertReservation inserts a reservation into the database
@@ -103,22 +114,77 @@ func (m *testDBRepo) AllUsers() ([]models.User, error) {
 // }
 
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
-	var u models.User
-
+	u, ok := testUsers[id]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
 	return u, nil
 }
 
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	for _, u := range testUsers {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
 func (m *testDBRepo) UpdateUser(u models.User) error {
-	return nil
+	_, err := m.GetUserByID(u.ID)
+	return err
+}
+
+func (m *testDBRepo) InsertUser(u models.User, hashedPassword string) (int, error) {
+	if u.Email == "taken@here.ca" {
+		return 0, errors.New("duplicate email")
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) SetUserActive(id int, active bool) error {
+	_, err := m.GetUserByID(id)
+	return err
+}
+
+func (m *testDBRepo) UpdatePassword(id int, hashedPassword string) error {
+	_, err := m.GetUserByID(id)
+	return err
+}
+
+func (m *testDBRepo) DeleteUser(id int) error {
+	_, err := m.GetUserByID(id)
+	return err
 }
 
 func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, int, error) {
 	if email == "me@here.ca" {
 		return 1, "", 3, nil
 	}
+	if email == "gone@here.ca" {
+		return 0, "", 0, repository.ErrInactiveUser
+	}
 	return 0, "", 0, errors.New("some error")
 }
 
+// testResetToken is the one password reset token the test repository accepts
+const testResetToken = "valid-token"
+
+func (m *testDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) GetPasswordReset(tokenHash string, now time.Time) (int, error) {
+	if tokenHash != fmt.Sprintf("%x", sha256.Sum256([]byte(testResetToken))) {
+		return 0, sql.ErrNoRows
+	}
+	return 2, nil
+}
+
+func (m *testDBRepo) UsePasswordReset(tokenHash, hashedPassword string, now time.Time) (int, error) {
+	return m.GetPasswordReset(tokenHash, now)
+}
+
 // // AllReservations returns a slice of all reservations
 // func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
 // 	var reservations []models.Reservation
@@ -196,18 +262,160 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, int,
 // 	return nil
 // }
 
-func (m *testDBRepo) InsertFile(taskID, sessionID, fileName, fileType string, fileData []byte) error {
+func (m *testDBRepo) InsertFile(f models.File) error {
+	return nil
+}
+
+func (m *testDBRepo) GetFile(taskID, fileType string) (models.File, error) {
+	return m.GetFileByName(taskID, fileType, taskID+"."+fileType)
+}
+
+func (m *testDBRepo) GetFileByName(taskID, fileType, fileName string) (models.File, error) {
+	files, _ := m.GetTaskFiles(taskID)
+	for _, f := range files {
+		if f.FileType == fileType && f.FileName == fileName {
+			return f, nil
+		}
+	}
+	return models.File{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) DeleteFilesByTask(taskID string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) GetAllFiles() ([]models.File, error) {
+	return m.GetTaskFiles("finished")
+}
+
+func (m *testDBRepo) GetExpiredFiles(fileType string, uploadedBefore time.Time) ([]models.File, error) {
+	var expired []models.File
+	files, _ := m.GetTaskFiles("finished")
+	for _, f := range files {
+		if f.FileType == fileType && !f.UploadTime.After(uploadedBefore) {
+			expired = append(expired, f)
+		}
+	}
+	return expired, nil
+}
+
+func (m *testDBRepo) DeleteFile(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) InsertTask(t models.Task) error {
+	return nil
+}
+
+func (m *testDBRepo) StartTask(id string, startedAt time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) AddTaskProgress(id string, progress int, statusText string, data []byte) error {
+	return nil
+}
+
+func (m *testDBRepo) GetTaskProgress(taskID string, afterID int64) ([]models.ProgressEvent, error) {
+	var events []models.ProgressEvent
+	if taskID != "finished" {
+		return events, nil
+	}
+	for _, e := range []models.ProgressEvent{
+		{ID: 1, TaskID: taskID, Data: `{"status":"Starting","progress":0}`},
+		{ID: 2, TaskID: taskID, Data: `{"status":"PDF generation completed","progress":100,"summary":{"printed":1,"skipped":0,"failed":0}}`},
+	} {
+		if e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (m *testDBRepo) DeleteOldProgress(olderThan time.Duration) error {
+	return nil
+}
+
+func (m *testDBRepo) FinishTask(t models.Task) error {
+	return nil
+}
+
+func (m *testDBRepo) GetTaskByID(id string) (models.Task, error) {
+	if id == "finished" || id == "expired" {
+		return models.Task{ID: id, UserID: 1, InputFile: "spring.xlsx", Status: "completed", Progress: 100, StatusText: "PDF generation completed", Printed: 1}, nil
+	}
+	return models.Task{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) HeartbeatTask(id string, now time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) InterruptRunningTasks(staleBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+// testChecksum is the SHA-256 of "test", the contents of the test files
+const testChecksum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func (m *testDBRepo) GetTaskFiles(taskID string) ([]models.File, error) {
+	if taskID == "finished" {
+		return []models.File{
+			{TaskID: "finished", FileName: "finished.xlsx", FileType: "xlsx", ObjectKey: "tasks/finished/finished.xlsx", Size: 4, Checksum: testChecksum},
+			{TaskID: "finished", FileName: "finished.pdf", FileType: "pdf", ObjectKey: "tasks/finished/finished.pdf", Size: 4, Checksum: testChecksum},
+		}, nil
+	}
+	return []models.File{}, nil
+}
+
+func (m *testDBRepo) GetTasksByUser(userID, limit int) ([]models.Task, error) {
+	return []models.Task{
+		{
+			ID:        "finished",
+			InputFile: "spring.xlsx",
+			Status:    "completed",
+			Printed:   1,
+			Files: []models.File{
+				{TaskID: "finished", FileName: "finished.xlsx", FileType: "xlsx"},
+				{TaskID: "finished", FileName: "finished.pdf", FileType: "pdf"},
+			},
+		},
+	}, nil
+}
+
+func (m *testDBRepo) SetTaskHold(id string, hold bool) error {
+	if _, err := m.GetTaskByID(id); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (m *testDBRepo) GetFile(taskID, fileType string) ([]byte, error) {
-	return []byte{}, nil
+func (m *testDBRepo) GetHeldTasks() ([]models.Task, error) {
+	return []models.Task{}, nil
 }
 
-func (m *testDBRepo) DeleteFilesByTask(taskID string) error {
+func (m *testDBRepo) InsertJob(j models.Job) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteOldFiles(olderThan time.Duration) error {
+func (m *testDBRepo) ClaimJob(workerID string, staleBefore time.Time) (models.Job, error) {
+	return models.Job{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) HeartbeatJob(taskID, workerID string) (bool, error) {
+	return false, nil
+}
+
+func (m *testDBRepo) CancelJob(taskID string) (string, error) {
+	if taskID == "queued" {
+		return "pending", nil
+	}
+	return "", sql.ErrNoRows
+}
+
+func (m *testDBRepo) DeleteJob(taskID, workerID string) error {
 	return nil
 }
+
+func (m *testDBRepo) CountJobs() (int, int, error) {
+	return 0, 0, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"pawprintpublic/internal/models"
+	"pawprintpublic/internal/repository"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -13,7 +14,7 @@ func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, access_level, created_at, updated_at from users`
+	query := `select id, first_name, last_name, email, access_level, active, created_at, updated_at from users order by id`
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -30,6 +31,7 @@ func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
 			&user.LastName,
 			&user.Email,
 			&user.AccessLevel,
+			&user.Active,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -51,7 +53,7 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+	query := `select id, first_name, last_name, email, password, access_level, active, created_at, updated_at
 			from users where id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
@@ -64,6 +66,7 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&u.Active,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -75,31 +78,118 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	return u, nil
 }
 
-// UpdateUser updates a user in the database
+// GetUserByEmail returns the user with an email, ignoring case
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, access_level, active, created_at, updated_at
+			from users where lower(email) = lower($1)`
+
+	var u models.User
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.AccessLevel,
+		&u.Active,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+
+	return u, err
+}
+
+// UpdateUser updates a user's name, email and access level, returning
+// sql.ErrNoRows when there is no user with its id
 func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	query := `
 		update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5
+		where id = $6
 `
 
-	_, err := m.DB.ExecContext(ctx, query,
+	result, err := m.DB.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.AccessLevel,
 		time.Now(),
+		u.ID,
 	)
+	if err != nil {
+		return err
+	}
+
+	return requireRow(result)
+}
 
+// InsertUser adds an active user with an already hashed password and
+// returns its id
+func (m *postgresDBRepo) InsertUser(u models.User, hashedPassword string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		insert into users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, true, $6, $6) returning id
+`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		hashedPassword,
+		u.AccessLevel,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+// SetUserActive deactivates a user, so they can no longer sign in, or
+// reactivates them
+func (m *postgresDBRepo) SetUserActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update users set active = $1, updated_at = $2 where id = $3`, active, time.Now(), id)
 	if err != nil {
 		return err
 	}
+	return requireRow(result)
+}
 
-	return nil
+// UpdatePassword replaces a user's password with an already hashed one
+func (m *postgresDBRepo) UpdatePassword(id int, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
 }
 
-// Authenticate authenticates a user
+// DeleteUser removes a user. Their tasks and files are kept without an owner.
+func (m *postgresDBRepo) DeleteUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from users where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Authenticate authenticates a user. Deactivated users get ErrInactiveUser
+// once their password is checked.
 func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -107,9 +197,10 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	var id int
 	var hashedPassword string
 	var accessLevel int
+	var active bool
 
-	row := m.DB.QueryRowContext(ctx, "select id, password, access_level from users where email = $1", email)
-	err := row.Scan(&id, &hashedPassword, &accessLevel)
+	row := m.DB.QueryRowContext(ctx, "select id, password, access_level, active from users where lower(email) = lower($1)", email)
+	err := row.Scan(&id, &hashedPassword, &accessLevel, &active)
 	if err != nil {
 		return id, "", accessLevel, err
 	}
@@ -120,6 +211,68 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	} else if err != nil {
 		return 0, "", 0, err
 	}
+	if !active {
+		return 0, "", 0, repository.ErrInactiveUser
+	}
 
 	return id, hashedPassword, accessLevel, nil
 }
+
+// InsertPasswordReset records the hash of a token emailed to a user to reset
+// their password
+func (m *postgresDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `insert into password_resets (user_id, token_hash, expires_at) values ($1, $2, $3)`
+	_, err := m.DB.ExecContext(ctx, query, userID, tokenHash, expiresAt.UTC())
+	return err
+}
+
+// GetPasswordReset returns the id of the user a token was sent to, or
+// sql.ErrNoRows when the token is unknown, used or expired at now
+func (m *postgresDBRepo) GetPasswordReset(tokenHash string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select user_id from password_resets where token_hash = $1 and used_at is null and expires_at > $2`
+
+	var userID int
+	err := m.DB.QueryRowContext(ctx, query, tokenHash, now.UTC()).Scan(&userID)
+	return userID, err
+}
+
+// UsePasswordReset spends a token and sets the password of the user it was
+// sent to, returning their id. Their other outstanding tokens are spent too.
+// It returns sql.ErrNoRows when the token is unknown, used or expired at now.
+func (m *postgresDBRepo) UsePasswordReset(tokenHash, hashedPassword string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Spending the token in the update lets only one request with it through
+	var userID int
+	query := `update password_resets set used_at = $1
+			where token_hash = $2 and used_at is null and expires_at > $1 returning user_id`
+	err = tx.QueryRowContext(ctx, query, now.UTC(), tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update password_resets set used_at = $1 where user_id = $2 and used_at is null`, now.UTC(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`, hashedPassword, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
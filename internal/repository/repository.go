@@ -1,10 +1,15 @@
 package repository
 
 import (
+	"errors"
 	"pawprintpublic/internal/models"
 	"time"
 )
 
+// ErrInactiveUser is returned by Authenticate for a deactivated user whose
+// password was right
+var ErrInactiveUser = errors.New("the user is deactivated")
+
 type DatabaseRepo interface {
 	AllUsers() ([]models.User, error)
 
@@ -15,13 +20,46 @@ type DatabaseRepo interface {
 	// GetRoomByID(id int) (models.Room, error)
 
 	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
 	UpdateUser(u models.User) error
+	InsertUser(u models.User, hashedPassword string) (int, error)
+	SetUserActive(id int, active bool) error
+	UpdatePassword(id int, hashedPassword string) error
+	DeleteUser(id int) error
 	Authenticate(email, testPassword string) (int, string, int, error)
 
-	InsertFile(taskID, sessionID, fileName, fileType string, fileData []byte) error
-	GetFile(taskID, fileType string) ([]byte, error)
-	DeleteFilesByTask(taskID string) error
-	DeleteOldFiles(olderThan time.Duration) error
+	InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error
+	GetPasswordReset(tokenHash string, now time.Time) (int, error)
+	UsePasswordReset(tokenHash, hashedPassword string, now time.Time) (int, error)
+
+	InsertFile(f models.File) error
+	GetFile(taskID, fileType string) (models.File, error)
+	GetFileByName(taskID, fileType, fileName string) (models.File, error)
+	DeleteFilesByTask(taskID string) ([]string, error)
+	GetAllFiles() ([]models.File, error)
+	GetExpiredFiles(fileType string, uploadedBefore time.Time) ([]models.File, error)
+	DeleteFile(id int) error
+
+	InsertTask(t models.Task) error
+	StartTask(id string, startedAt time.Time) error
+	AddTaskProgress(id string, progress int, statusText string, data []byte) error
+	GetTaskProgress(taskID string, afterID int64) ([]models.ProgressEvent, error)
+	DeleteOldProgress(olderThan time.Duration) error
+	FinishTask(t models.Task) error
+	GetTaskByID(id string) (models.Task, error)
+	HeartbeatTask(id string, now time.Time) error
+	InterruptRunningTasks(staleBefore time.Time) (int64, error)
+	GetTaskFiles(taskID string) ([]models.File, error)
+	GetTasksByUser(userID, limit int) ([]models.Task, error)
+	SetTaskHold(id string, hold bool) error
+	GetHeldTasks() ([]models.Task, error)
+
+	InsertJob(j models.Job) error
+	ClaimJob(workerID string, staleBefore time.Time) (models.Job, error)
+	HeartbeatJob(taskID, workerID string) (bool, error)
+	CancelJob(taskID string) (string, error)
+	DeleteJob(taskID, workerID string) error
+	CountJobs() (int, int, error)
 
 	// AllReservations() ([]models.Reservation, error)
 	// AllNewReservations() ([]models.Reservation, error)
@@ -0,0 +1,195 @@
+// Package retention decides how long stored files are kept before the
+// cleanup job removes them
+package retention
+
+import (
+	"fmt"
+	"os"
+	"pawprintpublic/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileTypes are the types of stored files, in the order rules are listed
+var FileTypes = []string{"csv", "xlsx", "processed", "pdf", "zip", "exceptions"}
+
+// Day is the unit retention rules are usually written in
+const Day = 24 * time.Hour
+
+// Policy is how long files of each type are kept. A type without a rule, or
+// with a rule of zero, is kept indefinitely. Files of a task on hold are
+// kept whatever the rules say.
+type Policy struct {
+	Rules    map[string]time.Duration // by file type
+	Interval time.Duration            // time between cleanup sweeps
+}
+
+// Rule is how long files of one type are kept
+type Rule struct {
+	FileType string
+	Keep     time.Duration // zero keeps files indefinitely
+}
+
+// Default keeps final PDFs, bundles, exceptions and processed workbooks 30
+// days and removes uploaded workbooks after a day, sweeping hourly
+func Default() Policy {
+	return Policy{
+		Rules: map[string]time.Duration{
+			"csv":        Day,
+			"xlsx":       Day,
+			"processed":  30 * Day,
+			"pdf":        30 * Day,
+			"zip":        30 * Day,
+			"exceptions": 30 * Day,
+		},
+		Interval: time.Hour,
+	}
+}
+
+// FromEnv reads the policy from FILE_RETENTION and RETENTION_SWEEP_INTERVAL.
+// FILE_RETENTION overrides the default rules for the types it lists, e.g.
+// "pdf=90d,xlsx=12h,zip=keep"; durations take Go's units plus d for days,
+// and keep or 0 keeps a type indefinitely. The sweep interval defaults to 1h.
+func FromEnv() (Policy, error) {
+	policy := Default()
+
+	if value := os.Getenv("FILE_RETENTION"); value != "" {
+		rules, err := ParseRules(value)
+		if err != nil {
+			return policy, fmt.Errorf("FILE_RETENTION: %v", err)
+		}
+		for fileType, keep := range rules {
+			policy.Rules[fileType] = keep
+		}
+	}
+
+	if value := os.Getenv("RETENTION_SWEEP_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return policy, fmt.Errorf("RETENTION_SWEEP_INTERVAL must be a positive duration such as 1h, got %q", value)
+		}
+		policy.Interval = interval
+	}
+
+	return policy, nil
+}
+
+// ParseRules reads comma separated type=duration rules
+func ParseRules(value string) (map[string]time.Duration, error) {
+	rules := make(map[string]time.Duration)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fileType, keep, found := strings.Cut(entry, "=")
+		fileType = strings.TrimSpace(fileType)
+		if !found || fileType == "" {
+			return nil, fmt.Errorf("rule %q must look like type=duration", entry)
+		}
+		if !validType(fileType) {
+			return nil, fmt.Errorf("unknown file type %q", fileType)
+		}
+		duration, err := parseKeep(strings.TrimSpace(keep))
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", entry, err)
+		}
+		rules[fileType] = duration
+	}
+	return rules, nil
+}
+
+// validType reports whether files of a type are stored at all
+func validType(fileType string) bool {
+	for _, t := range FileTypes {
+		if t == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKeep reads a duration that may be given in days, or keep for no limit
+func parseKeep(value string) (time.Duration, error) {
+	if value == "keep" || value == "0" {
+		return 0, nil
+	}
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a number of days", value)
+		}
+		return time.Duration(n) * Day, nil
+	}
+	keep, err := time.ParseDuration(value)
+	if err != nil || keep < 0 {
+		return 0, fmt.Errorf("%q is not a duration such as 30d or 12h", value)
+	}
+	return keep, nil
+}
+
+// Keep returns how long files of a type are kept, and false when they are
+// kept indefinitely
+func (p Policy) Keep(fileType string) (time.Duration, bool) {
+	keep := p.Rules[fileType]
+	return keep, keep > 0
+}
+
+// Expires returns when a file is due to be removed, and false when it is
+// kept indefinitely. Holds are not considered.
+func (p Policy) Expires(f models.File) (time.Time, bool) {
+	keep, ok := p.Keep(f.FileType)
+	if !ok {
+		return time.Time{}, false
+	}
+	return f.UploadTime.Add(keep), true
+}
+
+// List returns every rule, including the types kept indefinitely, ordered by
+// file type
+func (p Policy) List() []Rule {
+	var rules []Rule
+	for _, fileType := range FileTypes {
+		rules = append(rules, Rule{FileType: fileType, Keep: p.Rules[fileType]})
+	}
+	return rules
+}
+
+// Purge is a file the cleanup job will remove
+type Purge struct {
+	File    models.File
+	Expires time.Time
+}
+
+// Upcoming orders the files by when they expire, leaving out the ones kept
+// indefinitely or not due before the given time
+func (p Policy) Upcoming(files []models.File, before time.Time) []Purge {
+	var purges []Purge
+	for _, f := range files {
+		expires, ok := p.Expires(f)
+		if ok && !expires.After(before) {
+			purges = append(purges, Purge{File: f, Expires: expires})
+		}
+	}
+	sort.SliceStable(purges, func(i, j int) bool {
+		return purges[i].Expires.Before(purges[j].Expires)
+	})
+	return purges
+}
+
+// Period describes how long the rule keeps files for people, e.g. "30 days"
+func (r Rule) Period() string {
+	keep := r.Keep
+	switch {
+	case keep <= 0:
+		return "Indefinitely"
+	case keep == Day:
+		return "1 day"
+	case keep%Day == 0:
+		return fmt.Sprintf("%d days", keep/Day)
+	default:
+		return keep.String()
+	}
+}
@@ -0,0 +1,106 @@
+package retention
+
+import (
+	"pawprintpublic/internal/models"
+	"testing"
+	"time"
+)
+
+var parseRulesTests = []struct {
+	name     string
+	value    string
+	expected map[string]time.Duration
+	valid    bool
+}{
+	{"days and hours", "pdf=30d, xlsx=12h", map[string]time.Duration{"pdf": 30 * Day, "xlsx": 12 * time.Hour}, true},
+	{"keep indefinitely", "zip=keep,exceptions=0", map[string]time.Duration{"zip": 0, "exceptions": 0}, true},
+	{"empty entries", "pdf=1d,,", map[string]time.Duration{"pdf": Day}, true},
+	{"unknown type", "docx=1d", nil, false},
+	{"missing duration", "pdf", nil, false},
+	{"bad days", "pdf=xd", nil, false},
+	{"negative", "pdf=-1h", nil, false},
+}
+
+func TestParseRules(t *testing.T) {
+	for _, e := range parseRulesTests {
+		rules, err := ParseRules(e.value)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid to be %v, got error %v", e.name, e.valid, err)
+			continue
+		}
+		if len(rules) != len(e.expected) {
+			t.Errorf("%s: expected %d rules, got %v", e.name, len(e.expected), rules)
+		}
+		for fileType, keep := range e.expected {
+			if got, ok := rules[fileType]; !ok || got != keep {
+				t.Errorf("%s: expected %s to be kept %v, got %v", e.name, fileType, keep, got)
+			}
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("FILE_RETENTION", "pdf=keep")
+	t.Setenv("RETENTION_SWEEP_INTERVAL", "15m")
+
+	policy, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := policy.Keep("pdf"); ok {
+		t.Error("expected PDFs to be kept indefinitely")
+	}
+	if keep, _ := policy.Keep("xlsx"); keep != Day {
+		t.Errorf("expected workbooks to keep the default of a day, got %v", keep)
+	}
+	if keep, _ := policy.Keep("processed"); keep != 30*Day {
+		t.Errorf("expected processed workbooks to keep the default of 30 days, got %v", keep)
+	}
+	if policy.Interval != 15*time.Minute {
+		t.Errorf("expected a 15m sweep interval, got %v", policy.Interval)
+	}
+
+	t.Setenv("RETENTION_SWEEP_INTERVAL", "0s")
+	if _, err := FromEnv(); err == nil {
+		t.Error("expected a zero sweep interval to be rejected")
+	}
+}
+
+func TestUpcoming(t *testing.T) {
+	policy := Default()
+	policy.Rules["zip"] = 0
+	uploaded := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	files := []models.File{
+		{ID: 1, FileType: "pdf", UploadTime: uploaded},
+		{ID: 2, FileType: "xlsx", UploadTime: uploaded},
+		{ID: 3, FileType: "zip", UploadTime: uploaded},
+		{ID: 4, FileType: "csv", UploadTime: uploaded.Add(10 * Day)},
+	}
+
+	purges := policy.Upcoming(files, uploaded.Add(7*Day))
+	if len(purges) != 1 || purges[0].File.ID != 2 {
+		t.Fatalf("expected only the workbook within a week, got %v", purges)
+	}
+	if !purges[0].Expires.Equal(uploaded.Add(Day)) {
+		t.Errorf("expected the workbook to expire a day after upload, got %v", purges[0].Expires)
+	}
+
+	purges = policy.Upcoming(files, uploaded.Add(60*Day))
+	if len(purges) != 3 || purges[0].File.ID != 2 || purges[1].File.ID != 4 || purges[2].File.ID != 1 {
+		t.Errorf("expected the workbook, csv and PDF in expiry order, got %v", purges)
+	}
+}
+
+func TestRulePeriod(t *testing.T) {
+	for keep, expected := range map[time.Duration]string{
+		0:              "Indefinitely",
+		Day:            "1 day",
+		30 * Day:       "30 days",
+		12 * time.Hour: "12h0m0s",
+	} {
+		if got := (Rule{Keep: keep}).Period(); got != expected {
+			t.Errorf("expected %v to read %q, got %q", keep, expected, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package roles names what each access level may do. A user's role is the
+// access_level stored with them and in their session, and each role can do
+// everything the roles below it can.
+package roles
+
+import "fmt"
+
+// Role is a user's access level
+type Role int
+
+const (
+	// Viewer can sign in, follow their jobs and download their files
+	Viewer Role = 1
+	// Operator can also upload workbooks and run and cancel jobs
+	Operator Role = 2
+	// Admin can also see the admin dashboard with the queue and everyone's
+	// jobs and files, hold tasks' files past their retention and manage users
+	Admin Role = 3
+)
+
+// All is every role, lowest first
+var All = []Role{Viewer, Operator, Admin}
+
+// Permission is something a role may do
+type Permission string
+
+const (
+	ViewJobs        Permission = "jobs:view"
+	RunJobs         Permission = "jobs:run"
+	ViewAllJobs     Permission = "jobs:all"
+	ViewAdmin       Permission = "admin:view"
+	ManageRetention Permission = "retention:manage"
+	ManageUsers     Permission = "users:manage"
+)
+
+// minimum is the lowest role granted each permission
+var minimum = map[Permission]Role{
+	ViewJobs:        Viewer,
+	RunJobs:         Operator,
+	ViewAllJobs:     Admin,
+	ViewAdmin:       Admin,
+	ManageRetention: Admin,
+	ManageUsers:     Admin,
+}
+
+// Valid reports whether r is one of the named roles
+func (r Role) Valid() bool {
+	return r >= Viewer && r <= Admin
+}
+
+// Includes reports whether r can do everything other can
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && r >= other
+}
+
+// Can reports whether r has a permission. Unknown permissions are refused.
+func (r Role) Can(p Permission) bool {
+	required, ok := minimum[p]
+	return ok && r.Includes(required)
+}
+
+// String is the role's name
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "Viewer"
+	case Operator:
+		return "Operator"
+	case Admin:
+		return "Admin"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
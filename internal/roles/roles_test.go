@@ -0,0 +1,46 @@
+package roles
+
+import "testing"
+
+var canTests = []struct {
+	role       Role
+	permission Permission
+	expected   bool
+}{
+	{Viewer, ViewJobs, true},
+	{Viewer, RunJobs, false},
+	{Viewer, ViewAdmin, false},
+	{Operator, RunJobs, true},
+	{Operator, ViewAdmin, false},
+	{Operator, ManageRetention, false},
+	{Admin, ManageRetention, true},
+	{Operator, ViewAllJobs, false},
+	{Operator, ManageUsers, false},
+	{Admin, ManageUsers, true},
+	{Admin, ViewJobs, true},
+	{Admin, Permission("unknown"), false},
+	{Role(0), ViewJobs, false},
+	{Role(4), ManageUsers, false},
+}
+
+func TestCan(t *testing.T) {
+	for _, e := range canTests {
+		if got := e.role.Can(e.permission); got != e.expected {
+			t.Errorf("%v can %s: expected %v, got %v", e.role, e.permission, e.expected, got)
+		}
+	}
+}
+
+func TestEveryPermissionIsGranted(t *testing.T) {
+	for p := range minimum {
+		if !Admin.Can(p) {
+			t.Errorf("expected admins to have %s", p)
+		}
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	if !Admin.Includes(Operator) || Operator.Includes(Admin) || Role(0).Includes(Role(0)) {
+		t.Error("expected higher roles to include lower ones and unknown roles nothing")
+	}
+}
@@ -0,0 +1,531 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An encrypted object starts with a header naming the key-encryption key
+// and holding the object's own data key, wrapped with it:
+//
+//	magic | key id length (1) | key id | wrap nonce (12) | wrapped data key (48) | chunk size (4)
+//
+// The contents follow as chunks of up to chunk size bytes, each sealed with
+// AES-GCM under the data key and with the object key as associated data, so
+// chunks cannot be moved to another object. A chunk's nonce is its index and
+// whether it is the last, so chunks cannot be reordered or the object cut
+// short, and any chunk can be decrypted on its own for range requests.
+//
+// The first version of the header sealed chunks without the object key.
+// Such objects are still read, and Rekey encrypts them again.
+const (
+	encryptedMagic   = "PPENC\x02"
+	unboundMagic     = "PPENC\x01"
+	dataKeySize      = 32
+	defaultChunkSize = 64 << 10
+)
+
+// ErrUnknownKey is returned for objects encrypted with a key not in the keyring
+var ErrUnknownKey = errors.New("object is encrypted with a key that is not configured")
+
+// Keyring holds the keys that wrap data keys. New objects use the primary
+// key; the others are kept to read objects written before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// ParseKeyring reads keys written one per line, or separated by commas, as
+// id:base64-key. The first key is the primary. Keys are 32 bytes (AES-256).
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("encryption keys must be written as id:base64-key")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes, base64 encoded", id)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("encryption key %s is listed twice", id)
+		}
+
+		if k.primary == "" {
+			k.primary = id
+		}
+		k.keys[id] = key
+	}
+	if k.primary == "" {
+		return nil, errors.New("no encryption keys given")
+	}
+	return k, nil
+}
+
+// KeyringFromEnv reads the keyring from STORAGE_ENCRYPTION_KEYS, or the file
+// named by STORAGE_ENCRYPTION_KEYS_FILE. It returns nil when neither is set.
+func KeyringFromEnv() (*Keyring, error) {
+	text, err := secretFromEnv("STORAGE_ENCRYPTION_KEYS")
+	if err != nil || text == "" {
+		return nil, err
+	}
+	return ParseKeyring(text)
+}
+
+// Primary returns the id of the key new objects are encrypted with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// aead returns AES-GCM under the key-encryption key id
+func (k *Keyring) aead(id string) (cipher.AEAD, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return newGCM(key)
+}
+
+// newGCM returns AES-GCM under key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypted encrypts objects before they reach another Storage. Objects
+// stored before encryption was turned on are read as they are.
+type Encrypted struct {
+	inner     Storage
+	keys      *Keyring
+	chunkSize int
+}
+
+// NewEncrypted encrypts the objects of inner with keys from the keyring
+func NewEncrypted(inner Storage, keys *Keyring) *Encrypted {
+	return &Encrypted{inner: inner, keys: keys, chunkSize: defaultChunkSize}
+}
+
+// chunkCount returns how many chunks hold size bytes; even an empty object
+// has one, so its end is authenticated
+func chunkCount(size, chunkSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
+
+// chunkNonce is the nonce of chunk index of an object
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Put encrypts the object under a new data key as it is stored
+func (s *Encrypted) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	header, err := s.header(dataKey, s.chunkSize)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := int64(s.chunkSize)
+	chunks := chunkCount(size, chunkSize)
+	encrypted := &encryptingReader{
+		r:         r,
+		aead:      aead,
+		ad:        []byte(key),
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		remaining: size,
+		out:       header,
+	}
+	return s.inner.Put(ctx, key, encrypted, int64(len(header))+size+chunks*int64(aead.Overhead()))
+}
+
+// header writes the header of an object with the given data key and chunk
+// size, wrapping the data key with the primary key
+func (s *Encrypted) header(dataKey []byte, chunkSize int) ([]byte, error) {
+	id := s.keys.Primary()
+	kek, err := s.keys.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(encryptedMagic)
+	header.WriteByte(byte(len(id)))
+	header.WriteString(id)
+	header.Write(nonce)
+	header.Write(kek.Seal(nil, nonce, dataKey, []byte(encryptedMagic+id)))
+	binary.Write(&header, binary.BigEndian, uint32(chunkSize))
+	return header.Bytes(), nil
+}
+
+// encryptingReader reads the header and then the sealed chunks of an object
+type encryptingReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	ad        []byte // the object key
+	chunkSize int64
+	chunks    int64
+	index     int64
+	remaining int64  // plaintext bytes still to read from r
+	out       []byte // sealed bytes not read yet
+	plain     []byte
+	sealed    []byte
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.index == e.chunks {
+			return 0, io.EOF
+		}
+
+		n := min(e.chunkSize, e.remaining)
+		if e.plain == nil {
+			e.plain = make([]byte, e.chunkSize)
+		}
+		if _, err := io.ReadFull(e.r, e.plain[:n]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		e.remaining -= n
+
+		last := e.index == e.chunks-1
+		e.sealed = e.aead.Seal(e.sealed[:0], chunkNonce(e.index, last), e.plain[:n], e.ad)
+		e.out = e.sealed
+		e.index++
+	}
+
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// Open reads and decrypts an object as it is read
+func (s *Encrypted) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	object, err := s.inner.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := s.decrypt(object, key)
+	if err != nil {
+		object.Close()
+		return nil, fmt.Errorf("cannot decrypt %s: %w", key, err)
+	}
+	return decrypted, nil
+}
+
+// decrypt reads the header of the object stored under key. Objects without
+// one were stored unencrypted and are returned as they are.
+func (s *Encrypted) decrypt(object io.ReadSeekCloser, key string) (io.ReadSeekCloser, error) {
+	total, err := object.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	magic, id, rest, err := readHeaderStart(object)
+	if errors.Is(err, errNotEncrypted) {
+		_, err = object.Seek(0, io.SeekStart)
+		return object, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataKey, chunkSize, wrappedLen, err := s.readDataKey(object, magic, id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	var ad []byte
+	if magic == encryptedMagic {
+		ad = []byte(key)
+	}
+
+	headerLen := rest + wrappedLen
+	sealedChunk := chunkSize + int64(aead.Overhead())
+	body := total - headerLen
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	if chunks == 0 || body-(chunks-1)*sealedChunk < int64(aead.Overhead()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	d := &decryptingObject{
+		inner:     object,
+		aead:      aead,
+		ad:        ad,
+		headerLen: headerLen,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		size:      body - chunks*int64(aead.Overhead()),
+		innerPos:  headerLen,
+		loaded:    -1,
+	}
+
+	// Nothing would read the only chunk of an empty object, so check it here
+	if d.size == 0 {
+		if err := d.load(0); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// readDataKey reads the wrapped data key and the chunk size that follow the
+// key id in a header, returning the data key, the chunk size and how many
+// bytes it read
+func (s *Encrypted) readDataKey(object io.Reader, magic, id string) ([]byte, int64, int64, error) {
+	kek, err := s.keys.aead(id)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	wrapped := make([]byte, kek.NonceSize()+dataKeySize+kek.Overhead()+4)
+	if _, err := io.ReadFull(object, wrapped); err != nil {
+		return nil, 0, 0, err
+	}
+	nonce, sealed := wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():len(wrapped)-4]
+	dataKey, err := kek.Open(nil, nonce, sealed, []byte(magic+id))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("the data key does not match key %s", id)
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(wrapped[len(wrapped)-4:]))
+	if chunkSize == 0 {
+		return nil, 0, 0, errors.New("invalid chunk size")
+	}
+	return dataKey, chunkSize, int64(len(wrapped)), nil
+}
+
+// errNotEncrypted is returned by readHeaderStart for objects without a header
+var errNotEncrypted = errors.New("object is not encrypted")
+
+// readHeaderStart reads the magic and key id of an object, returning how
+// many bytes it read
+func readHeaderStart(object io.Reader) (magic, id string, n int64, err error) {
+	start := make([]byte, len(encryptedMagic)+1)
+	if _, err := io.ReadFull(object, start); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", "", 0, errNotEncrypted
+		}
+		return "", "", 0, err
+	}
+	magic = string(start[:len(encryptedMagic)])
+	if magic != encryptedMagic && magic != unboundMagic {
+		return "", "", 0, errNotEncrypted
+	}
+
+	idBytes := make([]byte, start[len(start)-1])
+	if _, err := io.ReadFull(object, idBytes); err != nil {
+		return "", "", 0, err
+	}
+	return magic, string(idBytes), int64(len(start) + len(idBytes)), nil
+}
+
+// decryptingObject decrypts an object a chunk at a time as it is read
+type decryptingObject struct {
+	inner     io.ReadSeekCloser
+	aead      cipher.AEAD
+	ad        []byte // the object key, or nil for the first header version
+	headerLen int64
+	chunkSize int64
+	chunks    int64
+	size      int64 // of the plaintext
+
+	pos      int64 // in the plaintext
+	innerPos int64 // in the stored object, to skip needless seeks
+	loaded   int64 // index of the chunk in plain, or -1
+	plain    []byte
+	sealed   []byte
+}
+
+func (d *decryptingObject) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+
+	index := d.pos / d.chunkSize
+	if index != d.loaded {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain[d.pos-index*d.chunkSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+// load reads and opens chunk index
+func (d *decryptingObject) load(index int64) error {
+	offset := d.headerLen + index*(d.chunkSize+int64(d.aead.Overhead()))
+	if offset != d.innerPos {
+		if _, err := d.inner.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		d.innerPos = offset
+	}
+
+	last := index == d.chunks-1
+	length := d.chunkSize + int64(d.aead.Overhead())
+	if last {
+		length = d.size - index*d.chunkSize + int64(d.aead.Overhead())
+	}
+	if int64(cap(d.sealed)) < length {
+		d.sealed = make([]byte, length)
+	}
+	d.sealed = d.sealed[:length]
+	if _, err := io.ReadFull(d.inner, d.sealed); err != nil {
+		d.innerPos = -1
+		return err
+	}
+	d.innerPos += length
+
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(index, last), d.sealed, d.ad)
+	if err != nil {
+		d.loaded = -1
+		return fmt.Errorf("chunk %d of the object is corrupt", index)
+	}
+	d.plain, d.loaded = plain, index
+	return nil
+}
+
+func (d *decryptingObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decryptingObject) Close() error {
+	return d.inner.Close()
+}
+
+// Delete removes an object
+func (s *Encrypted) Delete(ctx context.Context, key string) error {
+	return s.inner.Delete(ctx, key)
+}
+
+// KeyID returns the id of the key an object is encrypted with, or "" if it
+// was stored unencrypted
+func (s *Encrypted) KeyID(ctx context.Context, key string) (string, error) {
+	object, err := s.inner.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	_, id, _, err := readHeaderStart(object)
+	if errors.Is(err, errNotEncrypted) {
+		return "", nil
+	}
+	return id, err
+}
+
+// Rekey moves an object to the primary key and reports whether it needed
+// it. Only the header of an encrypted object changes: its data key is
+// wrapped again under the primary key, and the sealed chunks are copied as
+// they are. Objects stored unencrypted, or with the first header version,
+// are encrypted afresh as they are read. Either way the backend reads the
+// whole new object before it replaces the old one.
+func (s *Encrypted) Rekey(ctx context.Context, key string) (bool, error) {
+	object, err := s.inner.Open(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer object.Close()
+
+	magic, id, rest, err := readHeaderStart(object)
+	if errors.Is(err, errNotEncrypted) || (err == nil && magic == unboundMagic) {
+		return true, s.encryptAgain(ctx, key, object)
+	}
+	if err != nil || id == s.keys.Primary() {
+		return false, err
+	}
+
+	dataKey, chunkSize, wrappedLen, err := s.readDataKey(object, magic, id)
+	if err != nil {
+		return false, fmt.Errorf("cannot decrypt %s: %w", key, err)
+	}
+	header, err := s.header(dataKey, int(chunkSize))
+	if err != nil {
+		return false, err
+	}
+
+	total, err := object.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	if _, err := object.Seek(rest+wrappedLen, io.SeekStart); err != nil {
+		return false, err
+	}
+	body := total - rest - wrappedLen
+	return true, s.inner.Put(ctx, key, io.MultiReader(bytes.NewReader(header), object), int64(len(header))+body)
+}
+
+// encryptAgain stores an object read from the inner storage again, encrypted
+// under the primary key and bound to its key
+func (s *Encrypted) encryptAgain(ctx context.Context, key string, object io.ReadSeekCloser) error {
+	plain, err := s.decrypt(object, key)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt %s: %w", key, err)
+	}
+	size, err := plain.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := plain.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.Put(ctx, key, plain, size)
+}
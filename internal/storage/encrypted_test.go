@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testKey returns a keyring entry for a random key
+func testKey(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestEncrypted(t *testing.T, keys string) (*Encrypted, *Filesystem) {
+	t.Helper()
+	inner, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := ParseKeyring(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncrypted(inner, keyring), inner
+}
+
+func TestEncrypted(t *testing.T) {
+	s, inner := newTestEncrypted(t, testKey(t, "k1"))
+	testStorage(t, s)
+
+	// Nothing readable reaches the inner storage
+	ctx := context.Background()
+	if err := s.Put(ctx, "secret", strings.NewReader("Ada Lovelace, BSc"), 17); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ReadAll(ctx, inner, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("Lovelace")) {
+		t.Error("expected the stored object to be encrypted")
+	}
+}
+
+func TestEncryptedChunks(t *testing.T) {
+	s, _ := newTestEncrypted(t, testKey(t, "k1"))
+	s.chunkSize = 16
+	ctx := context.Background()
+
+	for _, size := range []int{0, 1, 15, 16, 17, 48, 53} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		if err := s.Put(ctx, "object", bytes.NewReader(plain), int64(size)); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		object, err := s.Open(ctx, "object")
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		got, err := io.ReadAll(object)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: contents do not round trip (%v)", size, err)
+		}
+
+		// Every offset can be read on its own, as range requests do
+		for offset := 0; offset < size; offset += 7 {
+			object.Seek(int64(offset), io.SeekStart)
+			got, err := io.ReadAll(object)
+			if err != nil || !bytes.Equal(got, plain[offset:]) {
+				t.Errorf("size %d: reading from %d gave the wrong contents (%v)", size, offset, err)
+			}
+		}
+		if end, _ := object.Seek(0, io.SeekEnd); end != int64(size) {
+			t.Errorf("size %d: expected the end at %d, got %d", size, size, end)
+		}
+		object.Close()
+	}
+
+	// A reader that runs short fails the put
+	if err := s.Put(ctx, "short", strings.NewReader("abc"), 5); err == nil {
+		t.Error("expected a short reader to fail")
+	}
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	s, inner := newTestEncrypted(t, testKey(t, "k1"))
+	s.chunkSize = 16
+	ctx := context.Background()
+	plain := strings.Repeat("diploma ", 8)
+	if err := s.Put(ctx, "object", strings.NewReader(plain), int64(len(plain))); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := ReadAll(ctx, inner, "object")
+
+	// The same contents stored under another key
+	if err := s.Put(ctx, "other", strings.NewReader(plain), int64(len(plain))); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := ReadAll(ctx, inner, "other")
+
+	tampered := map[string][]byte{
+		"another object": other,
+		"flipped byte":   append(append([]byte{}, stored[:len(stored)-20]...), append([]byte{stored[len(stored)-20] ^ 1}, stored[len(stored)-19:]...)...),
+		"cut at chunk":   stored[:len(stored)-(16+16)],
+		"cut short":      stored[:len(stored)-5],
+	}
+	for name, data := range tampered {
+		inner.Put(ctx, "object", bytes.NewReader(data), int64(len(data)))
+		object, err := s.Open(ctx, "object")
+		if err == nil {
+			_, err = io.ReadAll(object)
+			object.Close()
+		}
+		if err == nil {
+			t.Errorf("%s: expected reading to fail", name)
+		}
+	}
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	oldKey, newKey := testKey(t, "2024"), testKey(t, "2025")
+	s, inner := newTestEncrypted(t, oldKey)
+	ctx := context.Background()
+	if err := s.Put(ctx, "object", strings.NewReader("workbook"), 8); err != nil {
+		t.Fatal(err)
+	}
+	// Stored before encryption was turned on
+	if err := inner.Put(ctx, "plain", strings.NewReader("legacy"), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	// A keyring without the old key cannot read the object
+	other := NewEncrypted(inner, mustKeyring(t, newKey))
+	if _, err := other.Open(ctx, "object"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+
+	// Rekeying rewrites the header and leaves the sealed chunks alone
+	stored, _ := ReadAll(ctx, inner, "object")
+	chunks := stored[len(stored)-(8+16):]
+
+	// With the new key first, old objects still read and rekey moves them over
+	rotated := NewEncrypted(inner, mustKeyring(t, newKey+"\n"+oldKey))
+	for _, key := range []string{"object", "plain"} {
+		before, err := ReadAll(ctx, rotated, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed, err := rotated.Rekey(ctx, key)
+		if err != nil || !changed {
+			t.Fatalf("%s: expected the object to be rekeyed, got %v, %v", key, changed, err)
+		}
+		if id, _ := rotated.KeyID(ctx, key); id != "2025" {
+			t.Errorf("%s: expected key 2025 after rekeying, got %q", key, id)
+		}
+		if after, _ := ReadAll(ctx, rotated, key); !bytes.Equal(after, before) {
+			t.Errorf("%s: contents changed from %q to %q", key, before, after)
+		}
+		if changed, _ := rotated.Rekey(ctx, key); changed {
+			t.Errorf("%s: expected a second rekey to do nothing", key)
+		}
+	}
+	if stored, _ := ReadAll(ctx, inner, "object"); !bytes.HasSuffix(stored, chunks) {
+		t.Error("expected rekeying to keep the sealed chunks")
+	}
+}
+
+// putUnbound stores plain in one chunk as the first header version did,
+// sealed without the object key
+func putUnbound(t *testing.T, s *Encrypted, key, plain string) {
+	t.Helper()
+	id := s.keys.Primary()
+	kek, err := s.keys.aead(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey := make([]byte, dataKeySize)
+	nonce := make([]byte, kek.NonceSize())
+	rand.Read(dataKey)
+	rand.Read(nonce)
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var object bytes.Buffer
+	object.WriteString(unboundMagic)
+	object.WriteByte(byte(len(id)))
+	object.WriteString(id)
+	object.Write(nonce)
+	object.Write(kek.Seal(nil, nonce, dataKey, []byte(unboundMagic+id)))
+	binary.Write(&object, binary.BigEndian, uint32(s.chunkSize))
+	object.Write(aead.Seal(nil, chunkNonce(0, true), []byte(plain), nil))
+	if err := s.inner.Put(context.Background(), key, &object, int64(object.Len())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedUnbound(t *testing.T) {
+	s, inner := newTestEncrypted(t, testKey(t, "k1"))
+	ctx := context.Background()
+	putUnbound(t, s, "object", "workbook")
+
+	if got, err := ReadAll(ctx, s, "object"); err != nil || string(got) != "workbook" {
+		t.Fatalf("expected the first header version to read, got %q, %v", got, err)
+	}
+
+	// Rekey binds the chunks to the object even under the primary key
+	if changed, err := s.Rekey(ctx, "object"); err != nil || !changed {
+		t.Fatalf("expected the object to be encrypted again, got %v, %v", changed, err)
+	}
+	stored, _ := ReadAll(ctx, inner, "object")
+	if !bytes.HasPrefix(stored, []byte(encryptedMagic)) {
+		t.Error("expected the current header version after rekeying")
+	}
+	if got, err := ReadAll(ctx, s, "object"); err != nil || string(got) != "workbook" {
+		t.Errorf("expected the contents to survive, got %q, %v", got, err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	k1, k2 := testKey(t, "k1"), testKey(t, "k2")
+	keyring, err := ParseKeyring("# newest first\n" + k2 + "\n" + k1 + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Primary() != "k2" {
+		t.Errorf("expected k2 to be the primary key, got %q", keyring.Primary())
+	}
+	if keyring, err := ParseKeyring(k1 + "," + k2); err != nil || keyring.Primary() != "k1" {
+		t.Errorf("expected comma separated keys to parse, got %v", err)
+	}
+
+	for _, bad := range []string{"", "k1", "k1:short", k1 + "\n" + k1, ":" + strings.TrimPrefix(k1, "k1:")} {
+		if _, err := ParseKeyring(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func mustKeyring(t *testing.T, text string) *Keyring {
+	t.Helper()
+	keyring, err := ParseKeyring(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Filesystem stores objects as files under a root directory. Every process
+// that reads or writes files must see the same directory.
+type Filesystem struct {
+	root string
+}
+
+// NewFilesystem stores objects under root, creating it if needed
+func NewFilesystem(root string) (*Filesystem, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Filesystem{root: root}, nil
+}
+
+// path returns where the object stored under key lives
+func (s *Filesystem) path(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if clean == "" || clean != key || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// Put writes the object to a temporary file and renames it into place, so
+// readers never see part of an object
+func (s *Filesystem) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("expected %d bytes for %s, got %d", size, key, written)
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Open opens the file of an object
+func (s *Filesystem) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes the file of an object
+func (s *Filesystem) Delete(ctx context.Context, key string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config locates a bucket on S3 or an S3-compatible server such as MinIO
+type S3Config struct {
+	Endpoint  string // host and port, e.g. s3.amazonaws.com or minio:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// s3ConfigFromEnv reads the S3_* settings
+func s3ConfigFromEnv() (S3Config, error) {
+	secretKey, err := secretFromEnv("S3_SECRET_KEY")
+	if err != nil {
+		return S3Config{}, err
+	}
+	useSSL, err := strconv.ParseBool(envOr("S3_USE_SSL", "true"))
+	if err != nil {
+		return S3Config{}, fmt.Errorf("S3_USE_SSL must be true or false: %v", err)
+	}
+
+	cfg := S3Config{
+		Endpoint:  envOr("S3_ENDPOINT", "s3.amazonaws.com"),
+		Bucket:    envOr("S3_BUCKET", ""),
+		Region:    envOr("S3_REGION", ""),
+		AccessKey: envOr("S3_ACCESS_KEY", ""),
+		SecretKey: secretKey,
+		UseSSL:    useSSL,
+	}
+	if cfg.Bucket == "" {
+		return S3Config{}, fmt.Errorf("S3_BUCKET is required when STORAGE_BACKEND is %q", BackendS3)
+	}
+	return cfg, nil
+}
+
+// S3 stores objects in an S3 bucket
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3 connects to the bucket, creating it if it does not exist
+func NewS3(ctx context.Context, cfg S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+	return &S3{client: client, bucket: cfg.Bucket}, nil
+}
+
+// Put uploads an object, in parts when it is large
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+// Open reads an object. Data is fetched as it is read, and seeking starts
+// a new ranged request.
+func (s *S3) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject does not contact the server, so check the object is there
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+// Delete removes an object
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
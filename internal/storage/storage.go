@@ -0,0 +1,141 @@
+// Package storage keeps the contents of uploaded and generated files outside
+// the database, which only records their details and object keys
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"pawprintpublic/internal/models"
+	"strings"
+)
+
+// Backends that can be chosen with STORAGE_BACKEND
+const (
+	BackendFilesystem = "filesystem"
+	BackendS3         = "s3"
+)
+
+// ErrNotFound is returned when no object is stored under a key
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores file contents under keys such as tasks/<id>/<name>
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any object there
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open reads the object stored under key. The object can seek, so
+	// downloads can be served in ranges.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the object stored under key; a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// FileStore is the part of the repository that records stored files
+type FileStore interface {
+	InsertFile(f models.File) error
+}
+
+// Key is the object key the contents of a task's file are stored under
+func Key(taskID, fileName string) string {
+	return "tasks/" + taskID + "/" + fileName
+}
+
+// Save stores the contents of a file and then records it with their
+// checksum. Nothing is recorded if the contents cannot be stored.
+func Save(ctx context.Context, blobs Storage, store FileStore, f models.File, r io.Reader, size int64) error {
+	f.ObjectKey = Key(f.TaskID, f.FileName)
+	f.Size = size
+	hash := sha256.New()
+	if err := blobs.Put(ctx, f.ObjectKey, io.TeeReader(r, hash), size); err != nil {
+		return fmt.Errorf("failed to store %s: %w", f.FileName, err)
+	}
+	f.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if err := store.InsertFile(f); err != nil {
+		// Do not leave contents behind that no record points to
+		_ = blobs.Delete(ctx, f.ObjectKey)
+		return err
+	}
+	return nil
+}
+
+// SaveFile stores the file at path, as Save does
+func SaveFile(ctx context.Context, blobs Storage, store FileStore, f models.File, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return Save(ctx, blobs, store, f, file, info.Size())
+}
+
+// ReadAll reads a whole object into memory, for files that are read whole
+// anyway such as uploaded workbooks
+func ReadAll(ctx context.Context, blobs Storage, key string) ([]byte, error) {
+	object, err := blobs.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return io.ReadAll(object)
+}
+
+// FromEnv opens the backend chosen with STORAGE_BACKEND, which defaults to
+// the filesystem under STORAGE_PATH (./data/files). Objects are encrypted
+// when STORAGE_ENCRYPTION_KEYS or STORAGE_ENCRYPTION_KEYS_FILE is set.
+func FromEnv(ctx context.Context) (Storage, error) {
+	backend, err := backendFromEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := KeyringFromEnv()
+	if err != nil || keys == nil {
+		return backend, err
+	}
+	return NewEncrypted(backend, keys), nil
+}
+
+// backendFromEnv opens the backend chosen with STORAGE_BACKEND
+func backendFromEnv(ctx context.Context) (Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", BackendFilesystem:
+		return NewFilesystem(envOr("STORAGE_PATH", "./data/files"))
+	case BackendS3:
+		cfg, err := s3ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewS3(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("STORAGE_BACKEND must be %q or %q, got %q", BackendFilesystem, BackendS3, backend)
+	}
+}
+
+// envOr returns the value of an environment variable, or fallback when unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// secretFromEnv reads a secret from key, or from the file named by key_FILE
+func secretFromEnv(key string) (string, error) {
+	if file := os.Getenv(key + "_FILE"); file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_FILE: %v", key, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	return os.Getenv(key), nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"pawprintpublic/internal/models"
+	"strings"
+	"testing"
+)
+
+// testStorage checks the behaviour every backend shares
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	key := Key("task-1", "task-1.pdf")
+
+	if err := s.Put(ctx, key, strings.NewReader("%PDF-1.7 diplomas"), 17); err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := object.Seek(0, io.SeekEnd); err != nil || size != 17 {
+		t.Errorf("expected seeking to the end to give the size 17, got %d (%v)", size, err)
+	}
+	if _, err := object.Seek(9, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(object)
+	object.Close()
+	if err != nil || string(rest) != "diplomas" {
+		t.Errorf("expected to read from the seek offset, got %q (%v)", rest, err)
+	}
+
+	// Putting again replaces the object
+	if err := s.Put(ctx, key, strings.NewReader("new"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadAll(ctx, s, key); string(data) != "new" {
+		t.Errorf("expected the replaced contents, got %q", data)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deleting, got %v", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("expected deleting a missing object to succeed, got %v", err)
+	}
+}
+
+func TestFilesystem(t *testing.T) {
+	s, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	for _, key := range []string{"", "../outside", "tasks/../../outside", "/etc/passwd"} {
+		if err := s.Put(context.Background(), key, strings.NewReader("x"), 1); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+
+	if err := s.Put(context.Background(), "short", strings.NewReader("abc"), 5); err == nil {
+		t.Error("expected a short write to fail")
+	}
+	if _, err := s.Open(context.Background(), "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a failed put to leave nothing behind, got %v", err)
+	}
+}
+
+// TestS3 runs against an S3-compatible server such as a local MinIO:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	S3_TEST_ENDPOINT=localhost:9000 go test ./internal/storage
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+
+	s, err := NewS3(context.Background(), S3Config{
+		Endpoint:  endpoint,
+		Bucket:    envOr("S3_TEST_BUCKET", "pawprint-test"),
+		AccessKey: envOr("S3_TEST_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("S3_TEST_SECRET_KEY", "minioadmin"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	// Decrypting seeks within objects, which S3 serves as ranged requests
+	encrypted := NewEncrypted(s, mustKeyring(t, testKey(t, "k1")))
+	encrypted.chunkSize = 4
+	testStorage(t, encrypted)
+}
+
+// failingStore is a FileStore that cannot record files
+type failingStore struct{}
+
+func (failingStore) InsertFile(f models.File) error {
+	return errors.New("database is down")
+}
+
+func TestSaveRemovesUnrecordedContents(t *testing.T) {
+	s, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := models.File{TaskID: "task-1", FileName: "task-1.xlsx", FileType: "xlsx"}
+	if err := Save(context.Background(), s, failingStore{}, f, strings.NewReader("workbook"), 8); err == nil {
+		t.Fatal("expected Save to fail when the file cannot be recorded")
+	}
+	if _, err := s.Open(context.Background(), Key(f.TaskID, f.FileName)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the stored contents to be removed, got %v", err)
+	}
+}
+
+// recordingStore is a FileStore that keeps the last recorded file
+type recordingStore struct {
+	file models.File
+}
+
+func (s *recordingStore) InsertFile(f models.File) error {
+	s.file = f
+	return nil
+}
+
+func TestSaveRecordsChecksum(t *testing.T) {
+	s, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &recordingStore{}
+	f := models.File{TaskID: "task-1", FileName: "task-1.pdf", FileType: "pdf"}
+	if err := Save(context.Background(), s, store, f, strings.NewReader("test"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if store.file.ObjectKey != "tasks/task-1/task-1.pdf" || store.file.Size != 4 {
+		t.Errorf("unexpected record %+v", store.file)
+	}
+	if store.file.Checksum != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Errorf("expected the SHA-256 of the contents, got %q", store.file.Checksum)
+	}
+}